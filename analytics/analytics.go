@@ -0,0 +1,239 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/kafka"
+)
+
+const (
+	DefaultBufferSize = 10000
+	// 退出时等待缓冲中的事件发出的时间
+	DefaultCloseTimeout = 5 * time.Second
+
+	DropBufferFull      = "buffer_full"
+	DropSendError       = "send_error"
+	DropSinkUnavailable = "sink_unavailable"
+)
+
+var (
+	eventsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "analytics",
+		Subsystem: "events",
+		Name:      "published_count",
+		Help:      "The total number of completion events handed to the sink",
+	}, []string{"sink"})
+
+	eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "analytics",
+		Subsystem: "events",
+		Name:      "dropped_count",
+		Help:      "The total number of completion events dropped, reason is buffer_full, send_error or sink_unavailable",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsCount, eventsDropped)
+}
+
+type Publisher interface {
+	// Publish 不阻塞，缓冲已满时丢弃事件并计数
+	Publish(ctx context.Context, ev Event)
+}
+
+// sink 事件的去向，只在 pipeline 的 goroutine 中调用
+type sink interface {
+	name() string
+	write(ev Event, line []byte)
+	close()
+}
+
+var pipeline struct {
+	events chan Event
+	stop   chan struct{}
+	done   chan struct{}
+	sink   sink
+}
+
+// InitAnalytics 配置了 kafka.brokers 和 analytics.topic 时发布到 kafka，
+// 否则（或 kafka 连接失败时）配置了 analytics.file 则按行写入文件，都没有时不发布；
+// 文件打开失败时丢弃事件并计数
+func InitAnalytics() {
+	var s sink
+	if topic := config.GetStr("analytics.topic"); topic != "" && kafka.Enabled() {
+		producer, err := newKafkaSink(topic)
+		if err == nil {
+			s = producer
+		} else {
+			// 统计事件不影响服务启动，kafka 不可用时退回到文件
+			log.Err("analytics: init kafka producer error: " + err.Error())
+		}
+	}
+	if path := config.GetStr("analytics.file"); s == nil && path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err == nil {
+			s = fileSink{f}
+		} else {
+			log.Err("analytics: open analytics file error: " + err.Error())
+			s = discardSink{}
+		}
+	}
+	if s == nil {
+		return
+	}
+	pipeline.events = make(chan Event, config.GetIntDft("analytics.buffer_size", DefaultBufferSize))
+	pipeline.stop = make(chan struct{})
+	pipeline.done = make(chan struct{})
+	pipeline.sink = s
+	go run()
+}
+
+// CloseAnalytics 发出缓冲中的事件，最多等待 DefaultCloseTimeout
+func CloseAnalytics() {
+	if pipeline.sink == nil {
+		return
+	}
+	close(pipeline.stop)
+	select {
+	case <-pipeline.done:
+	case <-time.After(DefaultCloseTimeout):
+		log.Err("analytics: timeout flushing events")
+	}
+}
+
+func run() {
+	defer close(pipeline.done)
+	defer pipeline.sink.close()
+	for {
+		select {
+		case ev := <-pipeline.events:
+			write(ev)
+		case <-pipeline.stop:
+			for {
+				select {
+				case ev := <-pipeline.events:
+					write(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func write(ev Event) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		eventsDropped.WithLabelValues(DropSendError).Inc()
+		return
+	}
+	pipeline.sink.write(ev, line)
+	if _, ok := pipeline.sink.(discardSink); !ok {
+		eventsCount.WithLabelValues(pipeline.sink.name()).Inc()
+	}
+}
+
+type publisher struct {
+}
+
+func NewPublisher() Publisher {
+	return new(publisher)
+}
+
+func (p publisher) Publish(ctx context.Context, ev Event) {
+	if pipeline.sink == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.RequestID = log.ParseRequestID(ctx)
+	select {
+	case pipeline.events <- ev:
+	default:
+		eventsDropped.WithLabelValues(DropBufferFull).Inc()
+	}
+}
+
+// fileSink 本地运行时使用，按行写入
+type fileSink struct {
+	f *os.File
+}
+
+func (s fileSink) name() string { return "file" }
+
+func (s fileSink) write(_ Event, line []byte) {
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		eventsDropped.WithLabelValues(DropSendError).Inc()
+	}
+}
+
+func (s fileSink) close() {
+	s.f.Close()
+}
+
+// discardSink 配置的去向不可用时使用，只计数不发布
+type discardSink struct{}
+
+func (discardSink) name() string { return "discard" }
+
+func (discardSink) write(Event, []byte) {
+	eventsDropped.WithLabelValues(DropSinkUnavailable).Inc()
+}
+
+func (discardSink) close() {}
+
+// kafkaSink 异步发送，以用户 ID 为 key 保证同一用户的事件有序
+type kafkaSink struct {
+	topic    string
+	producer sarama.AsyncProducer
+	done     chan struct{}
+}
+
+func newKafkaSink(topic string) (*kafkaSink, error) {
+	cfg, err := kafka.NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	cfg.Producer.Flush.Frequency = 500 * time.Millisecond
+	cfg.Producer.Return.Errors = true
+	producer, err := sarama.NewAsyncProducer(kafka.Brokers(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &kafkaSink{topic: topic, producer: producer, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for err := range producer.Errors() {
+			eventsDropped.WithLabelValues(DropSendError).Inc()
+			log.WithCtxFields(context.Background(), log.Fields{"error": err.Err}).Warnln("analytics: send event error")
+		}
+	}()
+	return s, nil
+}
+
+func (s *kafkaSink) name() string { return "kafka" }
+
+func (s *kafkaSink) write(ev Event, line []byte) {
+	s.producer.Input() <- &sarama.ProducerMessage{
+		Topic: s.topic,
+		Key:   sarama.StringEncoder(strconv.FormatInt(ev.UserID, 10)),
+		Value: sarama.ByteEncoder(line),
+	}
+}
+
+func (s *kafkaSink) close() {
+	s.producer.AsyncClose()
+	<-s.done
+}
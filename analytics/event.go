@@ -0,0 +1,54 @@
+package analytics
+
+import (
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+)
+
+const (
+	KindChat       = "chat"
+	KindChatStream = "chat_stream"
+
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Event 一次生成结束时发布的事件，Prompt 和 Completions 只在 IncludeContent 允许时填充
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	UserID    int64     `json:"user_id"`
+	Caller    string    `json:"caller,omitempty"`
+	Kind      string    `json:"kind"`
+	Model     string    `json:"model"`
+	Status    string    `json:"status"`
+	ErrorCode int       `json:"error_code,omitempty"`
+
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	ImageTokens      int `json:"image_tokens,omitempty"`
+	// stream 模式下上游不返回用量，prompt 按字数、completion 按分片数估算
+	UsageEstimated bool `json:"usage_estimated,omitempty"`
+	// 美元，模型没有配置价格时为空
+	Cost *float64 `json:"cost,omitempty"`
+
+	LatencyMs    int64    `json:"latency_ms"`
+	FirstTokenMs int64    `json:"first_token_ms,omitempty"`
+	FinishReason []string `json:"finish_reason,omitempty"`
+	// 本地词库中送审或拒绝的分类，以及上游审核超过阈值的分类，仅替换的词不计入
+	ModerationFlags []string `json:"moderation_flags,omitempty"`
+
+	// 已经过敏感词和 PII 替换的内容
+	Prompt      []models.ChatGPTMessage `json:"prompt,omitempty"`
+	Completions []string                `json:"completions,omitempty"`
+}
+
+// IncludeContent analytics.include_content 开启且没有审核命中时才包含对话内容，
+// 命中审核的内容只进入送审记录
+func IncludeContent(ev *Event) bool {
+	return config.GetBool("analytics.include_content", false) && len(ev.ModerationFlags) == 0
+}
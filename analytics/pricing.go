@@ -0,0 +1,71 @@
+package analytics
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+// Price 每 1K token 的美元价格
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// defaultPrices 按模型名前缀匹配，analytics.prices 中的同名配置覆盖
+var defaultPrices = map[string]Price{
+	"gpt-3.5-turbo": {0.0015, 0.002},
+	"gpt-4":         {0.03, 0.06},
+	"gpt-4-32k":     {0.06, 0.12},
+	"gpt-4-turbo":   {0.01, 0.03},
+	"gpt-4o":        {0.005, 0.015},
+	"gpt-4o-mini":   {0.00015, 0.0006},
+}
+
+var (
+	pricesOnce sync.Once
+	prices     map[string]Price
+)
+
+// loadPrices 解析 analytics.prices，格式如 "gpt-4o:0.005/0.015,my-model:0.001/0.002"
+func loadPrices() {
+	prices = make(map[string]Price, len(defaultPrices))
+	for model, price := range defaultPrices {
+		prices[model] = price
+	}
+	for _, item := range strings.Split(config.GetStr("analytics.prices"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		pc := strings.SplitN(kv[1], "/", 2)
+		if len(pc) != 2 {
+			continue
+		}
+		prompt, err1 := strconv.ParseFloat(strings.TrimSpace(pc[0]), 64)
+		completion, err2 := strconv.ParseFloat(strings.TrimSpace(pc[1]), 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		prices[strings.TrimSpace(kv[0])] = Price{prompt, completion}
+	}
+}
+
+// Cost 按最长前缀匹配模型的价格，没有匹配时返回 nil
+func Cost(model string, promptTokens, completionTokens int) *float64 {
+	pricesOnce.Do(loadPrices)
+	matched := ""
+	for prefix := range prices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+		}
+	}
+	if matched == "" {
+		return nil
+	}
+	price := prices[matched]
+	cost := (float64(promptTokens)*price.Prompt + float64(completionTokens)*price.Completion) / 1000
+	return &cost
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+const (
+	// HeaderCaller 网关注入的调用方，只在 auth.trust_caller_header 为 true 时使用，
+	// 此时网关必须在入口删除客户端自带的同名请求头
+	HeaderCaller = "X-Caller"
+	// HeaderCallerToken 签名的调用方令牌，见 SignCaller
+	HeaderCallerToken = "X-Caller-Token"
+)
+
+var (
+	ErrInvalidCallerToken = errors.New("invalid caller token")
+	ErrCallerTokenExpired = errors.New("caller token expired")
+
+	callerPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+// ValidCallerName 调用方名称只能包含字母、数字、下划线和连字符
+func ValidCallerName(name string) bool {
+	return callerPattern.MatchString(name)
+}
+
+// SignCaller 令牌为 "调用方.过期时间.签名"，过期时间为 unix 秒，
+// 签名为 hex(HMAC-SHA256(secret, "调用方.过期时间"))
+func SignCaller(secret, name string, expires time.Time) string {
+	payload := name + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sign(secret, payload)
+}
+
+// VerifyCaller 校验令牌，返回其中的调用方
+func VerifyCaller(secret, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if secret == "" || len(parts) != 3 || !ValidCallerName(parts[0]) {
+		return "", ErrInvalidCallerToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidCallerToken
+	}
+	expected := sign(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return "", ErrInvalidCallerToken
+	}
+	if now.Unix() >= expires {
+		return "", ErrCallerTokenExpired
+	}
+	return parts[0], nil
+}
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Secret 签发和校验调用方令牌的密钥，为空时不接受令牌
+func Secret() string {
+	return config.GetStr("auth.caller_secret")
+}
+
+// Caller 请求的调用方，用于工具权限和批量请求的并发限制。
+// 带令牌时校验令牌，令牌无效返回错误；否则只有 auth.trust_caller_header 为 true 时才使用 X-Caller，
+// 都没有时为空，只能使用公开的工具
+func Caller(header func(string) string) (string, error) {
+	if token := header(HeaderCallerToken); token != "" {
+		return VerifyCaller(Secret(), token, time.Now())
+	}
+	if config.GetBool("auth.trust_caller_header", false) {
+		if name := header(HeaderCaller); ValidCallerName(name) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,146 @@
+package bench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"chatgpt_server/models"
+)
+
+// MaxRPS 固定速率的上限，再大时请求间隔不足 1µs
+const MaxRPS = 1e6
+
+// Options 压测参数，RPS > 0 时按固定速率发起请求，否则按 Concurrency 个并发循环请求
+type Options struct {
+	Target      string
+	Stream      bool
+	RPS         float64
+	Concurrency int
+	Duration    time.Duration
+	// 总请求数，0 表示只受 Duration 限制
+	Requests int
+	Timeout  time.Duration
+	Prompts  []models.ReqChatGPTFromCient
+}
+
+// promptLine prompts 文件的一行，可以是完整的请求或只有 prompt
+type promptLine struct {
+	models.ReqChatGPTFromCient
+	Prompt string `json:"prompt"`
+}
+
+// LoadPrompts 读取 jsonl 文件，每行为 {"messages":[...]} 或 {"prompt":"..."}
+func LoadPrompts(file string) ([]models.ReqChatGPTFromCient, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var prompts []models.ReqChatGPTFromCient
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		p := new(promptLine)
+		if err := json.Unmarshal([]byte(line), p); err != nil {
+			return nil, err
+		}
+		if p.Prompt != "" {
+			p.Message = append(p.Message, models.ChatGPTMessage{Role: "user", Content: p.Prompt})
+		}
+		prompts = append(prompts, p.ReqChatGPTFromCient)
+	}
+	return prompts, scanner.Err()
+}
+
+// Run 执行压测直到达到请求数、时长或 ctx 取消
+func Run(ctx context.Context, opts Options) *Report {
+	// 到达时长后不再发起新请求，已发出的请求继续等待结果
+	reqCtx := ctx
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	client := newClient(opts)
+	results := make(chan Result, opts.Concurrency*2)
+	report := newReport()
+
+	collected := make(chan struct{})
+	go func() {
+		for res := range results {
+			report.add(res)
+		}
+		close(collected)
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		seq  int
+		lock sync.Mutex
+	)
+	// next 取下一个请求，达到总数时返回 false
+	next := func() (models.ReqChatGPTFromCient, bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		if opts.Requests > 0 && seq >= opts.Requests {
+			return models.ReqChatGPTFromCient{}, false
+		}
+		req := opts.Prompts[seq%len(opts.Prompts)]
+		seq++
+		return req, true
+	}
+
+	start := time.Now()
+	if opts.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.RPS))
+		defer ticker.Stop()
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+				req, ok := next()
+				if !ok {
+					break loop
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results <- client.do(reqCtx, req)
+				}()
+			}
+		}
+	} else {
+		for i := 0; i < opts.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					req, ok := next()
+					if !ok {
+						return
+					}
+					results <- client.do(reqCtx, req)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(results)
+	<-collected
+	report.Elapsed = time.Since(start)
+	return report
+}
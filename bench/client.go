@@ -0,0 +1,156 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatgpt_server/models"
+)
+
+const DefaultTimeout = 60 * time.Second
+
+// Result 单个请求的结果，Err 为错误分类，成功时为空
+type Result struct {
+	Latency time.Duration
+	// 首个内容分片的耗时，只在 stream 模式下有值
+	TTFT   time.Duration
+	Tokens int
+	Err    string
+}
+
+type client struct {
+	http   *http.Client
+	url    string
+	stream bool
+}
+
+func newClient(opts Options) *client {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	path := "/chatGPT/sendMsg"
+	if opts.Stream {
+		path = "/chatGPT/sendMsgStream"
+	}
+	return &client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: opts.Concurrency,
+			},
+			Timeout: timeout,
+		},
+		url:    strings.TrimRight(opts.Target, "/") + path,
+		stream: opts.Stream,
+	}
+}
+
+func classify(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	return "network"
+}
+
+// respJson 服务端统一的 json 返回
+type respJson struct {
+	Code int                `json:"code"`
+	Msg  string             `json:"msg"`
+	Data models.RespChatGPT `json:"data"`
+}
+
+func (c *client) do(ctx context.Context, req models.ReqChatGPTFromCient) (res Result) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Result{Err: "marshal"}
+	}
+	start := time.Now()
+	defer func() {
+		res.Latency = time.Since(start)
+	}()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return Result{Err: "request"}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return Result{Err: classify(err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return Result{Err: fmt.Sprintf("http_%d", resp.StatusCode)}
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		out := new(respJson)
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return Result{Err: "decode"}
+		}
+		if out.Code != 0 {
+			return Result{Err: fmt.Sprintf("code_%d", out.Code)}
+		}
+		return Result{Tokens: out.Data.Usage.CompletionTokens}
+	}
+	return c.readStream(resp.Body, start)
+}
+
+// streamEvent SSE 事件，出错时为 {"code","msg"}
+type streamEvent struct {
+	models.RespChatGPTChunk
+	Code int `json:"code"`
+}
+
+func (c *client) readStream(body io.Reader, start time.Time) Result {
+	res := Result{}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			return res
+		}
+		var event streamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			res.Err = "decode"
+			return res
+		}
+		if event.Code != 0 {
+			res.Err = fmt.Sprintf("code_%d", event.Code)
+			return res
+		}
+		for _, choice := range event.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if res.Tokens == 0 {
+				res.TTFT = time.Since(start)
+			}
+			res.Tokens++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		res.Err = classify(err)
+		return res
+	}
+	// 没有收到 [DONE]
+	res.Err = "stream_truncated"
+	return res
+}
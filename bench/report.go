@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+var percentiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+type Report struct {
+	Elapsed   time.Duration
+	Total     int
+	Succeeded int
+	Errors    map[string]int
+	Tokens    int
+	latencies []time.Duration
+	ttfts     []time.Duration
+}
+
+func newReport() *Report {
+	return &Report{Errors: map[string]int{}}
+}
+
+func (r *Report) add(res Result) {
+	r.Total++
+	if res.Err != "" {
+		r.Errors[res.Err]++
+		return
+	}
+	r.Succeeded++
+	r.Tokens += res.Tokens
+	r.latencies = append(r.latencies, res.Latency)
+	if res.TTFT > 0 {
+		r.ttfts = append(r.ttfts, res.TTFT)
+	}
+}
+
+// percentile durations 需已排序
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(durations)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return durations[idx]
+}
+
+func writeDistribution(w io.Writer, name string, durations []time.Duration) {
+	if len(durations) == 0 {
+		return
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	fmt.Fprintf(w, "%s:\n", name)
+	for _, p := range percentiles {
+		fmt.Fprintf(w, "  p%-4g %v\n", p*100, percentile(durations, p).Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "  max   %v\n", durations[len(durations)-1].Round(time.Millisecond))
+}
+
+func (r *Report) Print(w io.Writer) {
+	seconds := r.Elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	fmt.Fprintf(w, "duration:   %v\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "requests:   %d (%.2f/s)\n", r.Total, float64(r.Total)/seconds)
+	fmt.Fprintf(w, "succeeded:  %d\n", r.Succeeded)
+	fmt.Fprintf(w, "failed:     %d\n", r.Total-r.Succeeded)
+	if len(r.Errors) > 0 {
+		kinds := make([]string, 0, len(r.Errors))
+		for kind := range r.Errors {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "  %-16s %d\n", kind, r.Errors[kind])
+		}
+	}
+	fmt.Fprintf(w, "tokens:     %d (%.1f/s)\n", r.Tokens, float64(r.Tokens)/seconds)
+	writeDistribution(w, "latency", r.latencies)
+	writeDistribution(w, "ttft", r.ttfts)
+}
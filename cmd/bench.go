@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/bench"
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+	"chatgpt_server/routes"
+)
+
+func benchCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "bench",
+		Usage: "Load test /chatGPT/sendMsg or /chatGPT/sendMsgStream",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "target",
+				Value: "http://127.0.0.1:10100",
+				Usage: "server address, ignored with --mock-upstream",
+			},
+			&cli.BoolFlag{
+				Name:  "stream",
+				Usage: "use the stream endpoint",
+			},
+			&cli.Float64Flag{
+				Name:  "rps",
+				Usage: "requests per second (at most 1e6), closed loop with --concurrency when 0",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Value: 10,
+				Usage: "concurrent workers",
+			},
+			&cli.DurationFlag{
+				Name:  "duration",
+				Value: 30 * time.Second,
+				Usage: "test duration",
+			},
+			&cli.IntFlag{
+				Name:  "requests",
+				Usage: "total requests, unlimited when 0",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Value: bench.DefaultTimeout,
+				Usage: "timeout of each request",
+			},
+			&cli.StringFlag{
+				Name:  "prompts",
+				Usage: `jsonl file, each line {"messages":[...]} or {"prompt":"..."}`,
+			},
+			&cli.BoolFlag{
+				Name:  "mock-upstream",
+				Usage: "run the server in process against a local mock upstream",
+			},
+			&cli.DurationFlag{
+				Name:  "mock-latency",
+				Usage: "mock upstream latency before the first byte",
+			},
+			&cli.DurationFlag{
+				Name:  "mock-chunk-interval",
+				Value: 20 * time.Millisecond,
+				Usage: "mock upstream interval between stream chunks",
+			},
+			configFlag(),
+		},
+		Before: loadConfig,
+		Action: runBench,
+	}
+}
+
+func runBench(c *cli.Context) error {
+	opts := bench.Options{
+		Target:      c.String("target"),
+		Stream:      c.Bool("stream"),
+		RPS:         c.Float64("rps"),
+		Concurrency: c.Int("concurrency"),
+		Duration:    c.Duration("duration"),
+		Requests:    c.Int("requests"),
+		Timeout:     c.Duration("timeout"),
+	}
+	// 写成 !(a && b) 以同时拒绝 NaN
+	if !(opts.RPS >= 0 && opts.RPS <= bench.MaxRPS) {
+		return cli.Exit(fmt.Sprintf("rps must be between 0 and %g", bench.MaxRPS), 1)
+	}
+	if f := c.String("prompts"); f != "" {
+		prompts, err := bench.LoadPrompts(f)
+		if err != nil {
+			return err
+		}
+		opts.Prompts = prompts
+	}
+	if len(opts.Prompts) == 0 {
+		opts.Prompts = []models.ReqChatGPTFromCient{{
+			ReqChatGPT: models.ReqChatGPT{
+				Message: []models.ChatGPTMessage{{Role: "user", Content: "Hello"}},
+			},
+		}}
+	}
+	if c.Bool("mock-upstream") {
+		target, err := startMockServer(c.Duration("mock-latency"), c.Duration("mock-chunk-interval"))
+		if err != nil {
+			return err
+		}
+		opts.Target = target
+	}
+
+	fmt.Printf("bench %s stream=%v rps=%v concurrency=%d duration=%v\n",
+		opts.Target, opts.Stream, opts.RPS, opts.Concurrency, opts.Duration)
+	bench.Run(c.Context, opts).Print(os.Stdout)
+	return nil
+}
+
+// startMockServer 使用 mock 上游在进程内启动服务，返回服务地址
+func startMockServer(latency, chunkInterval time.Duration) (string, error) {
+	config.Set("openai.provider", repos.ProviderMock)
+	config.Set("mock.latency_ms", latency.Milliseconds())
+	config.Set("mock.chunk_interval_ms", chunkInterval.Milliseconds())
+	initDeps()
+
+	engine := util.NewGin()
+	routes.RouteInit(engine)
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(server, engine)
+	return "http://" + server.Addr().String(), nil
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+const chatHelp = `commands:
+  /system <prompt>  set the system prompt and reset the conversation
+  /model <name>     switch model
+  /reset            clear the conversation
+  /exit             quit`
+
+func chatCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "chat",
+		Usage: "Interactive chat against the running server or the upstream directly",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "server",
+				Value: "http://127.0.0.1:10100",
+				Usage: "server address",
+			},
+			&cli.BoolFlag{
+				Name:  "direct",
+				Usage: "call the upstream in process instead of the server",
+			},
+			&cli.StringFlag{
+				Name:  "model",
+				Usage: "model name, server default when empty",
+			},
+			&cli.StringFlag{
+				Name:  "system",
+				Usage: "system prompt",
+			},
+			&cli.Int64Flag{
+				Name:  "user-id",
+				Usage: "user id sent with each request",
+			},
+			configFlag(),
+		},
+		Before: loadConfig,
+		Action: chatREPL,
+	}
+}
+
+// chatBackend 发送一轮对话，onDelta 收到第一个回答的增量内容，返回完整回答
+type chatBackend func(ctx context.Context, req models.ReqChatGPTFromCient, onDelta func(string)) (string, error)
+
+func chatREPL(c *cli.Context) error {
+	var backend chatBackend
+	if c.Bool("direct") {
+		initDeps()
+		backend = directBackend(services.NewChatGPT())
+	} else {
+		backend = serverBackend(strings.TrimRight(c.String("server"), "/"))
+	}
+
+	model := c.String("model")
+	system := c.String("system")
+	var history []models.ChatGPTMessage
+	reset := func() {
+		history = history[:0]
+		if system != "" {
+			history = append(history, models.ChatGPTMessage{Role: "system", Content: system})
+		}
+	}
+	reset()
+
+	fmt.Println(chatHelp)
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			cmd, arg, _ := strings.Cut(line, " ")
+			arg = strings.TrimSpace(arg)
+			switch cmd {
+			case "/system":
+				system = arg
+				reset()
+				fmt.Println("system prompt set, conversation reset")
+			case "/model":
+				model = arg
+				fmt.Println("model:", model)
+			case "/reset":
+				reset()
+				fmt.Println("conversation reset")
+			case "/exit", "/quit":
+				return nil
+			default:
+				fmt.Println(chatHelp)
+			}
+			continue
+		}
+
+		history = append(history, models.ChatGPTMessage{Role: "user", Content: line})
+		req := models.ReqChatGPTFromCient{
+			ReqChatGPT: models.ReqChatGPT{
+				Model:   model,
+				Message: append([]models.ChatGPTMessage(nil), history...),
+			},
+			UserID: c.Int64("user-id"),
+		}
+		answer, err := backend(c.Context, req, func(delta string) {
+			fmt.Print(delta)
+		})
+		fmt.Println()
+		if err != nil {
+			fmt.Println("error:", err)
+			// 失败的提问不计入上下文
+			history = history[:len(history)-1]
+			continue
+		}
+		history = append(history, models.ChatGPTMessage{Role: "assistant", Content: answer})
+	}
+}
+
+func directBackend(srv services.ChatGPT) chatBackend {
+	return func(ctx context.Context, req models.ReqChatGPTFromCient, onDelta func(string)) (string, error) {
+		var answer strings.Builder
+		err := srv.SendMsgStream(ctx, req, func(chunk *models.RespChatGPTChunk) error {
+			for _, choice := range chunk.Choices {
+				if choice.Index == 0 {
+					answer.WriteString(choice.Delta.Content)
+					onDelta(choice.Delta.Content)
+				}
+			}
+			return nil
+		})
+		return answer.String(), err
+	}
+}
+
+// streamEvent 服务端 SSE 事件，出错时为 {"code","msg"}
+type streamEvent struct {
+	models.RespChatGPTChunk
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func serverBackend(server string) chatBackend {
+	return func(ctx context.Context, req models.ReqChatGPTFromCient, onDelta func(string)) (string, error) {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return "", err
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", server+"/chatGPT/sendMsgStream", bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		// 开始输出前出错时服务端返回普通 json
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			var out struct {
+				Code int    `json:"code"`
+				Msg  string `json:"msg"`
+			}
+			if err := json.Unmarshal(bodyBytes, &out); err != nil {
+				return "", fmt.Errorf("unexpected response(%d): %s", resp.StatusCode, bodyBytes)
+			}
+			return "", utils.ErrorNew(out.Code, out.Msg)
+		}
+
+		var answer strings.Builder
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			data := strings.TrimPrefix(line, "data: ")
+			if data == "[DONE]" {
+				break
+			}
+			var event streamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				return answer.String(), err
+			}
+			if event.Code != 0 {
+				return answer.String(), utils.ErrorNew(event.Code, event.Msg)
+			}
+			for _, choice := range event.Choices {
+				if choice.Index == 0 {
+					answer.WriteString(choice.Delta.Content)
+					onDelta(choice.Delta.Content)
+				}
+			}
+		}
+		return answer.String(), scanner.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/urfave/cli/v2"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+
+	"chatgpt_server/analytics"
+	"chatgpt_server/ledger"
+	"chatgpt_server/moderation"
+	"chatgpt_server/repos"
+	"chatgpt_server/services"
+	"chatgpt_server/vector"
+)
+
+// configFlag 额外的配置文件，覆盖工作目录下 .yml 中的同名配置
+func configFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "config",
+		Aliases: []string{"c"},
+		Usage:   "yaml config file merged over .yml",
+	}
+}
+
+func loadConfig(c *cli.Context) error {
+	if f := c.String("config"); f != "" {
+		return config.ReadFromFile(f)
+	}
+	return nil
+}
+
+// initDeps 初始化服务依赖的 key 池、redis、审核词库等
+func initDeps() {
+	zipkinUtil.InitZipkinWithApolloConfig()
+	repos.InitChatGPTs()
+	repos.InitRedis()
+	moderation.InitWordLists()
+	moderation.InitReview()
+	ledger.InitLedger()
+	vector.InitStore()
+	analytics.InitAnalytics()
+}
+
+// closeDeps 服务退出前结束异步任务、写入向量索引、发出缓冲的统计事件等
+func closeDeps() {
+	services.StopJobs()
+	for _, store := range []*vector.Store{vector.Default(), vector.Knowledge()} {
+		if err := store.Flush(); err != nil {
+			log.Err("flush vector store error: " + err.Error())
+		}
+	}
+	analytics.CloseAnalytics()
+}
+
+// Commands 所有子命令
+func Commands() []*cli.Command {
+	return []*cli.Command{
+		serveCommand(),
+		keysCommand(),
+		chatCommand(),
+		benchCommand(),
+		consumeCommand(),
+		callerTokenCommand(),
+	}
+}
+
+// DefaultAction 不带子命令时启动服务，兼容旧的启动方式
+func DefaultAction(c *cli.Context) error {
+	initDeps()
+	StartListen()
+	closeDeps()
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+
+	"chatgpt_server/services"
+)
+
+func consumeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "consume",
+		Usage: "Process chat tasks from a kafka topic and publish results to the reply topic",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "topic",
+				Usage: "task topic, overrides kafka.consumer.topic",
+			},
+			&cli.StringFlag{
+				Name:  "group",
+				Usage: "consumer group, overrides kafka.consumer.group",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "tasks processed at the same time, overrides kafka.consumer.concurrency",
+			},
+			configFlag(),
+		},
+		Before: loadConfig,
+		Action: func(c *cli.Context) error {
+			opts := services.ConsumerOptionsFromConfig()
+			if c.IsSet("topic") {
+				opts.Topic = c.String("topic")
+			}
+			if c.IsSet("group") {
+				opts.Group = c.String("group")
+			}
+			if c.IsSet("concurrency") {
+				opts.Concurrency = c.Int("concurrency")
+			}
+			if err := opts.Validate(); err != nil {
+				return err
+			}
+			initDeps()
+			defer closeDeps()
+			// 收到退出信号后不再拉取，处理中的任务完成后退出
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			fmt.Printf("Consuming %s as group %s, concurrency %d\n", opts.Topic, opts.Group, opts.Concurrency)
+			return services.RunConsumer(ctx, opts)
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"chatgpt_server/repos"
+)
+
+func keysCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "Manage configured api keys",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "check",
+				Usage:  "Validate every configured key against the models endpoint",
+				Flags:  []cli.Flag{configFlag()},
+				Before: loadConfig,
+				Action: keysCheck,
+			},
+		},
+	}
+}
+
+func keysCheck(c *cli.Context) error {
+	initDeps()
+	checks := repos.CheckKeys(context.Background())
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSTATUS\tRATE LIMITS\tERROR")
+	failed := 0
+	for _, check := range checks {
+		status := "-"
+		if check.Status > 0 {
+			status = fmt.Sprint(check.Status)
+		}
+		if check.Error != "" || check.Status != 200 {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.KeyHash, status, formatRateLimits(check.RateLimits), check.Error)
+	}
+	w.Flush()
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d/%d keys failed", failed, len(checks)), 1)
+	}
+	return nil
+}
+
+func formatRateLimits(limits map[string]string) string {
+	if len(limits) == 0 {
+		return "-"
+	}
+	items := make([]string, 0, len(limits))
+	for name, value := range limits {
+		items = append(items, strings.TrimPrefix(name, "x-ratelimit-")+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, " ")
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/facebookgo/grace/gracehttp"
+	"github.com/urfave/cli/v2"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/repos"
+	"chatgpt_server/routes"
+	"chatgpt_server/services"
+)
+
+func serveCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Run http server",
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "port",
+				Aliases: []string{"p"},
+				Usage:   "listen port, overrides port in config",
+			},
+			&cli.StringFlag{
+				Name:  "record",
+				Usage: "record upstream traffic to a jsonl cassette",
+			},
+			&cli.StringFlag{
+				Name:  "replay",
+				Usage: "serve upstream responses from a jsonl cassette",
+			},
+			configFlag(),
+		},
+		Before: loadConfig,
+		Action: func(c *cli.Context) error {
+			if c.IsSet("port") {
+				config.Set("port", strconv.Itoa(c.Int("port")))
+			}
+			if f := c.String("record"); f != "" {
+				config.Set("cassette.mode", repos.CassetteRecord)
+				config.Set("cassette.file", f)
+			}
+			if f := c.String("replay"); f != "" {
+				config.Set("cassette.mode", repos.CassetteReplay)
+				config.Set("cassette.file", f)
+			}
+			initDeps()
+			StartListen()
+			closeDeps()
+			return nil
+		},
+	}
+}
+
+func StartListen() {
+	engin := util.NewGin()
+	routes.RouteInit(engin)
+
+	addr := ":" + config.GetDft("port", "10100")
+	s := &http.Server{
+		Addr:    addr,
+		Handler: engin,
+	}
+	services.InitJobs()
+	fmt.Println("Server listen on", addr)
+	err := gracehttp.Serve(s)
+	if err != nil {
+		fmt.Println(err)
+		log.Err(err.Error())
+	}
+}
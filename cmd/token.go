@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"chatgpt_server/auth"
+)
+
+func callerTokenCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "caller-token",
+		Usage: "Issue a signed X-Caller-Token with auth.caller_secret",
+		Flags: []cli.Flag{
+			configFlag(),
+			&cli.StringFlag{Name: "name", Usage: "caller name used in tools.permissions", Required: true},
+			&cli.DurationFlag{Name: "ttl", Usage: "token lifetime", Value: 30 * 24 * time.Hour},
+		},
+		Before: loadConfig,
+		Action: callerToken,
+	}
+}
+
+func callerToken(c *cli.Context) error {
+	secret := auth.Secret()
+	if secret == "" {
+		return cli.Exit("auth.caller_secret is not configured", 1)
+	}
+	name := c.String("name")
+	if !auth.ValidCallerName(name) {
+		return cli.Exit("caller name may only contain letters, digits, _ and -", 1)
+	}
+	if c.Duration("ttl") <= 0 {
+		return cli.Exit("ttl must be positive", 1)
+	}
+	fmt.Println(auth.SignCaller(secret, name, time.Now().Add(c.Duration("ttl"))))
+	return nil
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+const (
+	// DefaultAudioMaxBytes 与上游限制一致
+	DefaultAudioMaxBytes = 25 << 20
+	// 合成音频每次写给客户端的大小
+	speechChunkSize = 16 * 1024
+)
+
+type Audio struct {
+	Srv services.Audio
+}
+
+func NewAudio() *Audio {
+	return &Audio{
+		Srv: services.NewAudio(),
+	}
+}
+
+// Transcribe multipart 上传，file 为音频，可选 model、language、prompt、temperature、timestamps、user_id
+func (a *Audio) Transcribe(c *gin.Context) {
+	maxBytes := int64(config.GetIntDft("audio.max_bytes", DefaultAudioMaxBytes))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverhead)
+	header, err := c.FormFile("file")
+	if err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "file: "+err.Error())
+		return
+	}
+	if header.Size > maxBytes {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "file: exceeds "+strconv.FormatInt(maxBytes, 10)+" bytes")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	req := models.ReqTranscription{
+		Filename:   header.Filename,
+		Data:       data,
+		Model:      c.PostForm("model"),
+		Language:   c.PostForm("language"),
+		Prompt:     c.PostForm("prompt"),
+		Timestamps: c.PostForm("timestamps"),
+	}
+	if temperature := c.PostForm("temperature"); temperature != "" {
+		if req.Temperature, err = strconv.ParseFloat(temperature, 64); err != nil {
+			util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "temperature: must be a number")
+			return
+		}
+	}
+	if userID := c.PostForm("user_id"); userID != "" {
+		if req.UserID, err = strconv.ParseInt(userID, 10, 64); err != nil {
+			util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "user_id: must be an integer")
+			return
+		}
+	}
+	resp, err := a.Srv.Transcribe(c.Request.Context(), req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+// Speech 边收到上游的音频边转发给客户端，出错时返回 json
+func (a *Audio) Speech(c *gin.Context) {
+	req := new(models.ReqSpeechFromClient)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	audio, err := a.Srv.Speech(c.Request.Context(), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	defer audio.Body.Close()
+	c.Header("Content-Type", audio.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	buf := make([]byte, speechChunkSize)
+	for {
+		n, err := audio.Body.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// 状态码已经发出，只能中断响应
+			log.WithCtxFields(c.Request.Context(), log.Fields{"error": err}).Errorln("read speech stream error")
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultBatchMaxBytes = 10 << 20
+	// JSONL 单行的长度上限
+	batchMaxLineSize = 1 << 20
+)
+
+type Batch struct {
+	Srv services.Batch
+}
+
+func NewBatch() *Batch {
+	return &Batch{
+		Srv: services.NewBatch(),
+	}
+}
+
+// Run 请求体为 {"requests":[{"custom_id","body"}],"user_id"}，
+// 或 Content-Type 为 application/x-ndjson 的 JSONL（user_id 放在 query 中），
+// 或 multipart 上传 JSONL 文件 file 和字段 user_id。
+// 结果按完成顺序以 application/x-ndjson 逐行输出，单项失败时该行带 error
+func (b *Batch) Run(c *gin.Context) {
+	maxBytes := int64(config.GetIntDft("batch.max_bytes", DefaultBatchMaxBytes))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverhead)
+	req, err := bindBatch(c)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	caller, ok := callerOf(c)
+	if !ok {
+		return
+	}
+	req.Caller = caller
+
+	started := false
+	err = b.Srv.Run(c.Request.Context(), *req, func(result *models.BatchResult) error {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Cache-Control", "no-cache")
+		}
+		line, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if _, err := c.Writer.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil && !started {
+		outServiceErr(c, err)
+	}
+}
+
+func bindBatch(c *gin.Context) (*models.ReqBatch, error) {
+	req := new(models.ReqBatch)
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("file: " + err.Error())
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if req.Requests, err = models.ParseBatchJSONL(file, batchMaxLineSize); err != nil {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("file: " + err.Error())
+		}
+		if req.UserID, err = parseUserID(c.PostForm("user_id")); err != nil {
+			return nil, err
+		}
+	case "application/x-ndjson", "application/jsonl":
+		var err error
+		if req.Requests, err = models.ParseBatchJSONL(c.Request.Body, batchMaxLineSize); err != nil {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("body: " + err.Error())
+		}
+		if req.UserID, err = parseUserID(c.Query("user_id")); err != nil {
+			return nil, err
+		}
+	default:
+		if err := c.ShouldBindJSON(req); err != nil {
+			return nil, utils.ErrorParamsInvalid
+		}
+	}
+	return req, nil
+}
+
+func parseUserID(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	userID, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, utils.ErrorParamsInvalid.NewWithMsg("user_id: must be an integer")
+	}
+	return userID, nil
+}
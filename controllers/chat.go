@@ -1,10 +1,14 @@
 package controllers
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/gin-gonic/gin"
 
 	"meipian.cn/meigo/v2/util"
 
+	"chatgpt_server/auth"
 	"chatgpt_server/models"
 	"chatgpt_server/services"
 	"chatgpt_server/utils"
@@ -33,7 +37,7 @@ func (chat *Chat) SendMsg(c *gin.Context) {
 
 	resp, err := chat.Srv.SendMsg(ctx, *req)
 	if err != nil {
-		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorSystemError), utils.GetErrorMsg(utils.ErrorSystemError))
+		outServiceErr(c, err)
 		return
 	}
 	util.OutJsonOk(c, resp)
@@ -46,12 +50,87 @@ func (chat *Chat) SendChatGPTMsg(c *gin.Context) {
 		return
 	}
 
+	caller, ok := callerOf(c)
+	if !ok {
+		return
+	}
+	req.Caller = caller
 	ctx := c.Request.Context()
 
 	resp, err := chat.ChatGPTSrv.SendMsg(ctx, *req)
 	if err != nil {
-		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorSystemError), utils.GetErrorMsg(utils.ErrorSystemError))
+		outServiceErr(c, err)
 		return
 	}
 	util.OutJsonOk(c, resp)
 }
+
+// SendChatGPTMsgStream 以 SSE 输出，格式与 OpenAI stream 一致，出错时输出 {"code","msg"} 后结束
+func (chat *Chat) SendChatGPTMsgStream(c *gin.Context) {
+	req := new(models.ReqChatGPTFromCient)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+
+	caller, ok := callerOf(c)
+	if !ok {
+		return
+	}
+	req.Caller = caller
+	ctx := c.Request.Context()
+
+	started := false
+	err := chat.ChatGPTSrv.SendMsgStream(ctx, *req, func(chunk *models.RespChatGPTChunk) error {
+		if !started {
+			started = true
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+		}
+		return writeEvent(c, chunk)
+	})
+	if err != nil {
+		if !started {
+			outServiceErr(c, err)
+			return
+		}
+		if _, ok := err.(*utils.ServiceErr); !ok {
+			err = utils.ErrorSystemError
+		}
+		writeEvent(c, err)
+		return
+	}
+	fmt.Fprintf(c.Writer, "data: %s\n\n", "[DONE]")
+	c.Writer.Flush()
+}
+
+func writeEvent(c *gin.Context, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", body); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// callerOf 已认证的调用方，用于服务端工具的权限，见 auth.Caller。令牌无效时输出错误并返回 false
+func callerOf(c *gin.Context) (string, bool) {
+	caller, err := auth.Caller(c.GetHeader)
+	if err != nil {
+		outServiceErr(c, utils.ErrorUnauthorized.NewWithMsg(err.Error()))
+		return "", false
+	}
+	return caller, true
+}
+
+// outServiceErr 业务错误原样输出，其他错误统一为系统错误
+func outServiceErr(c *gin.Context, err error) {
+	if _, ok := err.(*utils.ServiceErr); !ok {
+		err = utils.ErrorSystemError
+	}
+	util.OutJsonErrMsg(c, utils.GetErrorCode(err), utils.GetErrorMsg(err))
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+type Embeddings struct {
+	Srv       services.Embeddings
+	VectorSrv services.Vectors
+}
+
+func NewEmbeddings() *Embeddings {
+	return &Embeddings{
+		Srv:       services.NewEmbeddings(),
+		VectorSrv: services.NewVectors(),
+	}
+}
+
+func (e *Embeddings) Create(c *gin.Context) {
+	req := new(models.ReqEmbeddingsFromClient)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	resp, err := e.Srv.Create(c.Request.Context(), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+func (e *Embeddings) Upsert(c *gin.Context) {
+	req := new(models.ReqVectorUpsert)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	resp, err := e.VectorSrv.Upsert(c.Request.Context(), c.Param("namespace"), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+func (e *Embeddings) Delete(c *gin.Context) {
+	req := new(models.ReqVectorDelete)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	resp, err := e.VectorSrv.Delete(c.Request.Context(), c.Param("namespace"), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+func (e *Embeddings) Query(c *gin.Context) {
+	req := new(models.ReqVectorQuery)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	resp, err := e.VectorSrv.Query(c.Request.Context(), c.Param("namespace"), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
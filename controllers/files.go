@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/extract"
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+// multipart 中文件以外的字段和边界的余量
+const multipartOverhead = 1 << 20
+
+type Files struct {
+	Srv services.Files
+}
+
+func NewFiles() *Files {
+	return &Files{
+		Srv: services.NewFiles(),
+	}
+}
+
+// Extract multipart 上传，file 为文件，可选 knowledge_base_id、document_id、user_id
+func (f *Files) Extract(c *gin.Context) {
+	maxBytes := extract.GetOptions().MaxBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverhead)
+	header, err := c.FormFile("file")
+	if err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "file: "+err.Error())
+		return
+	}
+	if header.Size > maxBytes {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "file: exceeds "+strconv.FormatInt(maxBytes, 10)+" bytes")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	req := models.ReqExtract{
+		Filename:        header.Filename,
+		Data:            data,
+		KnowledgeBaseID: c.PostForm("knowledge_base_id"),
+		DocumentID:      c.PostForm("document_id"),
+	}
+	if userID := c.PostForm("user_id"); userID != "" {
+		if req.UserID, err = strconv.ParseInt(userID, 10, 64); err != nil {
+			util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), "user_id: must be an integer")
+			return
+		}
+	}
+	resp, err := f.Srv.Extract(c.Request.Context(), req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+)
+
+type Health struct {
+	Srv services.Health
+}
+
+func NewHealth() *Health {
+	return &Health{
+		Srv: services.NewHealth(),
+	}
+}
+
+func (h *Health) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Srv.Liveness(c.Request.Context()))
+}
+
+// Readyz 未就绪时返回 503，编排系统据此摘除流量
+func (h *Health) Readyz(c *gin.Context) {
+	report := h.Srv.Readiness(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != models.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+type Images struct {
+	Srv services.Images
+}
+
+func NewImages() *Images {
+	return &Images{
+		Srv: services.NewImages(),
+	}
+}
+
+func (i *Images) Generate(c *gin.Context) {
+	req := new(models.ReqImageGenerateFromClient)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	resp, err := i.Srv.Generate(c.Request.Context(), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
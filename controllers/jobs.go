@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+type Jobs struct {
+	Srv services.Jobs
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{
+		Srv: services.NewJobs(),
+	}
+}
+
+// Submit 请求体与 /chatGPT/sendMsg 相同，可选 callback_url
+func (j *Jobs) Submit(c *gin.Context) {
+	req := new(models.ReqJob)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	caller, ok := callerOf(c)
+	if !ok {
+		return
+	}
+	req.Caller = caller
+	resp, err := j.Srv.Submit(c.Request.Context(), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+// Get 只能查询本调用方提交的任务
+func (j *Jobs) Get(c *gin.Context) {
+	caller, ok := callerOf(c)
+	if !ok {
+		return
+	}
+	resp, err := j.Srv.Get(c.Request.Context(), caller, c.Param("id"))
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+// Cancel 只能取消本调用方提交的任务
+func (j *Jobs) Cancel(c *gin.Context) {
+	caller, ok := callerOf(c)
+	if !ok {
+		return
+	}
+	resp, err := j.Srv.Cancel(c.Request.Context(), caller, c.Param("id"))
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"meipian.cn/meigo/v2/util"
+
+	"chatgpt_server/models"
+	"chatgpt_server/services"
+	"chatgpt_server/utils"
+)
+
+type Knowledge struct {
+	Srv services.Knowledge
+}
+
+func NewKnowledge() *Knowledge {
+	return &Knowledge{
+		Srv: services.NewKnowledge(),
+	}
+}
+
+func (k *Knowledge) AddDocument(c *gin.Context) {
+	req := new(models.ReqKBDocument)
+	if err := c.ShouldBindJSON(req); err != nil {
+		util.OutJsonErrMsg(c, utils.GetErrorCode(utils.ErrorParamsInvalid), utils.GetErrorMsg(utils.ErrorParamsInvalid))
+		return
+	}
+	resp, err := k.Srv.AddDocument(c.Request.Context(), c.Param("kb"), *req)
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+func (k *Knowledge) ListDocuments(c *gin.Context) {
+	resp, err := k.Srv.ListDocuments(c.Request.Context(), c.Param("kb"))
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
+
+func (k *Knowledge) DeleteDocument(c *gin.Context) {
+	resp, err := k.Srv.DeleteDocument(c.Request.Context(), c.Param("kb"), c.Param("doc"))
+	if err != nil {
+		outServiceErr(c, err)
+		return
+	}
+	util.OutJsonOk(c, resp)
+}
@@ -0,0 +1,186 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// docx 中单个 xml 文件解压后的大小上限，避免压缩炸弹
+const maxDocxPartSize = 64 << 20
+
+// extractDOCX 读取 word/document.xml，Heading 样式的段落转为 # 标题，表格单元格用 | 分隔
+func extractDOCX(data []byte) (*Document, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("corrupt docx file")
+	}
+	doc := &Document{Format: FormatDOCX}
+	var body []byte
+	for _, f := range r.File {
+		switch f.Name {
+		case "word/document.xml":
+			if body, err = readZipFile(f); err != nil {
+				return nil, err
+			}
+		case "docProps/core.xml":
+			core, err := readZipFile(f)
+			if err == nil {
+				doc.Title = docxTitle(core)
+			}
+		case "EncryptedPackage":
+			return nil, ErrEncrypted
+		}
+	}
+	if body == nil {
+		return nil, errors.New("corrupt docx file: missing word/document.xml")
+	}
+	text, err := docxText(body)
+	if err != nil {
+		return nil, errors.New("corrupt docx file: " + err.Error())
+	}
+	doc.Text = text
+	return doc, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, errors.New("corrupt docx file")
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxDocxPartSize+1))
+	if err != nil {
+		return nil, errors.New("corrupt docx file")
+	}
+	if len(data) > maxDocxPartSize {
+		return nil, errors.New("docx content too large")
+	}
+	return data, nil
+}
+
+func docxTitle(core []byte) string {
+	var props struct {
+		Title string `xml:"title"`
+	}
+	if xml.Unmarshal(core, &props) != nil {
+		return ""
+	}
+	return strings.TrimSpace(props.Title)
+}
+
+// docxText 按顺序遍历 xml，w:p 为段落，w:t 为文字，w:tab / w:br 为制表符和换行
+func docxText(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var (
+		b         strings.Builder
+		paragraph strings.Builder
+		prefix    string
+		inText    bool
+		// 表格嵌套层数，单元格之间用 | 分隔
+		tableDepth int
+		cellIndex  int
+		// 当前单元格中已有文字
+		cellText bool
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+				prefix = ""
+			case "pStyle":
+				prefix = headingPrefix(attr(t, "val"))
+			case "numPr":
+				if prefix == "" {
+					prefix = "- "
+				}
+			case "t":
+				inText = true
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			case "tbl":
+				tableDepth++
+				b.WriteString("\n")
+			case "tr":
+				cellIndex = 0
+			case "tc":
+				cellIndex++
+				cellText = false
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := strings.TrimSpace(paragraph.String())
+				if tableDepth > 0 {
+					if text != "" {
+						if cellText {
+							b.WriteString(" ")
+						} else if cellIndex > 1 {
+							b.WriteString(" | ")
+						}
+						b.WriteString(text)
+						cellText = true
+					}
+					continue
+				}
+				if text != "" {
+					b.WriteString(prefix + text)
+				}
+				b.WriteString("\n")
+				if strings.HasPrefix(prefix, "#") {
+					b.WriteString("\n")
+				}
+			case "tr":
+				b.WriteString("\n")
+			case "tbl":
+				tableDepth--
+				b.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// headingPrefix 样式 Heading1 ~ Heading6 和 Title 对应的 # 前缀
+func headingPrefix(style string) string {
+	lower := strings.ToLower(style)
+	if lower == "title" {
+		return "# "
+	}
+	if strings.HasPrefix(lower, "heading") {
+		if level, err := strconv.Atoi(strings.TrimPrefix(lower, "heading")); err == nil && level >= 1 && level <= 6 {
+			return strings.Repeat("#", level) + " "
+		}
+	}
+	return ""
+}
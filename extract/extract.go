@@ -0,0 +1,149 @@
+package extract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+const (
+	DefaultMaxBytes = 10 << 20
+	DefaultMaxPages = 200
+	DefaultMaxChars = 200000
+
+	FormatPDF      = "pdf"
+	FormatDOCX     = "docx"
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported file format, expected pdf, docx, html, md or txt")
+	ErrEncrypted   = errors.New("encrypted files are not supported")
+)
+
+// Options 文件大小、页数和提取文字的限制
+type Options struct {
+	MaxBytes int64
+	MaxPages int
+	// 超过后截断，Document.Truncated 为 true
+	MaxChars int
+}
+
+// GetOptions 读取 extract.* 配置
+func GetOptions() Options {
+	return Options{
+		MaxBytes: int64(config.GetIntDft("extract.max_bytes", DefaultMaxBytes)),
+		MaxPages: config.GetIntDft("extract.max_pages", DefaultMaxPages),
+		MaxChars: config.GetIntDft("extract.max_chars", DefaultMaxChars),
+	}
+}
+
+// Document 提取出的文字，标题以 Markdown 的 # 标出，PDF 每页前有 [Page N]
+type Document struct {
+	Format string
+	Title  string
+	// 只有 PDF 有页数
+	Pages     int
+	Text      string
+	Truncated bool
+}
+
+// Detect 按文件头和扩展名判断格式
+func Detect(filename string, data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return FormatPDF
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if strings.EqualFold(filepath.Ext(filename), ".docx") || bytes.Contains(data[:minInt(len(data), 4096)], []byte("word/")) {
+			return FormatDOCX
+		}
+		return ""
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".html", ".htm":
+		return FormatHTML
+	case ".md", ".markdown":
+		return FormatMarkdown
+	case ".txt", ".text", "":
+		if !utf8.Valid(data) {
+			return ""
+		}
+		head := strings.ToLower(string(data[:minInt(len(data), 512)]))
+		if strings.Contains(head, "<html") || strings.Contains(head, "<!doctype html") {
+			return FormatHTML
+		}
+		return FormatText
+	}
+	return ""
+}
+
+// Extract 提取文件中的文字
+func Extract(filename string, data []byte, opts Options) (*Document, error) {
+	if int64(len(data)) > opts.MaxBytes {
+		return nil, fmt.Errorf("file exceeds %d bytes", opts.MaxBytes)
+	}
+	var (
+		doc *Document
+		err error
+	)
+	switch format := Detect(filename, data); format {
+	case FormatPDF:
+		doc, err = extractPDF(data, opts)
+	case FormatDOCX:
+		doc, err = extractDOCX(data)
+	case FormatHTML:
+		doc = extractHTML(string(data))
+	case FormatMarkdown:
+		doc = extractMarkdown(string(data))
+	case FormatText:
+		doc = &Document{Format: FormatText, Text: string(data)}
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.Text = clean(doc.Text)
+	if doc.Title == "" {
+		doc.Title = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	if opts.MaxChars > 0 && utf8.RuneCountInString(doc.Text) > opts.MaxChars {
+		doc.Text = string([]rune(doc.Text)[:opts.MaxChars])
+		doc.Truncated = true
+	}
+	return doc, nil
+}
+
+var (
+	spaces     = regexp.MustCompile(`[ \t\f\v\x{00a0}\x{3000}]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// clean 合并多余的空白和空行，去掉每行首尾空白
+func clean(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.ToValidUTF8(text, "")
+	text = strings.ReplaceAll(text, "\x00", "")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaces.ReplaceAllString(line, " "))
+	}
+	text = blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
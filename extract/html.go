@@ -0,0 +1,127 @@
+package extract
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// skipTags 内容不是正文的标签
+var skipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"svg": true, "nav": true, "iframe": true,
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// blockTags 前后换行的标签
+var blockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "header": true, "footer": true,
+	"main": true, "aside": true, "blockquote": true, "pre": true, "table": true, "tr": true,
+	"ul": true, "ol": true, "dl": true, "dt": true, "dd": true, "figure": true, "figcaption": true,
+	"form": true, "hr": true, "address": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "li": true, "br": true, "title": true,
+}
+
+// extractHTML 去掉标签，标题转为 # 开头的行，列表项转为 - 开头，表格单元格用 | 分隔
+func extractHTML(src string) *Document {
+	doc := &Document{Format: FormatHTML}
+	var (
+		b    strings.Builder
+		skip string
+		// 当前在 title 中
+		inTitle bool
+		title   strings.Builder
+		// 当前在 pre 中，保留空白
+		inPre bool
+		// 当前行已有的单元格数
+		cells int
+	)
+	for len(src) > 0 {
+		lt := strings.IndexByte(src, '<')
+		if lt < 0 {
+			lt = len(src)
+		}
+		if text := src[:lt]; text != "" && skip == "" {
+			text = html.UnescapeString(text)
+			if !inPre {
+				text = whitespace.ReplaceAllString(text, " ")
+			}
+			if inTitle {
+				title.WriteString(text)
+			} else {
+				b.WriteString(text)
+			}
+		}
+		src = src[lt:]
+		if src == "" {
+			break
+		}
+		if strings.HasPrefix(src, "<!--") {
+			end := strings.Index(src, "-->")
+			if end < 0 {
+				break
+			}
+			src = src[end+3:]
+			continue
+		}
+		gt := strings.IndexByte(src, '>')
+		if gt < 0 {
+			break
+		}
+		tag := src[1:gt]
+		src = src[gt+1:]
+		closing := strings.HasPrefix(tag, "/")
+		name := strings.ToLower(strings.TrimLeft(tag, "/!?"))
+		if i := strings.IndexAny(name, " \t\n\r/"); i >= 0 {
+			name = name[:i]
+		}
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+			continue
+		}
+		if !closing && skipTags[name] && !strings.HasSuffix(tag, "/") {
+			skip = name
+			continue
+		}
+		switch name {
+		case "title":
+			inTitle = !closing
+			continue
+		case "pre":
+			inPre = !closing
+		}
+		if !blockTags[name] {
+			if (name == "td" || name == "th") && !closing {
+				if cells > 0 {
+					b.WriteString(" | ")
+				}
+				cells++
+			}
+			continue
+		}
+		if closing && name == "li" {
+			continue
+		}
+		b.WriteString("\n")
+		if name == "tr" {
+			cells = 0
+		}
+		if closing {
+			continue
+		}
+		switch name {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			b.WriteString(strings.Repeat("#", int(name[1]-'0')) + " ")
+		case "li":
+			b.WriteString("- ")
+		case "p", "table", "blockquote", "pre":
+			b.WriteString("\n")
+		}
+	}
+	doc.Title = strings.TrimSpace(title.String())
+	doc.Text = b.String()
+	return doc
+}
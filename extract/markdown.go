@@ -0,0 +1,83 @@
+package extract
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink     = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdRefLink  = regexp.MustCompile(`\[([^\]]+)\]\[[^\]]*\]`)
+	mdRefDef   = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s+\S+`)
+	mdEmphasis = regexp.MustCompile("(\\*\\*|__|~~|`)")
+	mdTag      = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	mdHeading  = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdSetext   = regexp.MustCompile(`^\s{0,3}(=+|-+)\s*$`)
+	mdList     = regexp.MustCompile(`^(\s*)([*+-]|\d+[.)])\s+`)
+)
+
+// extractMarkdown 保留 # 标题和列表，去掉链接、图片地址、强调符号和代码块标记
+func extractMarkdown(src string) *Document {
+	doc := &Document{Format: FormatMarkdown}
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	// 去掉 front matter，其中的 title 作为标题
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "---" {
+				for _, line := range lines[1:i] {
+					if key, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(key) == "title" {
+						doc.Title = strings.Trim(strings.TrimSpace(value), `"'`)
+					}
+				}
+				lines = lines[i+1:]
+				break
+			}
+		}
+	}
+	out := make([]string, 0, len(lines))
+	inCode := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			out = append(out, line)
+			continue
+		}
+		if mdRefDef.MatchString(line) {
+			continue
+		}
+		// Setext 标题: 下一行为 === 或 ---
+		if i+1 < len(lines) && trimmed != "" && !mdList.MatchString(line) && mdSetext.MatchString(lines[i+1]) {
+			level := "#"
+			if strings.HasPrefix(strings.TrimSpace(lines[i+1]), "-") {
+				level = "##"
+			}
+			line = level + " " + trimmed
+		}
+		if mdSetext.MatchString(line) {
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			line = m[1] + " " + m[2]
+			if doc.Title == "" && m[1] == "#" {
+				doc.Title = inlineMarkdown(m[2])
+			}
+		}
+		line = strings.TrimLeft(line, "> ")
+		out = append(out, inlineMarkdown(line))
+	}
+	doc.Text = strings.Join(out, "\n")
+	return doc
+}
+
+func inlineMarkdown(line string) string {
+	line = mdImage.ReplaceAllString(line, "$1")
+	line = mdLink.ReplaceAllString(line, "$1")
+	line = mdRefLink.ReplaceAllString(line, "$1")
+	line = mdTag.ReplaceAllString(line, "")
+	return mdEmphasis.ReplaceAllString(line, "")
+}
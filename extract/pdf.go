@@ -0,0 +1,510 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// PDF 对象，见 PDF 32000-1:2008 第 7.3 节
+type (
+	pdfName  string
+	pdfDict  map[string]interface{}
+	pdfArray []interface{}
+	// pdfString 字符串的原始字节，编码由字体决定
+	pdfString []byte
+	pdfRef    struct{ num, gen int }
+	// pdfKeyword 内容流中的操作符和 true / false / null 以外的关键字
+	pdfKeyword string
+	pdfStream  struct {
+		dict pdfDict
+		raw  []byte
+	}
+)
+
+var (
+	errCorruptPDF  = errors.New("corrupt pdf file")
+	errPDFTooLarge = errors.New("pdf content exceeds the decode limit")
+)
+
+const (
+	// 单个流解压后的大小上限，避免压缩炸弹
+	maxPDFStreamSize = 64 << 20
+	// 数组和字典的嵌套层数上限，避免递归解析耗尽栈
+	maxPDFNesting = 256
+	// 整个文件解码的总字节数为文件大小上限的倍数，同一个流被多次使用时重复计入
+	pdfDecodeRatio = 16
+)
+
+type pdfParser struct {
+	data []byte
+	pos  int
+	// 当前所在数组和字典的层数
+	depth int
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelim(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if isPDFSpace(c) {
+			p.pos++
+		} else if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// next 读取下一个对象，数字后跟 "gen R" 时返回引用，结束时返回 io.EOF
+func (p *pdfParser) next() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.EOF
+	}
+	c := p.data[p.pos]
+	switch {
+	case c == '/':
+		return p.name(), nil
+	case c == '(':
+		return p.literal(), nil
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		return p.dict()
+	case c == '<':
+		return p.hexString(), nil
+	case c == '[':
+		return p.array()
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		p.pos++
+		return pdfKeyword(c), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number(), nil
+	}
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelim(p.data[p.pos]) {
+		p.pos++
+	}
+	switch word := string(p.data[start:p.pos]); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return pdfKeyword(word), nil
+	}
+}
+
+func (p *pdfParser) name() pdfName {
+	p.pos++
+	var b []byte
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelim(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		p.pos++
+	}
+	return pdfName(b)
+}
+
+func (p *pdfParser) literal() pdfString {
+	p.pos++
+	var b []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return b
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return b
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func (p *pdfParser) hexString() pdfString {
+	p.pos++
+	var digits []byte
+	for p.pos < len(p.data) && p.data[p.pos] != '>' {
+		if c := p.data[p.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		p.pos++
+	}
+	p.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	n, _ := hex.Decode(b, digits)
+	return b[:n]
+}
+
+func (p *pdfParser) number() interface{} {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.data) && (p.data[p.pos] == '.' || (p.data[p.pos] >= '0' && p.data[p.pos] <= '9')) {
+		p.pos++
+	}
+	v, _ := strconv.ParseFloat(string(p.data[start:p.pos]), 64)
+	// 引用 "num gen R"
+	if !bytes.ContainsAny(p.data[start:p.pos], "+-.") {
+		save := p.pos
+		p.skipSpace()
+		genStart := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+		if p.pos > genStart {
+			gen, _ := strconv.Atoi(string(p.data[genStart:p.pos]))
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == 'R' && (p.pos+1 == len(p.data) || isPDFSpace(p.data[p.pos+1]) || isPDFDelim(p.data[p.pos+1])) {
+				p.pos++
+				return pdfRef{int(v), gen}
+			}
+		}
+		p.pos = save
+	}
+	return v
+}
+
+// enter 进入数组或字典，超过 maxPDFNesting 层时返回 errCorruptPDF
+func (p *pdfParser) enter() error {
+	if p.depth >= maxPDFNesting {
+		return errCorruptPDF
+	}
+	p.depth++
+	return nil
+}
+
+func (p *pdfParser) array() (pdfArray, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos++
+	var arr pdfArray
+	for {
+		obj, err := p.next()
+		if err != nil {
+			return arr, err
+		}
+		if obj == pdfKeyword("]") {
+			return arr, nil
+		}
+		arr = append(arr, obj)
+	}
+}
+
+func (p *pdfParser) dict() (pdfDict, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.pos += 2
+	dict := pdfDict{}
+	for {
+		p.skipSpace()
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			return dict, nil
+		}
+		key, err := p.next()
+		if err != nil {
+			return dict, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return dict, errCorruptPDF
+		}
+		value, err := p.next()
+		if err != nil {
+			return dict, err
+		}
+		dict[string(name)] = value
+	}
+}
+
+// pdfFile 按对象号索引的所有对象
+type pdfFile struct {
+	objects  map[int]interface{}
+	trailers []pdfDict
+	// 已解码的流，每个对象只解码一次
+	decoded map[*pdfStream][]byte
+	// 剩余可解码的字节数，用完后 overBudget 为 true，之后的解码都失败
+	budget     int64
+	overBudget bool
+}
+
+var objPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// parsePDF 扫描整个文件中的 "n g obj"，不依赖可能损坏的 xref 表，后出现的对象覆盖之前的。
+// budget 为整个文件解码的总字节数上限
+func parsePDF(data []byte, budget int64) (*pdfFile, error) {
+	f := &pdfFile{objects: map[int]interface{}{}, decoded: map[*pdfStream][]byte{}, budget: budget}
+	for _, loc := range objPattern.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		p := &pdfParser{data: data, pos: loc[1]}
+		obj, err := p.next()
+		if err != nil {
+			continue
+		}
+		if dict, ok := obj.(pdfDict); ok {
+			p.skipSpace()
+			if bytes.HasPrefix(data[p.pos:], []byte("stream")) {
+				obj = &pdfStream{dict: dict, raw: streamData(data, p.pos+len("stream"), dict)}
+			}
+			if dict["Type"] == pdfName("XRef") {
+				f.trailers = append(f.trailers, dict)
+			}
+		}
+		f.objects[num] = obj
+	}
+	for _, loc := range regexp.MustCompile(`trailer\s*<<`).FindAllIndex(data, -1) {
+		p := &pdfParser{data: data, pos: loc[1] - 2}
+		if dict, err := p.dict(); err == nil {
+			f.trailers = append(f.trailers, dict)
+		}
+	}
+	for _, trailer := range f.trailers {
+		if trailer["Encrypt"] != nil {
+			return nil, ErrEncrypted
+		}
+	}
+	f.loadObjectStreams()
+	if len(f.objects) == 0 {
+		return nil, errCorruptPDF
+	}
+	return f, nil
+}
+
+// streamData stream 关键字之后的数据，Length 不可用时查找 endstream
+func streamData(data []byte, start int, dict pdfDict) []byte {
+	if start < len(data) && data[start] == '\r' {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		start++
+	}
+	if length, ok := dict["Length"].(float64); ok {
+		end := start + int(length)
+		if end <= len(data) && end >= start {
+			rest := bytes.TrimLeft(data[end:minInt(len(data), end+32)], "\r\n \t")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				return data[start:end]
+			}
+		}
+	}
+	end := bytes.Index(data[start:], []byte("endstream"))
+	if end < 0 {
+		return data[start:]
+	}
+	return bytes.TrimRight(data[start:start+end], "\r\n")
+}
+
+// loadObjectStreams PDF 1.5 起对象可以压缩在 /Type /ObjStm 的流中
+func (f *pdfFile) loadObjectStreams() {
+	for _, obj := range f.objects {
+		stream, ok := obj.(*pdfStream)
+		if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := f.decode(stream)
+		if err != nil {
+			continue
+		}
+		n, _ := stream.dict["N"].(float64)
+		first, _ := f.resolve(stream.dict["First"]).(float64)
+		header := &pdfParser{data: data}
+		for i := 0; i < int(n); i++ {
+			num, err1 := header.next()
+			offset, err2 := header.next()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			numValue, ok1 := num.(float64)
+			offsetValue, ok2 := offset.(float64)
+			if !ok1 || !ok2 || int(first+offsetValue) >= len(data) {
+				break
+			}
+			if _, exists := f.objects[int(numValue)]; exists {
+				continue
+			}
+			p := &pdfParser{data: data, pos: int(first + offsetValue)}
+			if obj, err := p.next(); err == nil {
+				f.objects[int(numValue)] = obj
+			}
+		}
+	}
+}
+
+// resolve 解析引用，其他对象原样返回
+func (f *pdfFile) resolve(obj interface{}) interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = f.objects[ref.num]
+	}
+	return nil
+}
+
+func (f *pdfFile) dict(obj interface{}) pdfDict {
+	switch v := f.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	}
+	return nil
+}
+
+func (f *pdfFile) array(obj interface{}) pdfArray {
+	switch v := f.resolve(obj).(type) {
+	case pdfArray:
+		return v
+	case nil:
+		return nil
+	default:
+		return pdfArray{v}
+	}
+}
+
+// decode 按 /Filter 解码流，不支持的过滤器返回错误。每次返回的数据都计入 budget
+func (f *pdfFile) decode(stream *pdfStream) ([]byte, error) {
+	if f.overBudget {
+		return nil, errPDFTooLarge
+	}
+	data, ok := f.decoded[stream]
+	if !ok {
+		var err error
+		if data, err = f.decodeFilters(stream); err != nil {
+			return nil, err
+		}
+		f.decoded[stream] = data
+	}
+	if int64(len(data)) > f.budget {
+		f.overBudget = true
+		return nil, errPDFTooLarge
+	}
+	f.budget -= int64(len(data))
+	return data, nil
+}
+
+func (f *pdfFile) decodeFilters(stream *pdfStream) ([]byte, error) {
+	data := stream.raw
+	for _, filter := range f.array(stream.dict["Filter"]) {
+		name, _ := f.resolve(filter).(pdfName)
+		var err error
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data, f.budget)
+		case "ASCIIHexDecode", "AHx":
+			p := &pdfParser{data: append(append([]byte{'<'}, data...), '>')}
+			data = p.hexString()
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		default:
+			return nil, fmt.Errorf("unsupported filter %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate 解压 zlib 数据，最多 limit 和 maxPDFStreamSize 中较小的字节数，数据截断时返回已解压的部分
+func inflate(data []byte, limit int64) ([]byte, error) {
+	if limit > maxPDFStreamSize {
+		limit = maxPDFStreamSize
+	}
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(out)) > limit {
+		return nil, errPDFTooLarge
+	}
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, 4*len(data)/5+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	return out[:n], err
+}
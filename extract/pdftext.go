@@ -0,0 +1,536 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Form XObject 嵌套层数上限
+const maxPDFFormDepth = 8
+
+// extractPDF 按页面树顺序提取文字，每页前加 [Page N]，字号明显大于正文的短行作为标题
+func extractPDF(data []byte, opts Options) (*Document, error) {
+	maxPages := opts.MaxPages
+	f, err := parsePDF(data, pdfDecodeBudget(opts.MaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	var root pdfDict
+	for _, trailer := range f.trailers {
+		if root = f.dict(trailer["Root"]); root != nil {
+			break
+		}
+	}
+	if root == nil {
+		for _, obj := range f.objects {
+			if dict, ok := obj.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+				root = dict
+				break
+			}
+		}
+	}
+	if root == nil {
+		return nil, errCorruptPDF
+	}
+	var pages []pdfPage
+	f.collectPages(f.dict(root["Pages"]), nil, &pages, map[interface{}]bool{}, 0)
+	if len(pages) == 0 {
+		return nil, errCorruptPDF
+	}
+	if maxPages > 0 && len(pages) > maxPages {
+		return nil, fmt.Errorf("pdf has %d pages, exceeds the limit of %d", len(pages), maxPages)
+	}
+	doc := &Document{Format: FormatPDF, Pages: len(pages)}
+	for _, trailer := range f.trailers {
+		if info := f.dict(trailer["Info"]); info != nil {
+			if title, ok := f.resolve(info["Title"]).(pdfString); ok {
+				doc.Title = strings.TrimSpace(decodeTextString(title))
+				break
+			}
+		}
+	}
+	var lines [][]pdfLine
+	for _, page := range pages {
+		ex := &pdfExtractor{file: f, fonts: map[string]*pdfFont{}, scale: 1}
+		ex.run(f.pageContents(page.dict), page.resources, 0)
+		ex.flush()
+		lines = append(lines, ex.lines)
+		if f.overBudget {
+			return nil, errPDFTooLarge
+		}
+	}
+	doc.Text = renderPDFLines(lines)
+	return doc, nil
+}
+
+// pdfDecodeBudget 整个文件解码的总字节数，至少能解码一个最大的流
+func pdfDecodeBudget(maxBytes int64) int64 {
+	if budget := maxBytes * pdfDecodeRatio; budget > maxPDFStreamSize {
+		return budget
+	}
+	return maxPDFStreamSize
+}
+
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// collectPages 深度优先遍历页面树，Resources 可以从父节点继承
+func (f *pdfFile) collectPages(node pdfDict, resources pdfDict, pages *[]pdfPage, seen map[interface{}]bool, depth int) {
+	if node == nil || depth > 64 {
+		return
+	}
+	if r := f.dict(node["Resources"]); r != nil {
+		resources = r
+	}
+	if node["Type"] == pdfName("Page") || (node["Kids"] == nil && node["Contents"] != nil) {
+		*pages = append(*pages, pdfPage{dict: node, resources: resources})
+		return
+	}
+	for _, kid := range f.array(node["Kids"]) {
+		if ref, ok := kid.(pdfRef); ok {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+		}
+		f.collectPages(f.dict(kid), resources, pages, seen, depth+1)
+	}
+}
+
+// pageContents 页面的内容流，多个流之间以空白连接
+func (f *pdfFile) pageContents(page pdfDict) []byte {
+	var buf bytes.Buffer
+	for _, c := range f.array(page["Contents"]) {
+		stream, ok := f.resolve(c).(*pdfStream)
+		if !ok {
+			continue
+		}
+		if data, err := f.decode(stream); err == nil {
+			buf.Write(data)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeTextString 文档信息中的字符串，UTF-16BE 以 BOM 开头，否则按 PDFDocEncoding 近似为 Latin-1
+func decodeTextString(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xfe && s[1] == 0xff {
+		return decodeUTF16(s[2:])
+	}
+	runes := make([]rune, len(s))
+	for i, c := range s {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(u))
+}
+
+// pdfFont 字符编码到 Unicode 的映射
+type pdfFont struct {
+	// 每个字符编码的字节数，Type0 字体一般为 2
+	codeBytes int
+	toUnicode map[int]string
+	// 单字节字体没有 ToUnicode 时的编码表
+	encoding *[256]rune
+}
+
+func (ft *pdfFont) decode(s []byte) string {
+	var b strings.Builder
+	n := ft.codeBytes
+	for i := 0; i+n <= len(s); i += n {
+		code := 0
+		for _, c := range s[i : i+n] {
+			code = code<<8 | int(c)
+		}
+		if text, ok := ft.toUnicode[code]; ok {
+			b.WriteString(text)
+		} else if ft.encoding != nil && code < 256 {
+			if r := ft.encoding[code]; r != 0 {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (f *pdfFile) loadFont(dict pdfDict) *pdfFont {
+	ft := &pdfFont{codeBytes: 1}
+	if dict["Subtype"] == pdfName("Type0") {
+		ft.codeBytes = 2
+	}
+	if stream, ok := f.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := f.decode(stream); err == nil {
+			ft.toUnicode, ft.codeBytes = parseCMap(data, ft.codeBytes)
+		}
+	}
+	if ft.codeBytes == 1 {
+		ft.encoding = f.simpleEncoding(f.resolve(dict["Encoding"]))
+	}
+	return ft
+}
+
+// simpleEncoding 单字节字体的编码，Differences 中只识别常见的字形名
+func (f *pdfFile) simpleEncoding(enc interface{}) *[256]rune {
+	table := winAnsiEncoding()
+	dict, ok := enc.(pdfDict)
+	if !ok {
+		return table
+	}
+	code := 0
+	for _, item := range f.array(dict["Differences"]) {
+		switch v := f.resolve(item).(type) {
+		case float64:
+			code = int(v)
+		case pdfName:
+			if code >= 0 && code < 256 {
+				if r := glyphRune(string(v)); r != 0 {
+					table[code] = r
+				}
+			}
+			code++
+		}
+	}
+	return table
+}
+
+// winAnsiEncoding 即 cp1252，0x80 ~ 0x9f 之外与 Latin-1 相同
+func winAnsiEncoding() *[256]rune {
+	var table [256]rune
+	for i := 32; i < 256; i++ {
+		table[i] = rune(i)
+	}
+	table[9], table[10], table[13] = '\t', '\n', '\n'
+	copy(table[0x80:0xa0], []rune("€\x00‚ƒ„…†‡ˆ‰Š‹Œ\x00Ž\x00\x00‘’“”•–—˜™š›œ\x00žŸ"))
+	return &table
+}
+
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quotesingle": '\'', "quoteright": '’', "quoteleft": '‘', "parenleft": '(',
+	"parenright": ')', "asterisk": '*', "plus": '+', "comma": ',', "hyphen": '-', "period": '.',
+	"slash": '/', "colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"underscore": '_', "braceleft": '{', "bar": '|', "braceright": '}', "zero": '0', "one": '1',
+	"two": '2', "three": '3', "four": '4', "five": '5', "six": '6', "seven": '7', "eight": '8',
+	"nine": '9', "endash": '–', "emdash": '—', "quotedblleft": '“', "quotedblright": '”',
+	"bullet": '•', "ellipsis": '…', "fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ',
+}
+
+func glyphRune(name string) rune {
+	if r, ok := glyphNames[name]; ok {
+		return r
+	}
+	if len(name) == 1 {
+		return rune(name[0])
+	}
+	if strings.HasPrefix(name, "uni") && len(name) == 7 {
+		if v, err := strconv.ParseUint(name[3:], 16, 32); err == nil {
+			return rune(v)
+		}
+	}
+	return 0
+}
+
+// parseCMap 解析 ToUnicode CMap 中的 codespacerange、bfchar 和 bfrange
+func parseCMap(data []byte, codeBytes int) (map[int]string, int) {
+	m := map[int]string{}
+	p := &pdfParser{data: data}
+	var operands []interface{}
+	for {
+		obj, err := p.next()
+		if err != nil {
+			break
+		}
+		kw, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+		switch kw {
+		case "endcodespacerange":
+			if len(operands) >= 1 {
+				if lo, ok := operands[0].(pdfString); ok && len(lo) > 0 {
+					codeBytes = len(lo)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					m[cmapCode(src)] = decodeUTF16(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := cmapCode(lo), cmapCode(hi)
+				if end-start > 0xffff || end < start {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					// 只递增最后一个 UTF-16 单元
+					u := []byte(dst)
+					for code := start; code <= end && len(u) >= 2; code++ {
+						m[code] = decodeUTF16(u)
+						next := append([]byte(nil), u...)
+						v := int(next[len(next)-2])<<8 | int(next[len(next)-1]) + 1
+						next[len(next)-2], next[len(next)-1] = byte(v>>8), byte(v)
+						u = next
+					}
+				case pdfArray:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && start+j <= end {
+							m[start+j] = decodeUTF16(s)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return m, codeBytes
+}
+
+func cmapCode(b []byte) int {
+	code := 0
+	for _, c := range b {
+		code = code<<8 | int(c)
+	}
+	return code
+}
+
+// pdfLine 一行文字及其最大字号
+type pdfLine struct {
+	text string
+	size float64
+}
+
+// pdfExtractor 解释内容流中的文字操作符，换行由文字矩阵的纵向移动判断
+type pdfExtractor struct {
+	file  *pdfFile
+	fonts map[string]*pdfFont
+	font  *pdfFont
+	// Tf 指定的字号和文字矩阵的纵向缩放
+	fontSize float64
+	scale    float64
+	// y 为文字矩阵的纵坐标，shownY 为上次输出文字时的纵坐标
+	y, shownY float64
+	// 下次输出文字前换行或加空格
+	breakLine, gap bool
+	line           strings.Builder
+	lineSize       float64
+	lines          []pdfLine
+}
+
+func (ex *pdfExtractor) run(content []byte, resources pdfDict, depth int) {
+	p := &pdfParser{data: content}
+	var operands []interface{}
+	for {
+		obj, err := p.next()
+		if err != nil {
+			return
+		}
+		op, ok := obj.(pdfKeyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+		switch op {
+		case "BT":
+			ex.y, ex.scale = 0, 1
+		case "Tf":
+			if len(operands) >= 2 {
+				name, _ := operands[0].(pdfName)
+				ex.font = ex.loadFont(resources, string(name))
+				ex.fontSize, _ = operands[1].(float64)
+			}
+		case "Tm":
+			if len(operands) == 6 {
+				d, _ := operands[3].(float64)
+				y, _ := operands[5].(float64)
+				if d != 0 {
+					ex.scale = math.Abs(d)
+				}
+				ex.y, ex.gap = y, true
+			}
+		case "Td", "TD":
+			if len(operands) == 2 {
+				tx, _ := operands[0].(float64)
+				ty, _ := operands[1].(float64)
+				ex.y += ty * ex.scale
+				ex.gap = ex.gap || tx > 0
+			}
+		case "T*":
+			ex.breakLine = true
+		case "Tj":
+			if len(operands) >= 1 {
+				ex.show(operands[0])
+			}
+		case "'", "\"":
+			ex.breakLine = true
+			if len(operands) >= 1 {
+				ex.show(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				arr, _ := operands[0].(pdfArray)
+				for _, item := range arr {
+					// 负的字距大于约四分之一个字宽时视为空格
+					if v, ok := item.(float64); ok && v < -250 {
+						ex.gap = true
+					} else {
+						ex.show(item)
+					}
+				}
+			}
+		case "Do":
+			if len(operands) >= 1 && depth < maxPDFFormDepth {
+				name, _ := operands[0].(pdfName)
+				ex.form(resources, string(name), depth)
+			}
+		case "ID":
+			// 跳过内联图片的二进制数据
+			end := bytes.Index(content[p.pos:], []byte("EI"))
+			for end >= 0 && p.pos+end+2 < len(content) && !isPDFSpace(content[p.pos+end+2]) {
+				next := bytes.Index(content[p.pos+end+2:], []byte("EI"))
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += 2 + next
+			}
+			if end < 0 {
+				return
+			}
+			p.pos += end + 2
+		}
+		operands = operands[:0]
+	}
+}
+
+func (ex *pdfExtractor) loadFont(resources pdfDict, name string) *pdfFont {
+	fonts := ex.file.dict(resources["Font"])
+	if fonts == nil {
+		return nil
+	}
+	key := fmt.Sprintf("%p/%s", fonts, name)
+	if ft, ok := ex.fonts[key]; ok {
+		return ft
+	}
+	var ft *pdfFont
+	if dict := ex.file.dict(fonts[name]); dict != nil {
+		ft = ex.file.loadFont(dict)
+	}
+	ex.fonts[key] = ft
+	return ft
+}
+
+// form 解释 Form XObject 的内容流，图片等其他 XObject 忽略
+func (ex *pdfExtractor) form(resources pdfDict, name string, depth int) {
+	xobjects := ex.file.dict(resources["XObject"])
+	if xobjects == nil {
+		return
+	}
+	stream, ok := ex.file.resolve(xobjects[name]).(*pdfStream)
+	if !ok || stream.dict["Subtype"] != pdfName("Form") {
+		return
+	}
+	data, err := ex.file.decode(stream)
+	if err != nil {
+		return
+	}
+	if r := ex.file.dict(stream.dict["Resources"]); r != nil {
+		resources = r
+	}
+	ex.run(data, resources, depth+1)
+}
+
+func (ex *pdfExtractor) show(obj interface{}) {
+	s, ok := obj.(pdfString)
+	if !ok || ex.font == nil {
+		return
+	}
+	text := ex.font.decode(s)
+	if text == "" {
+		return
+	}
+	if ex.line.Len() > 0 {
+		if ex.breakLine || math.Abs(ex.y-ex.shownY) >= 0.5 {
+			ex.flush()
+		} else if ex.gap && !strings.HasSuffix(ex.line.String(), " ") && !strings.HasPrefix(text, " ") {
+			ex.line.WriteString(" ")
+		}
+	}
+	ex.shownY, ex.breakLine, ex.gap = ex.y, false, false
+	ex.line.WriteString(text)
+	// 空白不计入字号
+	if size := ex.fontSize * ex.scale; strings.TrimSpace(text) != "" && size > ex.lineSize {
+		ex.lineSize = size
+	}
+}
+
+func (ex *pdfExtractor) flush() {
+	if text := strings.TrimSpace(ex.line.String()); text != "" {
+		ex.lines = append(ex.lines, pdfLine{text: text, size: ex.lineSize})
+	}
+	ex.line.Reset()
+	ex.lineSize = 0
+}
+
+// renderPDFLines 以字数最多的字号为正文字号，大 20% 以上且不超过 100 字的行作为标题
+func renderPDFLines(pages [][]pdfLine) string {
+	weights := map[float64]int{}
+	for _, lines := range pages {
+		for _, line := range lines {
+			weights[math.Round(line.size*10)/10] += len([]rune(line.text))
+		}
+	}
+	sizes := make([]float64, 0, len(weights))
+	for size := range weights {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if weights[sizes[i]] != weights[sizes[j]] {
+			return weights[sizes[i]] > weights[sizes[j]]
+		}
+		return sizes[i] < sizes[j]
+	})
+	body := 0.0
+	if len(sizes) > 0 {
+		body = sizes[0]
+	}
+	var b strings.Builder
+	for i, lines := range pages {
+		fmt.Fprintf(&b, "[Page %d]\n", i+1)
+		for _, line := range lines {
+			if body > 0 && line.size >= body*1.2 && len([]rune(line.text)) <= 100 {
+				b.WriteString("\n## " + line.text + "\n\n")
+				continue
+			}
+			b.WriteString(line.text + "\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,327 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Schema 编译后的 JSON Schema，支持结构化输出常用的关键字:
+// type、enum、const、properties、required、additionalProperties、items、
+// min/maxItems、uniqueItems、min/maxLength、pattern、minimum、maximum、
+// exclusiveMinimum、exclusiveMaximum、multipleOf、anyOf、oneOf、allOf、not，
+// 以及文档内的 $ref（如 #/$defs/node），其他关键字忽略
+type Schema struct {
+	// true / false 形式的 schema
+	always bool
+	never  bool
+
+	types    []string
+	enum     []interface{}
+	constVal interface{}
+	hasConst bool
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+
+	items       *Schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	anyOf []*Schema
+	oneOf []*Schema
+	allOf []*Schema
+	not   *Schema
+	ref   *Schema
+
+	// 在文档中的位置，用于编译错误
+	pointer string
+}
+
+// maxNodes schema 中最多的子 schema 数，校验的开销随节点数增长
+const maxNodes = 1000
+
+var validTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true,
+	"object": true, "array": true, "null": true,
+}
+
+// Compile 解析 schema，$ref 只能指向同一文档内的位置，不深入实例的 $ref 环返回错误
+func Compile(data []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	c := &compiler{root: root, cache: map[string]*Schema{}}
+	s, err := c.compile("", root)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type compiler struct {
+	root  interface{}
+	cache map[string]*Schema
+}
+
+func (c *compiler) compile(pointer string, node interface{}) (*Schema, error) {
+	if s, ok := c.cache[pointer]; ok {
+		return s, nil
+	}
+	if len(c.cache) >= maxNodes {
+		return nil, fmt.Errorf("%s: schema has more than %d subschemas", location(pointer), maxNodes)
+	}
+	s := &Schema{pointer: pointer}
+	// 先放入缓存，递归的 $ref 指向同一个对象
+	c.cache[pointer] = s
+	switch v := node.(type) {
+	case bool:
+		s.always, s.never = v, !v
+		return s, nil
+	case map[string]interface{}:
+		return s, c.fill(s, pointer, v)
+	default:
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", location(pointer))
+	}
+}
+
+func (c *compiler) fill(s *Schema, pointer string, m map[string]interface{}) error {
+	var err error
+	if ref, ok := m["$ref"].(string); ok {
+		target, ok := c.resolve(ref)
+		if !ok {
+			return fmt.Errorf("%s: cannot resolve $ref %q", location(pointer), ref)
+		}
+		if s.ref, err = c.compile(strings.TrimPrefix(ref, "#"), target); err != nil {
+			return err
+		}
+	}
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, _ := item.(string)
+			s.types = append(s.types, name)
+		}
+	default:
+		return fmt.Errorf("%s/type: must be a string or an array", location(pointer))
+	}
+	for _, t := range s.types {
+		if !validTypes[t] {
+			return fmt.Errorf("%s/type: unknown type %q", location(pointer), t)
+		}
+	}
+	if enum, ok := m["enum"].([]interface{}); ok {
+		s.enum = enum
+	}
+	if v, ok := m["const"]; ok {
+		s.constVal, s.hasConst = v, true
+	}
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = make(map[string]*Schema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = c.compile(pointer+"/properties/"+escape(name), prop); err != nil {
+				return err
+			}
+		}
+	}
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		if s.additionalProperties, err = c.compile(pointer+"/additionalProperties", v); err != nil {
+			return err
+		}
+	}
+	if v, ok := m["items"]; ok {
+		if s.items, err = c.compile(pointer+"/items", v); err != nil {
+			return err
+		}
+	}
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+	if pattern, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s/pattern: %v", location(pointer), err)
+		}
+	}
+	for key, dst := range map[string]**int{
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if v, ok := m[key].(float64); ok {
+			n := int(v)
+			*dst = &n
+		}
+	}
+	for key, dst := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf": &s.multipleOf,
+	} {
+		if v, ok := m[key].(float64); ok {
+			*dst = &v
+		}
+	}
+	for key, dst := range map[string]*[]*Schema{"anyOf": &s.anyOf, "oneOf": &s.oneOf, "allOf": &s.allOf} {
+		list, ok := m[key].([]interface{})
+		if !ok {
+			continue
+		}
+		for i, item := range list {
+			sub, err := c.compile(pointer+"/"+key+"/"+strconv.Itoa(i), item)
+			if err != nil {
+				return err
+			}
+			*dst = append(*dst, sub)
+		}
+	}
+	if v, ok := m["not"]; ok {
+		if s.not, err = c.compile(pointer+"/not", v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inPlace 不深入实例、对同一个值校验的子 schema
+func (s *Schema) inPlace() []*Schema {
+	subs := make([]*Schema, 0, len(s.anyOf)+len(s.oneOf)+len(s.allOf)+2)
+	subs = append(subs, s.anyOf...)
+	subs = append(subs, s.oneOf...)
+	subs = append(subs, s.allOf...)
+	if s.not != nil {
+		subs = append(subs, s.not)
+	}
+	if s.ref != nil {
+		subs = append(subs, s.ref)
+	}
+	return subs
+}
+
+// children 所有子 schema
+func (s *Schema) children() []*Schema {
+	subs := s.inPlace()
+	for _, prop := range s.properties {
+		subs = append(subs, prop)
+	}
+	if s.additionalProperties != nil {
+		subs = append(subs, s.additionalProperties)
+	}
+	if s.items != nil {
+		subs = append(subs, s.items)
+	}
+	return subs
+}
+
+// checkCycles 拒绝只经过 $ref、anyOf、oneOf、allOf、not 的环，如 {"$ref":"#"}，
+// 这样的 schema 校验时会对同一个值无限递归。经过 properties 或 items 的递归是合法的
+func checkCycles(root *Schema) error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[*Schema]int{}
+	var visit func(s *Schema) error
+	visit = func(s *Schema) error {
+		switch state[s] {
+		case visiting:
+			return fmt.Errorf("%s: $ref cycle does not descend into the instance", location(s.pointer))
+		case done:
+			return nil
+		}
+		state[s] = visiting
+		for _, sub := range s.inPlace() {
+			if err := visit(sub); err != nil {
+				return err
+			}
+		}
+		state[s] = done
+		return nil
+	}
+	seen := map[*Schema]bool{root: true}
+	queue := []*Schema{root}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		if err := visit(s); err != nil {
+			return err
+		}
+		for _, sub := range s.children() {
+			if !seen[sub] {
+				seen[sub] = true
+				queue = append(queue, sub)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve 解析文档内的 JSON Pointer，如 #/$defs/node
+func (c *compiler) resolve(ref string) (interface{}, bool) {
+	if ref == "#" {
+		return c.root, true
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	node := c.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := node.(type) {
+		case map[string]interface{}:
+			next, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			node = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func location(pointer string) string {
+	if pointer == "" {
+		return "schema"
+	}
+	return "schema" + pointer
+}
+
+// ErrInvalidJSON 内容不是合法的 JSON
+var ErrInvalidJSON = errors.New("content is not valid JSON")
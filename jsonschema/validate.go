@@ -0,0 +1,282 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// 最多返回的错误数，重新提示时不需要完整的列表
+	maxErrors = 20
+	// 校验的递归层数上限，超过时报错而不是继续深入
+	maxDepth = 256
+	// 一次校验最多检查的 (schema, 值) 次数，嵌套的 anyOf / oneOf 会让每个分支重复校验同一个值
+	maxSteps = 100000
+)
+
+// ErrTooComplex 校验超过 maxSteps，schema 由客户端提供，不能让它占用过多的 CPU
+var ErrTooComplex = fmt.Errorf("schema is too complex to validate, exceeds %d steps", maxSteps)
+
+// run 一次校验的状态，anyOf / oneOf / not 的分支共享同一个预算
+type run struct {
+	steps     int
+	exhausted bool
+}
+
+// ValidationError Path 为 JSON Pointer，根为空字符串
+type ValidationError struct {
+	Path string
+	Msg  string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return "(root): " + e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// ValidateJSON 解析并校验内容，返回解析后的值，内容不是 JSON 时错误为 ErrInvalidJSON，
+// 校验超过预算时为 ErrTooComplex
+func (s *Schema) ValidateJSON(data []byte) (interface{}, []ValidationError, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&v); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	if decoder.More() {
+		return nil, nil, fmt.Errorf("%w: unexpected data after the top-level value", ErrInvalidJSON)
+	}
+	errs, err := s.Validate(v)
+	return v, errs, err
+}
+
+// Validate 校验 json.Unmarshal 得到的值，超过预算时返回 ErrTooComplex
+func (s *Schema) Validate(v interface{}) ([]ValidationError, error) {
+	var errs []ValidationError
+	r := new(run)
+	s.validate(v, "", &errs, r, 0)
+	if r.exhausted {
+		return nil, ErrTooComplex
+	}
+	if len(errs) > maxErrors {
+		errs = errs[:maxErrors]
+	}
+	return errs, nil
+}
+
+func (s *Schema) valid(v interface{}, r *run, depth int) bool {
+	var errs []ValidationError
+	s.validate(v, "", &errs, r, depth)
+	return len(errs) == 0
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *[]ValidationError, r *run, depth int) {
+	if len(*errs) > maxErrors || r.exhausted {
+		return
+	}
+	if r.steps++; r.steps > maxSteps {
+		r.exhausted = true
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ValidationError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	if depth > maxDepth {
+		fail("exceeds the maximum nesting depth of %d", maxDepth)
+		return
+	}
+	depth++
+	if s.always {
+		return
+	}
+	if s.never {
+		fail("is not allowed")
+		return
+	}
+	if s.ref != nil {
+		s.ref.validate(v, path, errs, r, depth)
+	}
+	if len(s.types) > 0 && !matchType(s.types, v) {
+		fail("must be %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil && !containsValue(s.enum, v) {
+		fail("must be one of %s", marshal(s.enum))
+	}
+	if s.hasConst && !equal(s.constVal, v) {
+		fail("must be %s", marshal(s.constVal))
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, errs, r, depth)
+	case []interface{}:
+		s.validateArray(v, path, errs, r, depth)
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("must be < %v", *s.exclusiveMaximum)
+		}
+		if s.multipleOf != nil && *s.multipleOf > 0 {
+			if q := v / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("must be a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+	for _, sub := range s.allOf {
+		sub.validate(v, path, errs, r, depth)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.valid(v, r, depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one schema in anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(v, r, depth) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", matched)
+		}
+	}
+	if s.not != nil && s.not.valid(v, r, depth) {
+		fail("must not match the schema in not")
+	}
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string, errs *[]ValidationError, r *run, depth int) {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*errs = append(*errs, ValidationError{Path: path, Msg: fmt.Sprintf("missing required property %q", name)})
+		}
+	}
+	// 按名字排序，错误的顺序稳定
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := path + "/" + escape(name)
+		if prop, ok := s.properties[name]; ok {
+			prop.validate(v[name], child, errs, r, depth)
+			continue
+		}
+		if s.additionalProperties == nil {
+			continue
+		}
+		if s.additionalProperties.never {
+			*errs = append(*errs, ValidationError{Path: child, Msg: "is not an allowed property"})
+			continue
+		}
+		s.additionalProperties.validate(v[name], child, errs, r, depth)
+	}
+}
+
+func (s *Schema) validateArray(v []interface{}, path string, errs *[]ValidationError, r *run, depth int) {
+	if s.minItems != nil && len(v) < *s.minItems {
+		*errs = append(*errs, ValidationError{Path: path, Msg: fmt.Sprintf("must have at least %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		*errs = append(*errs, ValidationError{Path: path, Msg: fmt.Sprintf("must have at most %d items", *s.maxItems)})
+	}
+	if s.uniqueItems {
+		for i := range v {
+			for j := 0; j < i; j++ {
+				if equal(v[i], v[j]) {
+					*errs = append(*errs, ValidationError{Path: path, Msg: fmt.Sprintf("items %d and %d are equal", j, i)})
+				}
+			}
+		}
+	}
+	if s.items != nil {
+		for i, item := range v {
+			s.items.validate(item, fmt.Sprintf("%s/%d", path, i), errs, r, depth)
+		}
+	}
+}
+
+func matchType(types []string, v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf JSON 类型名，没有小数部分的数为 integer
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if equal(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func marshal(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
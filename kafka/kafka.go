@@ -0,0 +1,55 @@
+package kafka
+
+import (
+	"strings"
+
+	"github.com/Shopify/sarama"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+const (
+	// 消费组需要 0.10.2 以上，record header 需要 0.11 以上
+	DefaultVersion  = "1.0.0"
+	DefaultClientID = "chatgpt_server"
+)
+
+// Brokers kafka.brokers 配置，逗号分隔
+func Brokers() []string {
+	var brokers []string
+	for _, broker := range strings.Split(config.GetStr("kafka.brokers"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// Enabled 是否配置了 kafka
+func Enabled() bool {
+	return len(Brokers()) > 0
+}
+
+// NewConfig 生产者和消费者共用的客户端配置
+func NewConfig() (*sarama.Config, error) {
+	version, err := sarama.ParseKafkaVersion(config.GetDft("kafka.version", DefaultVersion))
+	if err != nil {
+		return nil, err
+	}
+	cfg := sarama.NewConfig()
+	cfg.Version = version
+	cfg.ClientID = config.GetDft("kafka.client_id", DefaultClientID)
+	return cfg, nil
+}
+
+// NewSyncProducer 等待所有副本确认的同步生产者
+func NewSyncProducer() (sarama.SyncProducer, error) {
+	cfg, err := NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Retry.Max = config.GetIntDft("kafka.producer_retries", 5)
+	return sarama.NewSyncProducer(Brokers(), cfg)
+}
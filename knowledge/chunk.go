@@ -0,0 +1,70 @@
+package knowledge
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Split 按字符数把文本切成有重叠的块，size 为每块最多字符数，overlap 为相邻块重叠的字符数
+// 切分点优先选在段落、句子结尾，其次是空白，避免把词和句子切断
+func Split(text string, size, overlap int) []string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := start + size
+		if end >= len(runes) {
+			end = len(runes)
+		} else {
+			end = breakPoint(runes, start+size/2, end)
+		}
+		if chunk := strings.TrimSpace(string(runes[start:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(runes) {
+			break
+		}
+		next := end - overlap
+		// 重叠部分从词的开头开始
+		for next > start && isWordRune(runes[next-1]) && isWordRune(runes[next]) {
+			next--
+		}
+		if next <= start {
+			next = end
+		}
+		start = next
+	}
+	return chunks
+}
+
+// breakPoint 在 [min, max) 中从后往前找切分点，返回切分点之后的下标
+func breakPoint(runes []rune, min, max int) int {
+	for _, isBreak := range []func(i int) bool{
+		func(i int) bool { return runes[i] == '\n' && i > 0 && runes[i-1] == '\n' },
+		func(i int) bool { return runes[i] == '\n' },
+		func(i int) bool {
+			return strings.ContainsRune("。！？!?；;", runes[i]) || (runes[i] == '.' && i+1 < len(runes) && unicode.IsSpace(runes[i+1]))
+		},
+		func(i int) bool { return unicode.IsSpace(runes[i]) || strings.ContainsRune("，,、", runes[i]) },
+	} {
+		for i := max - 1; i >= min; i-- {
+			if isBreak(i) {
+				return i + 1
+			}
+		}
+	}
+	return max
+}
+
+// isWordRune 英文单词和数字中的字符，中文每个字可以单独切分
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !unicode.Is(unicode.Han, r)
+}
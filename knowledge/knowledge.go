@@ -0,0 +1,159 @@
+package knowledge
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/vector"
+)
+
+const (
+	DefaultChunkSize      = 800
+	DefaultChunkOverlap   = 100
+	DefaultTopK           = 4
+	DefaultContextTokens  = 1500
+	DefaultContextWindow  = 4096
+	DefaultMaxDocumentLen = 1 << 20
+
+	DefaultPrompt = "请仅根据以下资料回答用户的问题，引用资料时在句末用 [编号] 标注来源。" +
+		"资料中没有相关信息时，请直接说明无法回答，不要编造。"
+
+	// 向量 metadata 中的字段
+	MetaDocumentID = "document_id"
+	MetaTitle      = "title"
+	MetaSource     = "source"
+	MetaChunk      = "chunk"
+	MetaText       = "text"
+
+	namespacePrefix = "kb_"
+)
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,60}$`)
+
+// Options 切分和检索配置
+type Options struct {
+	ChunkSize    int
+	ChunkOverlap int
+	TopK         int
+	// 低于该相似度的块不使用
+	MinScore float64
+	// 注入到 system prompt 的资料最多的 token 数
+	ContextTokens int
+	// 模型的上下文长度，资料和消息、max_tokens 的总和不超过该值
+	ContextWindow int
+	// 单个文档最多的字符数
+	MaxDocumentLen int
+	Prompt         string
+	// 生成向量的模型，修改后需要重新上传文档
+	Model string
+}
+
+// GetOptions 读取 knowledge.* 配置
+func GetOptions() Options {
+	minScore, _ := strconv.ParseFloat(config.GetDft("knowledge.min_score", "0"), 64)
+	return Options{
+		ChunkSize:      config.GetIntDft("knowledge.chunk_size", DefaultChunkSize),
+		ChunkOverlap:   config.GetIntDft("knowledge.chunk_overlap", DefaultChunkOverlap),
+		TopK:           config.GetIntDft("knowledge.top_k", DefaultTopK),
+		MinScore:       minScore,
+		ContextTokens:  config.GetIntDft("knowledge.context_tokens", DefaultContextTokens),
+		ContextWindow:  config.GetIntDft("knowledge.context_window", DefaultContextWindow),
+		MaxDocumentLen: config.GetIntDft("knowledge.max_document_len", DefaultMaxDocumentLen),
+		Prompt:         config.GetDft("knowledge.prompt", DefaultPrompt),
+		Model:          config.GetDft("knowledge.model", models.DefaultEmbeddingModel),
+	}
+}
+
+// Namespace 知识库在 vector.Knowledge() 中的 namespace
+func Namespace(kbID string) (string, error) {
+	if !idPattern.MatchString(kbID) {
+		return "", fmt.Errorf("knowledge_base_id must match %s", idPattern)
+	}
+	return namespacePrefix + kbID, nil
+}
+
+// ValidID 文档 ID 的格式与知识库 ID 相同
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// ChunkID 块在向量索引中的 ID
+func ChunkID(documentID string, index int) string {
+	return documentID + "#" + strconv.Itoa(index)
+}
+
+// Chunk 检索到的一块资料
+type Chunk struct {
+	ID         string
+	DocumentID string
+	Title      string
+	Source     string
+	Index      int
+	Text       string
+	Score      float32
+}
+
+// ChunkFromMatch 从向量查询结果还原资料块
+func ChunkFromMatch(match vector.Match) Chunk {
+	index, _ := strconv.Atoi(match.Metadata[MetaChunk])
+	return Chunk{
+		ID:         match.ID,
+		DocumentID: match.Metadata[MetaDocumentID],
+		Title:      match.Metadata[MetaTitle],
+		Source:     match.Metadata[MetaSource],
+		Index:      index,
+		Text:       match.Metadata[MetaText],
+		Score:      match.Score,
+	}
+}
+
+// EstimateTokens 粗略估算 token 数，中文按每字一个，其他按每 4 个字符一个
+func EstimateTokens(text string) int {
+	han := 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			han++
+		}
+	}
+	return han + (utf8.RuneCountInString(text)-han+3)/4
+}
+
+// BuildContext 按相似度顺序拼接资料，总长度不超过 budget 个 token，返回 system prompt 和对应的引用
+// 引用的 Index 即 prompt 中的 [编号]
+func BuildContext(prompt string, chunks []Chunk, budget int) (string, []models.Citation) {
+	var (
+		b         strings.Builder
+		citations []models.Citation
+	)
+	b.WriteString(prompt)
+	used := EstimateTokens(prompt)
+	for _, chunk := range chunks {
+		n := len(citations) + 1
+		source := fmt.Sprintf("\n\n[%d] %s\n%s", n, chunk.Title, chunk.Text)
+		tokens := EstimateTokens(source)
+		if used+tokens > budget {
+			continue
+		}
+		used += tokens
+		b.WriteString(source)
+		citations = append(citations, models.Citation{
+			Index:      n,
+			DocumentID: chunk.DocumentID,
+			ChunkID:    chunk.ID,
+			Title:      chunk.Title,
+			Source:     chunk.Source,
+			Score:      chunk.Score,
+		})
+	}
+	if len(citations) == 0 {
+		return "", nil
+	}
+	return b.String(), citations
+}
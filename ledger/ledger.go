@@ -0,0 +1,98 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+)
+
+const (
+	KindTranscription = "transcription"
+	KindSpeech        = "speech"
+)
+
+// Entry 一次按时长计费的用量
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	UserID    int64     `json:"user_id"`
+	Kind      string    `json:"kind"`
+	Model     string    `json:"model"`
+	Seconds   float64   `json:"seconds"`
+	// 时长由字数估算而不是来自音频本身
+	Estimated  bool `json:"estimated,omitempty"`
+	Characters int  `json:"characters,omitempty"`
+}
+
+type Ledger interface {
+	Record(ctx context.Context, entry Entry)
+}
+
+var audioSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "usage",
+	Subsystem: "audio",
+	Name:      "seconds_count",
+	Help:      "The total seconds of audio transcribed or synthesized",
+}, []string{"kind", "model"})
+
+func init() {
+	prometheus.MustRegister(audioSeconds)
+}
+
+var ledgerFile = struct {
+	f *os.File
+	sync.Mutex
+}{}
+
+// InitLedger 配置了 ledger.file 时用量按行写入该文件，否则写日志
+func InitLedger() {
+	path := config.GetStr("ledger.file")
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		panic("open ledger file error: " + err.Error())
+	}
+	ledgerFile.f = f
+}
+
+type ledger struct {
+}
+
+func NewLedger() Ledger {
+	return new(ledger)
+}
+
+func (l ledger) Record(ctx context.Context, entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.RequestID = log.ParseRequestID(ctx)
+	audioSeconds.WithLabelValues(entry.Kind, entry.Model).Add(entry.Seconds)
+	ledgerFile.Lock()
+	defer ledgerFile.Unlock()
+	if ledgerFile.f == nil {
+		log.WithCtxFields(ctx, log.Fields{
+			"ledger": entry,
+		}).Infoln("usage ledger")
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = ledgerFile.f.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{
+			"ledger": entry,
+			"error":  err,
+		}).Errorln("write ledger error")
+	}
+}
@@ -2,48 +2,24 @@ package main
 
 import (
 	"fmt"
-	"net/http"
 	"os"
 
-	"meipian.cn/meigo/v2/util"
-
-	"meipian.cn/meigo/v2/config"
-	"meipian.cn/meigo/v2/log"
-	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
-
-	"github.com/facebookgo/grace/gracehttp"
 	"github.com/urfave/cli/v2"
 
-	"chatgpt_server/repos"
-	"chatgpt_server/routes"
+	"chatgpt_server/cmd"
 )
 
-func startListen() {
-	engin := util.NewGin()
-	routes.RouteInit(engin)
-
-	addr := ":" + config.GetDft("port", "10100")
-	s := &http.Server{
-		Addr:    addr,
-		Handler: engin,
-	}
-	fmt.Println("Server listen on", addr)
-	err := gracehttp.Serve(s)
-	if err != nil {
-		fmt.Println(err)
-		log.Err(err.Error())
-	}
-}
-
 func main() {
-	zipkinUtil.InitZipkinWithApolloConfig()
-	repos.InitChatGPTs()
 	app := cli.NewApp()
-	app.Name = "user_feed go server"
+	app.Name = "chatgpt_server"
+	app.Usage = "ChatGPT proxy server"
+	app.Commands = cmd.Commands()
 	app.Action = func(c *cli.Context) error {
 		fmt.Println("Run Http Server")
-		startListen()
-		return nil
+		return cmd.DefaultAction(c)
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	app.Run(os.Args)
 }
@@ -0,0 +1,158 @@
+package mock
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"chatgpt_server/models"
+)
+
+const (
+	// 压缩格式按 128kbps 计算时长
+	compressedBytesPerSecond = 16000
+	// pcm 与上游一致，24kHz、16 位、单声道
+	pcmBytesPerSecond = 48000
+	// 合成时每秒朗读的字符数
+	speechCharsPerSecond = 15
+)
+
+// transcriptions 按音频大小计算时长，回答固定的文字并平均分配时间戳
+func (u *upstream) transcriptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !u.wait(w, r) {
+		return
+	}
+	duration := audioDuration(data)
+	language := r.FormValue("language")
+	if language == "" {
+		language = "en"
+	}
+	text := fmt.Sprintf("This is a mock transcription of %s.", filepath.Base(header.Filename))
+	res := models.RespTranscription{Language: language, Duration: duration, Text: text}
+	granularities := r.MultipartForm.Value["timestamp_granularities[]"]
+	if len(granularities) == 0 || containsValue(granularities, models.TimestampsSegment) {
+		res.Segments = []models.TranscriptionSegment{{Start: 0, End: duration, Text: text}}
+	}
+	if containsValue(granularities, models.TimestampsWord) {
+		words := strings.Fields(text)
+		step := duration / float64(len(words))
+		for i, word := range words {
+			res.Words = append(res.Words, models.TranscriptionWord{
+				Word:  strings.Trim(word, "."),
+				Start: float64(i) * step,
+				End:   float64(i+1) * step,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func containsValue(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// audioDuration wav 按文件头中的码率计算，其他格式按 128kbps 估算
+func audioDuration(data []byte) float64 {
+	if len(data) > 44 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE" {
+		if byteRate := binary.LittleEndian.Uint32(data[28:32]); byteRate > 0 {
+			return float64(len(data)-44) / float64(byteRate)
+		}
+	}
+	return float64(len(data)) / compressedBytesPerSecond
+}
+
+// speech 返回静音，时长按字数和语速计算，分多个分片发送
+func (u *upstream) speech(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req := new(models.ReqSpeech)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !u.wait(w, r) {
+		return
+	}
+	speed := req.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	seconds := float64(utf8.RuneCountInString(req.Input)) / speechCharsPerSecond / speed
+	var data []byte
+	switch req.ResponseFormat {
+	case "pcm":
+		data = make([]byte, int(seconds*pcmBytesPerSecond)&^1)
+	case "wav":
+		data = wavSilence(int(seconds*pcmBytesPerSecond) &^ 1)
+	default:
+		data = make([]byte, int(seconds*compressedBytesPerSecond))
+	}
+	w.Header().Set("Content-Type", models.SpeechContentType(req.ResponseFormat))
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	const chunks = 4
+	size := len(data)/chunks + 1
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := w.Write(data[start:end]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(u.opts.ChunkInterval):
+		}
+	}
+}
+
+// wavSilence 24kHz、16 位、单声道的 wav 文件
+func wavSilence(n int) []byte {
+	buf := make([]byte, 44+n)
+	copy(buf, "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+n))
+	copy(buf[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1)
+	binary.LittleEndian.PutUint16(buf[22:], 1)
+	binary.LittleEndian.PutUint32(buf[24:], 24000)
+	binary.LittleEndian.PutUint32(buf[28:], pcmBytesPerSecond)
+	binary.LittleEndian.PutUint16(buf[32:], 2)
+	binary.LittleEndian.PutUint16(buf[34:], 16)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(n))
+	return buf
+}
@@ -0,0 +1,95 @@
+package mock
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"chatgpt_server/models"
+)
+
+// EmbeddingDim mock 向量的维度
+const EmbeddingDim = 256
+
+// embeddings 按词哈希生成确定的向量，含相同词越多的文本越相似
+func (u *upstream) embeddings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req := new(models.ReqEmbeddings)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !u.wait(w, r) {
+		return
+	}
+	res := models.RespEmbeddings{
+		Object: "list",
+		Model:  req.Model,
+		Data:   make([]models.Embedding, len(req.Input)),
+	}
+	for i, input := range req.Input {
+		res.Data[i] = models.Embedding{Object: "embedding", Index: i, Embedding: Embed(input)}
+		res.Usage.PromptTokens += estimateTokens(input)
+	}
+	res.Usage.TotalTokens = res.Usage.PromptTokens
+	writeJSON(w, http.StatusOK, res)
+}
+
+// Embed 英文按单词、中文按单字哈希到 EmbeddingDim 维并归一化
+func Embed(text string) []float32 {
+	vector := make([]float32, EmbeddingDim)
+	for _, word := range words(text) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		sum := h.Sum32()
+		sign := float32(1)
+		if sum&(1<<31) != 0 {
+			sign = -1
+		}
+		vector[sum%EmbeddingDim] += sign
+	}
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		vector[0] = 1
+		return vector
+	}
+	for i := range vector {
+		vector[i] /= float32(math.Sqrt(norm))
+	}
+	return vector
+}
+
+func words(text string) []string {
+	var (
+		result []string
+		word   strings.Builder
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			result = append(result, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			result = append(result, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return result
+}
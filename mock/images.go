@@ -0,0 +1,64 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"time"
+
+	"chatgpt_server/models"
+)
+
+// images 按提示词的哈希生成纯色 png，url 格式返回不可访问的占位地址
+func (u *upstream) images(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req := new(models.ReqImageGenerate)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(req.Size, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid size")
+		return
+	}
+	if !u.wait(w, r) {
+		return
+	}
+	n := req.N
+	if n <= 0 {
+		n = 1
+	}
+	res := models.RespImages{Created: time.Now().Unix(), Data: make([]models.ImageData, n)}
+	for i := range res.Data {
+		data := solidPNG(width, height, fmt.Sprintf("%s#%d", req.Prompt, i))
+		if req.ResponseFormat == models.ImageFormatB64 {
+			res.Data[i].B64JSON = base64.StdEncoding.EncodeToString(data)
+		} else {
+			res.Data[i].URL = fmt.Sprintf("https://mock.openai.local/images/%s.png", newID("img"))
+		}
+		if req.Model == "dall-e-3" {
+			res.Data[i].RevisedPrompt = req.Prompt
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func solidPNG(width, height int, seed string) []byte {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	sum := h.Sum32()
+	palette := color.Palette{color.RGBA{uint8(sum), uint8(sum >> 8), uint8(sum >> 16), 255}}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewPaletted(image.Rect(0, 0, width, height), palette))
+	return buf.Bytes()
+}
@@ -0,0 +1,84 @@
+package mock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"chatgpt_server/models"
+)
+
+// Reply 一次脚本回答，Status >= 400 时返回错误
+type Reply struct {
+	Content string `json:"content"`
+	// 为空时为 stop，超过 max_tokens 时为 length
+	FinishReason string `json:"finish_reason"`
+	Status       int    `json:"status"`
+	Error        string `json:"error"`
+	// 不为空时返回工具调用，finish_reason 为 tool_calls
+	ToolCalls []models.FunctionCall `json:"tool_calls"`
+}
+
+// Rule 最后一条 user 消息包含 Match 时使用 Replies，Match 为空时匹配所有请求。
+// 同一规则多次命中时按顺序返回 Replies，用完后重复最后一个
+type Rule struct {
+	Match   string  `json:"match"`
+	Replies []Reply `json:"replies"`
+	next    int
+}
+
+// Script 按顺序匹配的规则，都不匹配时回显
+type Script struct {
+	rules []*Rule
+	sync.Mutex
+}
+
+// LoadScript 读取 jsonl 文件，每行一条 Rule
+func LoadScript(file string) (*Script, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	script := new(Script)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := new(Rule)
+		if err := json.Unmarshal([]byte(text), rule); err != nil {
+			return nil, fmt.Errorf("mock script line %d: %w", line, err)
+		}
+		if len(rule.Replies) == 0 {
+			return nil, fmt.Errorf("mock script line %d: no replies", line)
+		}
+		script.rules = append(script.rules, rule)
+	}
+	return script, scanner.Err()
+}
+
+// Reply 取匹配 prompt 的下一个回答
+func (s *Script) Reply(prompt string) (Reply, bool) {
+	if s == nil {
+		return Reply{}, false
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, rule := range s.rules {
+		if !strings.Contains(prompt, rule.Match) {
+			continue
+		}
+		reply := rule.Replies[rule.next]
+		if rule.next < len(rule.Replies)-1 {
+			rule.next++
+		}
+		return reply, true
+	}
+	return Reply{}, false
+}
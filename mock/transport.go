@@ -0,0 +1,92 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// NewTransport 在进程内调用 handler 的 RoundTripper，不经过网络
+func NewTransport(handler http.Handler) http.RoundTripper {
+	return &transport{handler: handler}
+}
+
+type transport struct {
+	handler http.Handler
+}
+
+// responseWriter 写入的内容通过 pipe 交给调用方，第一次写入时返回响应头
+type responseWriter struct {
+	header  http.Header
+	body    *io.PipeWriter
+	status  int
+	started chan struct{}
+	once    sync.Once
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		close(w.started)
+	})
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *responseWriter) Flush() {
+}
+
+// pipeBody 调用方关闭 body 时取消 handler 的 ctx
+type pipeBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (b *pipeBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	reader, writer := io.Pipe()
+	w := &responseWriter{
+		header:  http.Header{},
+		body:    writer,
+		started: make(chan struct{}),
+	}
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	go func() {
+		defer writer.Close()
+		t.handler.ServeHTTP(w, req.WithContext(ctx))
+		w.WriteHeader(http.StatusOK)
+	}()
+	select {
+	case <-w.started:
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header.Clone(),
+		Body:          &pipeBody{PipeReader: reader, cancel: cancel},
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
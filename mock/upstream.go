@@ -0,0 +1,400 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"chatgpt_server/models"
+)
+
+// Options 本地 mock 上游的行为
+type Options struct {
+	// 返回首个字节前的延迟
+	Latency time.Duration
+	// stream 模式每个分片之间的间隔
+	ChunkInterval time.Duration
+	// 随机返回错误的比例，0 ~ 1
+	ErrorRate float64
+	// 随机错误的状态码，默认 500
+	ErrorStatus int
+	// 脚本回答，为 nil 或不匹配时回显最后一条 user 消息
+	Script *Script
+}
+
+type upstream struct {
+	opts Options
+}
+
+// NewUpstream 返回兼容 OpenAI 接口格式的 mock 上游
+func NewUpstream(opts Options) http.Handler {
+	if opts.ErrorStatus == 0 {
+		opts.ErrorStatus = http.StatusInternalServerError
+	}
+	u := &upstream{opts: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", u.chatCompletions)
+	mux.HandleFunc("/v1/embeddings", u.embeddings)
+	mux.HandleFunc("/v1/images/generations", u.images)
+	mux.HandleFunc("/v1/audio/transcriptions", u.transcriptions)
+	mux.HandleFunc("/v1/audio/speech", u.speech)
+	mux.HandleFunc("/v1/models", u.models)
+	return mux
+}
+
+// imageTokens 每张图片计入 prompt_tokens 的数量
+const imageTokens = 85
+
+// estimateTokens 粗略估算 token 数
+func estimateTokens(text string) int {
+	return utf8.RuneCountInString(text)/4 + 1
+}
+
+// truncateTokens 按 estimateTokens 的估算截断到 maxTokens
+func truncateTokens(text string, maxTokens int) (string, bool) {
+	if maxTokens <= 0 || estimateTokens(text) <= maxTokens {
+		return text, false
+	}
+	runes := []rune(text)
+	return string(runes[:(maxTokens-1)*4]), true
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// errorTypes 与上游各状态码的 error.type 一致
+var errorTypes = map[int]string{
+	http.StatusBadRequest:      "invalid_request_error",
+	http.StatusUnauthorized:    "invalid_request_error",
+	http.StatusTooManyRequests: "requests",
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	errType := errorTypes[status]
+	if errType == "" {
+		errType = "server_error"
+	}
+	writeJSON(w, status, map[string]models.OpenApiError{
+		"error": {Message: msg, Type: errType},
+	})
+}
+
+func (u *upstream) models(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data": []map[string]string{
+			{"id": "gpt-3.5-turbo", "object": "model", "owned_by": "mock"},
+		},
+	})
+}
+
+func lastUserMessage(messages []models.ChatGPTMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Text()
+		}
+	}
+	return ""
+}
+
+// continued finish_reason 为 length 后续写时，最后一条 user 消息之后已有的回答
+func continued(messages []models.ChatGPTMessage) string {
+	var sent []string
+	for i := len(messages) - 1; i >= 0 && messages[i].Role == "assistant"; i-- {
+		sent = append([]string{messages[i].Content}, sent...)
+	}
+	return strings.Join(sent, "")
+}
+
+func promptTokens(messages []models.ChatGPTMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		// 图片按 low detail 的固定值计算
+		tokens += estimateTokens(message.Text()) + imageTokens*len(message.Images())
+	}
+	return tokens
+}
+
+// wait 模拟延迟和随机错误，返回 false 时已写入错误或请求已取消
+func (u *upstream) wait(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case <-time.After(u.opts.Latency):
+	case <-r.Context().Done():
+		return false
+	}
+	if u.opts.ErrorRate > 0 && rand.Float64() < u.opts.ErrorRate {
+		writeError(w, u.opts.ErrorStatus, "mock error")
+		return false
+	}
+	return true
+}
+
+// reply 按脚本、随机错误和 max_tokens 决定回答，返回 false 时已写入错误
+// sent 为续写前已经返回的内容，回显时从这之后继续
+func (u *upstream) reply(w http.ResponseWriter, r *http.Request, prompt, sent string, maxTokens int) (Reply, bool) {
+	if !u.wait(w, r) {
+		return Reply{}, false
+	}
+	reply, ok := u.opts.Script.Reply(prompt)
+	if !ok {
+		reply = Reply{Content: strings.TrimPrefix("echo: "+prompt, sent)}
+	}
+	if reply.Status >= http.StatusBadRequest {
+		msg := reply.Error
+		if msg == "" {
+			msg = http.StatusText(reply.Status)
+		}
+		writeError(w, reply.Status, msg)
+		return Reply{}, false
+	}
+	if len(reply.ToolCalls) > 0 {
+		reply.FinishReason = models.FinishReasonToolCalls
+		return reply, true
+	}
+	content, truncated := truncateTokens(reply.Content, maxTokens)
+	reply.Content = content
+	if truncated {
+		reply.FinishReason = "length"
+	}
+	if reply.FinishReason == "" {
+		reply.FinishReason = "stop"
+	}
+	return reply, true
+}
+
+func newID(prefix string) string {
+	return prefix + "-mock-" + uuid.NewString()
+}
+
+// choiceCount 请求的 n，至少为 1
+func choiceCount(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (u *upstream) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	req := new(models.ReqChatGPT)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reply, ok := u.reply(w, r, lastUserMessage(req.Message), continued(req.Message), req.MaxTokens)
+	if !ok {
+		return
+	}
+	id := newID("chatcmpl")
+	if req.Stream {
+		u.stream(w, r, req, id, reply)
+		return
+	}
+	n := choiceCount(req.N)
+	choices := make([]models.ChatChoice, 0, n)
+	for i := 0; i < n; i++ {
+		choices = append(choices, models.ChatChoice{
+			Index: i,
+			Message: models.ChatGPTMessage{
+				Role:      models.RoleAssistant,
+				Content:   reply.Content,
+				ToolCalls: toolCalls(reply.ToolCalls, false),
+			},
+			FinishReason: reply.FinishReason,
+			Logprobs:     logprobs(req, splitChunks(reply.Content)...),
+		})
+	}
+	completion := estimateTokens(reply.Content+argumentsOf(reply.ToolCalls)) * n
+	prompt := promptTokens(req.Message)
+	writeJSON(w, http.StatusOK, models.RespChatGPT{
+		ID:                id,
+		Object:            "chat.completion",
+		Created:           time.Now().Unix(),
+		Model:             req.Model,
+		SystemFingerprint: fingerprint(req),
+		Choices:           choices,
+		Usage: models.ChatUsage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+		},
+	})
+}
+
+// logprobs 请求 logprobs 时每个分片作为一个 token，概率按长度递减，候选为 token 的大小写变体
+func logprobs(req *models.ReqChatGPT, tokens ...string) *models.ChoiceLogprobs {
+	if !req.Logprobs {
+		return nil
+	}
+	result := &models.ChoiceLogprobs{Content: []models.TokenLogprob{}}
+	for _, token := range tokens {
+		top := models.TopLogprob{Token: token, Logprob: -float64(len(token)) / 10, Bytes: tokenBytes(token)}
+		item := models.TokenLogprob{TopLogprob: top, TopLogprobs: []models.TopLogprob{}}
+		if req.TopLogprobs != nil {
+			candidates := []string{token, strings.ToUpper(token), strings.ToLower(token) + " "}
+			for i := 0; i < *req.TopLogprobs && i < len(candidates); i++ {
+				item.TopLogprobs = append(item.TopLogprobs, models.TopLogprob{
+					Token:   candidates[i],
+					Logprob: top.Logprob - float64(i),
+					Bytes:   tokenBytes(candidates[i]),
+				})
+			}
+		}
+		result.Content = append(result.Content, item)
+	}
+	return result
+}
+
+func tokenBytes(token string) []int {
+	b := make([]int, 0, len(token))
+	for i := 0; i < len(token); i++ {
+		b = append(b, int(token[i]))
+	}
+	return b
+}
+
+// fingerprint 指定 seed 时返回固定的 system_fingerprint
+func fingerprint(req *models.ReqChatGPT) string {
+	if req.Seed == nil {
+		return ""
+	}
+	return "fp_mock"
+}
+
+// toolCalls 脚本中的函数调用转为 tool_calls，stream 时带 index
+func toolCalls(calls []models.FunctionCall, stream bool) []models.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	result := make([]models.ToolCall, 0, len(calls))
+	for i, call := range calls {
+		toolCall := models.ToolCall{
+			ID:       newID("call"),
+			Type:     models.ToolTypeFunction,
+			Function: call,
+		}
+		if stream {
+			index := i
+			toolCall.Index = &index
+		}
+		result = append(result, toolCall)
+	}
+	return result
+}
+
+func argumentsOf(calls []models.FunctionCall) string {
+	var b strings.Builder
+	for _, call := range calls {
+		b.WriteString(call.Arguments)
+	}
+	return b.String()
+}
+
+// splitArguments 工具调用参数按固定长度切分，模拟上游的参数 delta
+func splitArguments(arguments string) []string {
+	const size = 8
+	var chunks []string
+	for len(arguments) > size {
+		chunks = append(chunks, arguments[:size])
+		arguments = arguments[size:]
+	}
+	if arguments != "" {
+		chunks = append(chunks, arguments)
+	}
+	return chunks
+}
+
+// splitChunks 按词切分，中文按字切分，模拟上游每个 token 一个分片
+func splitChunks(text string) []string {
+	if text == "" {
+		return nil
+	}
+	var chunks []string
+	for _, word := range strings.SplitAfter(text, " ") {
+		if utf8.RuneCountInString(word) <= 4 {
+			chunks = append(chunks, word)
+			continue
+		}
+		for _, r := range word {
+			chunks = append(chunks, string(r))
+		}
+	}
+	return chunks
+}
+
+func (u *upstream) stream(w http.ResponseWriter, r *http.Request, req *models.ReqChatGPT, id string, reply Reply) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	n := choiceCount(req.N)
+	chunk := models.RespChatGPTChunk{
+		ID:                id,
+		Object:            "chat.completion.chunk",
+		Created:           time.Now().Unix(),
+		Model:             req.Model,
+		SystemFingerprint: fingerprint(req),
+	}
+	// send 每个 choice 发送一个同样的分片
+	send := func(choice models.ChatChunkChoice) {
+		for i := 0; i < n; i++ {
+			choice.Index = i
+			chunk.Choices = []models.ChatChunkChoice{choice}
+			body, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", body)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	wait := func() bool {
+		select {
+		case <-time.After(u.opts.ChunkInterval):
+			return true
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	send(models.ChatChunkChoice{Delta: models.ChatGPTMessage{Role: models.RoleAssistant}})
+	for _, content := range splitChunks(reply.Content) {
+		if !wait() {
+			return
+		}
+		send(models.ChatChunkChoice{
+			Delta:    models.ChatGPTMessage{Content: content},
+			Logprobs: logprobs(req, content),
+		})
+	}
+	// 并行的工具调用依次返回，第一个分片带 id 和函数名，之后只有参数
+	for _, call := range toolCalls(reply.ToolCalls, true) {
+		arguments := call.Function.Arguments
+		call.Function.Arguments = ""
+		send(models.ChatChunkChoice{Delta: models.ChatGPTMessage{ToolCalls: []models.ToolCall{call}}})
+		for _, part := range splitArguments(arguments) {
+			if !wait() {
+				return
+			}
+			send(models.ChatChunkChoice{Delta: models.ChatGPTMessage{ToolCalls: []models.ToolCall{{
+				Index:    call.Index,
+				Function: models.FunctionCall{Arguments: part},
+			}}}})
+		}
+	}
+	send(models.ChatChunkChoice{FinishReason: reply.FinishReason})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultTranscriptionModel = "whisper-1"
+	DefaultSpeechModel        = "tts-1"
+	DefaultSpeechVoice        = "alloy"
+	DefaultSpeechFormat       = "mp3"
+
+	TimestampsNone    = "none"
+	TimestampsSegment = "segment"
+	TimestampsWord    = "word"
+
+	// 合成的文字最多字符数
+	MaxSpeechInputLen = 4096
+)
+
+var (
+	audioExts     = []string{".flac", ".m4a", ".mp3", ".mp4", ".mpeg", ".mpga", ".oga", ".ogg", ".wav", ".webm"}
+	speechVoices  = []string{"alloy", "echo", "fable", "onyx", "nova", "shimmer"}
+	speechModels  = []string{"tts-1", "tts-1-hd"}
+	languageCode  = regexp.MustCompile(`^[a-z]{2}$`)
+	speechFormats = map[string]string{
+		"mp3":  "audio/mpeg",
+		"opus": "audio/ogg",
+		"aac":  "audio/aac",
+		"flac": "audio/flac",
+		"wav":  "audio/wav",
+		"pcm":  "audio/pcm",
+	}
+)
+
+// ReqTranscription 上传的音频，Timestamps 为 segment 或 word 时返回对应粒度的时间戳
+type ReqTranscription struct {
+	Filename    string
+	Data        []byte
+	Model       string
+	Language    string
+	Prompt      string
+	Temperature float64
+	Timestamps  string
+	UserID      int64
+}
+
+func (req *ReqTranscription) Validate() error {
+	if len(req.Data) == 0 {
+		return fieldError("file", "is required")
+	}
+	if !containsString(audioExts, strings.ToLower(filepath.Ext(req.Filename))) {
+		return fieldError("file", "must be one of %v", audioExts)
+	}
+	if req.Model == "" {
+		req.Model = DefaultTranscriptionModel
+	}
+	if req.Language != "" && !languageCode.MatchString(req.Language) {
+		return fieldError("language", "must be an ISO-639-1 code such as zh or en")
+	}
+	if req.Temperature < 0 || req.Temperature > 1 {
+		return fieldError("temperature", "must be between 0 and 1")
+	}
+	switch req.Timestamps {
+	case "":
+		req.Timestamps = TimestampsNone
+	case TimestampsNone, TimestampsSegment, TimestampsWord:
+	default:
+		return fieldError("timestamps", "must be none, segment or word")
+	}
+	return nil
+}
+
+// CreateReqTranscription 上游的 multipart 请求，总是请求 verbose_json 以得到音频时长
+func CreateReqTranscription(req ReqTranscription) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("file", filepath.Base(req.Filename))
+	if err != nil {
+		panic(err)
+	}
+	part.Write(req.Data)
+	w.WriteField("model", req.Model)
+	w.WriteField("response_format", "verbose_json")
+	if req.Language != "" {
+		w.WriteField("language", req.Language)
+	}
+	if req.Prompt != "" {
+		w.WriteField("prompt", req.Prompt)
+	}
+	if req.Temperature > 0 {
+		w.WriteField("temperature", strconv.FormatFloat(req.Temperature, 'f', -1, 64))
+	}
+	if req.Timestamps != TimestampsNone {
+		w.WriteField("timestamp_granularities[]", req.Timestamps)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return body, w.FormDataContentType()
+}
+
+type TranscriptionSegment struct {
+	ID    int     `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+type TranscriptionWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// AudioUsage 按音频时长计费的用量
+type AudioUsage struct {
+	Seconds float64 `json:"seconds"`
+}
+
+// https://platform.openai.com/docs/api-reference/audio/verbose-json-object
+type RespTranscription struct {
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration"`
+	Text     string                 `json:"text"`
+	Segments []TranscriptionSegment `json:"segments,omitempty"`
+	Words    []TranscriptionWord    `json:"words,omitempty"`
+	Usage    *AudioUsage            `json:"usage,omitempty"`
+	Error    *OpenApiError          `json:"error,omitempty"`
+}
+
+func ToRespTranscription(body []byte) (*RespTranscription, error) {
+	msg := new(RespTranscription)
+	err := json.Unmarshal(body, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// https://platform.openai.com/docs/api-reference/audio/createSpeech
+type ReqSpeech struct {
+	Model          string  `json:"model,omitempty"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice,omitempty"`
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+type ReqSpeechFromClient struct {
+	ReqSpeech
+	UserID int64 `json:"user_id"`
+}
+
+func (req *ReqSpeech) Validate() error {
+	if req.Model == "" {
+		req.Model = DefaultSpeechModel
+	}
+	if !containsString(speechModels, req.Model) {
+		return fieldError("model", "must be one of %v", speechModels)
+	}
+	if strings.TrimSpace(req.Input) == "" {
+		return fieldError("input", "is required")
+	}
+	if utf8.RuneCountInString(req.Input) > MaxSpeechInputLen {
+		return fieldError("input", "at most %d characters", MaxSpeechInputLen)
+	}
+	if req.Voice == "" {
+		req.Voice = DefaultSpeechVoice
+	}
+	if !containsString(speechVoices, req.Voice) {
+		return fieldError("voice", "must be one of %v", speechVoices)
+	}
+	if req.ResponseFormat == "" {
+		req.ResponseFormat = DefaultSpeechFormat
+	}
+	if _, ok := speechFormats[req.ResponseFormat]; !ok {
+		return fieldError("response_format", "must be mp3, opus, aac, flac, wav or pcm")
+	}
+	if req.Speed == 0 {
+		req.Speed = 1
+	}
+	if req.Speed < 0.25 || req.Speed > 4 {
+		return fieldError("speed", "must be between 0.25 and 4")
+	}
+	return nil
+}
+
+// SpeechContentType 合成音频格式对应的 Content-Type
+func SpeechContentType(format string) string {
+	if contentType, ok := speechFormats[format]; ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func CreateReqSpeech(req ReqSpeech) *bytes.Buffer {
+	body, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewBuffer(body)
+}
+
+// SpeechAudio 合成的音频流，读完后需要 Close
+type SpeechAudio struct {
+	ContentType string
+	Body        io.ReadCloser
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// BatchItem 批量请求中的一项，CustomID 为空时使用序号
+type BatchItem struct {
+	CustomID string              `json:"custom_id"`
+	Body     ReqChatGPTFromCient `json:"body"`
+	// JSONL 中无法解析的行，该项直接返回错误
+	Invalid string `json:"-"`
+}
+
+// ReqBatch UserID 和 Caller 覆盖每一项中的值，批量请求的所有项计入同一调用方
+type ReqBatch struct {
+	Requests []BatchItem `json:"requests"`
+	UserID   int64       `json:"user_id"`
+	Caller   string      `json:"-"`
+}
+
+// Validate 补全 custom_id 并检查数量，重复的 custom_id 只让该项返回错误
+func (req *ReqBatch) Validate(maxItems int) error {
+	if len(req.Requests) == 0 {
+		return fieldError("requests", "is required")
+	}
+	if len(req.Requests) > maxItems {
+		return fieldError("requests", "at most %d items", maxItems)
+	}
+	seen := make(map[string]int, len(req.Requests))
+	for i := range req.Requests {
+		item := &req.Requests[i]
+		if item.CustomID == "" {
+			item.CustomID = strconv.Itoa(i)
+		}
+		if first, ok := seen[item.CustomID]; ok {
+			if item.Invalid == "" {
+				item.Invalid = "custom_id " + strconv.Quote(item.CustomID) + " duplicates requests[" + strconv.Itoa(first) + "]"
+			}
+			continue
+		}
+		seen[item.CustomID] = i
+	}
+	return nil
+}
+
+// ParseBatchJSONL 每行一个 {"custom_id","body"}，空行忽略，无法解析的行作为错误项
+func ParseBatchJSONL(r io.Reader, maxLineSize int) ([]BatchItem, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	var items []BatchItem
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var item BatchItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			// 尽量保留 custom_id，调用方才能对应到出错的项
+			var id struct {
+				CustomID string `json:"custom_id"`
+			}
+			_ = json.Unmarshal(data, &id)
+			item = BatchItem{CustomID: id.CustomID, Invalid: "line " + strconv.Itoa(line) + ": " + err.Error()}
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// BatchResult 按完成顺序返回，Index 为请求中的序号
+type BatchResult struct {
+	CustomID string       `json:"custom_id"`
+	Index    int          `json:"index"`
+	Response *RespChatGPT `json:"response,omitempty"`
+	Error    *ErrorDetail `json:"error,omitempty"`
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 )
 
+// ReqChat 旧的 /chat/sendMsg 请求，Prompt 为之前返回的对话记录，每行以 "角色: " 开头，
+// 第一条角色行之前的内容作为 system prompt
 type ReqChat struct {
 	UserID    int64  `json:"user_id"`
 	Msg       string `json:"msg"`
@@ -16,84 +18,128 @@ type ReqChat struct {
 	N         int    `json:"n"`
 }
 
-type ReqGPT3 struct {
-	Model             string    `json:"model"`
-	Prompt            string    `json:"prompt"`
-	Temperature       float64   `json:"temperature"`
-	Max_tokens        int       `json:"max_tokens"`
-	Top_p             float64   `json:"top_p"`
-	Frequency_penalty int       `json:"frequency_penalty"`
-	Presence_penalty  float64   `json:"presence_penalty"`
-	Stop              [2]string `json:"stop"`
-	N                 int       `json:"n"`
-}
+const (
+	DefaultRoleAsker = "You"
+	DefaultRoleAI    = "AI"
 
-func (msg *ReqGPT3) ToJson() []byte {
-	body, err := json.Marshal(msg)
-	if err != nil {
-		panic(err)
-	}
-	return body
-}
+	legacyTemperature     = 0.9
+	legacyMaxTokens       = 150
+	legacyPresencePenalty = 0.6
+)
 
-func CreateReqGPT3(req *ReqChat) *bytes.Buffer {
+// CreateReqChatFromLegacy 把对话记录转为 system prompt 和 user / assistant 消息，
+// 补全角色名，req.Prompt 更新为加上本次提问的对话记录
+func CreateReqChatFromLegacy(req *ReqChat, model string) (*ReqChatGPTFromCient, error) {
 	if req == nil {
-		return nil
+		return nil, fieldError("body", "is required")
+	}
+	if strings.TrimSpace(req.Msg) == "" {
+		return nil, fieldError("msg", "is required")
 	}
+	// 旧接口不限制 n，超过上限时按上限返回而不是报错
 	if req.N < 1 {
 		req.N = 1
 	}
-
+	if req.N > MaxChoices {
+		req.N = MaxChoices
+	}
 	req.RoleAsker = strings.TrimSpace(req.RoleAsker)
+	if req.RoleAsker == "" {
+		req.RoleAsker = DefaultRoleAsker
+	}
 	req.RoleAI = strings.TrimSpace(req.RoleAI)
-
-	if req.Msg == "" || strings.TrimSpace(req.Msg) == "" {
-		return nil
+	if req.RoleAI == "" {
+		req.RoleAI = DefaultRoleAI
 	}
-	reqGPT := &ReqGPT3{
-		Model:             "text-davinci-003",
-		Temperature:       0.9,
-		Max_tokens:        150,
-		Top_p:             1,
-		Frequency_penalty: 0,
-		Presence_penalty:  0.6,
-		Stop: [2]string{
-			"You: Bye",
-			"AI: Bye",
-		},
-		Prompt: req.Prompt,
-		N:      req.N,
+	if req.RoleAsker == req.RoleAI {
+		return nil, fieldError("role_ai", "must differ from role_asker")
 	}
-	if req.RoleAsker != "" {
-		reqGPT.Stop[0] = req.RoleAsker + ": Bye"
+
+	system, messages := parseTranscript(req.Prompt, req.RoleAsker, req.RoleAI)
+	if req.RoleAsker != DefaultRoleAsker || req.RoleAI != DefaultRoleAI {
+		system = strings.TrimSpace(system + fmt.Sprintf("\nIn this conversation you are %s and the user is %s.", req.RoleAI, req.RoleAsker))
 	}
-	if req.RoleAI != "" {
-		reqGPT.Stop[1] = req.RoleAI + ": Bye"
+	if system != "" {
+		messages = append([]ChatGPTMessage{{Role: RoleSystem, Content: system}}, messages...)
 	}
-	reqGPT.Prompt += "\n" + reqGPT.Stop[0] + req.Msg + "\n" + reqGPT.Stop[1]
-	req.Prompt = reqGPT.Prompt
-	return bytes.NewBuffer(reqGPT.ToJson())
+	messages = append(messages, ChatGPTMessage{Role: RoleUser, Content: strings.TrimSpace(req.Msg)})
+	req.Prompt = strings.TrimRight(req.Prompt, "\n") + "\n" + req.RoleAsker + ": " + strings.TrimSpace(req.Msg)
+
+	temperature := legacyTemperature
+	chatReq := &ReqChatGPTFromCient{UserID: req.UserID}
+	chatReq.Model = model
+	chatReq.Message = messages
+	chatReq.Temperature = &temperature
+	chatReq.MaxTokens = legacyMaxTokens
+	chatReq.PresencePenalty = legacyPresencePenalty
+	chatReq.N = req.N
+	// 模型接着替提问者说话时停止
+	chatReq.Stop = StringList{"\n" + req.RoleAsker + ":"}
+	return chatReq, nil
 }
 
-type RespGPT3 struct {
-	Msg       string `json:"message"`
-	Prompt    string `json:"prompt"`
-	RoleAI    string `json:"role_ai"`
-	RoleAsker string `json:"role_asker"`
+// legacyGlue 旧的 completions 接口以 "角色: Bye" 作为 stop，对话记录中每行为 "You: Bye问题" / "AI: Bye回答"
+const legacyGlue = " Bye"
+
+// parseTranscript 按角色前缀切分对话记录，没有前缀的行属于上一条消息。
+// 第一条角色行带有旧接口的 ": Bye" 时视为旧的对话记录，去掉所有角色行中的 ": Bye"
+func parseTranscript(prompt, asker, ai string) (string, []ChatGPTMessage) {
+	var preamble []string
+	var messages []ChatGPTMessage
+	legacy := false
+	content := func(line, role string) string {
+		text := line[len(role)+1:]
+		// 旧的对话记录中问题紧跟在 Bye 后面，"You: Bye now" 是新的对话记录
+		if len(messages) == 0 {
+			rest := strings.TrimPrefix(text, legacyGlue)
+			legacy = len(rest) < len(text) && !strings.HasPrefix(rest, " ")
+		}
+		if legacy {
+			text = strings.TrimPrefix(text, legacyGlue)
+		}
+		return strings.TrimSpace(text)
+	}
+	for _, line := range strings.Split(prompt, "\n") {
+		switch {
+		case strings.HasPrefix(line, asker+":"):
+			messages = append(messages, ChatGPTMessage{Role: RoleUser, Content: content(line, asker)})
+		case strings.HasPrefix(line, ai+":"):
+			messages = append(messages, ChatGPTMessage{Role: RoleAssistant, Content: content(line, ai)})
+		case len(messages) > 0:
+			last := &messages[len(messages)-1]
+			last.Content = strings.TrimSpace(last.Content + "\n" + line)
+		default:
+			preamble = append(preamble, line)
+		}
+	}
+	// 空消息对上游没有意义，如只有角色名的行
+	kept := messages[:0]
+	for _, m := range messages {
+		if m.Content != "" {
+			kept = append(kept, m)
+		}
+	}
+	return strings.TrimSpace(strings.Join(preamble, "\n")), kept
 }
 
-type OpenAiChoices struct {
-	Text          string `json:"text"`
-	Finish_reason string `json:"finish_reason"`
+type RespGPT3 struct {
+	Msg string `json:"message"`
+	// 全部 N 个回答，Msg 为第一个
+	Choices   []string `json:"choices"`
+	Prompt    string   `json:"prompt"`
+	RoleAI    string   `json:"role_ai"`
+	RoleAsker string   `json:"role_asker"`
 }
 
 type OpenApiError struct {
 	Message string `json:"message"`
+	Type    string `json:"type,omitempty"`
+	Code    string `json:"code,omitempty"`
 }
 
+// OpenAiRsp 上游出错时的响应体
 type OpenAiRsp struct {
-	Choices []OpenAiChoices `json:"choices"`
-	Error   OpenApiError    `json:"error"`
+	Error OpenApiError `json:"error"`
 }
 
 func ToRespOpenApi(body []byte) (*OpenAiRsp, error) {
@@ -105,42 +151,84 @@ func ToRespOpenApi(body []byte) (*OpenAiRsp, error) {
 	return &msg, err
 }
 
-func ToRespGPT3(req ReqChat, aipRes OpenAiRsp) *RespGPT3 {
-	res := new(RespGPT3)
-	res.Msg = aipRes.Choices[0].Text
-	res.Prompt = req.Prompt + res.Msg
-	// if aipRes.Choices[0].Finish_reason != "" {
-	// 	res.N = 0
-	// 	res.Prompt = ""
-	// }
-	res.RoleAsker = req.RoleAsker
-	res.RoleAI = req.RoleAI
+// ToRespGPT3 req 为 CreateReqChatFromLegacy 处理后的请求，返回的 Prompt 加上第一个回答，
+// 客户端下次请求时原样带上
+func ToRespGPT3(req ReqChat, aipRes *RespChatGPT) *RespGPT3 {
+	res := &RespGPT3{
+		Choices:   make([]string, 0, len(aipRes.Choices)),
+		RoleAsker: req.RoleAsker,
+		RoleAI:    req.RoleAI,
+	}
+	for _, choice := range aipRes.Choices {
+		res.Choices = append(res.Choices, choice.Message.Text())
+	}
+	if len(res.Choices) > 0 {
+		res.Msg = res.Choices[0]
+	}
+	res.Prompt = req.Prompt + "\n" + req.RoleAI + ": " + res.Msg
 	return res
 }
 
 type ChatGPTMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// content 为数组时的内容，此时 Content 为空，见 content.go
+	Parts []ContentPart `json:"-"`
+	// function 消息的函数名，或 user / assistant 的参与者名
+	Name      string     `json:"name,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// tool 消息回应的 ToolCall.ID
+	ToolCallID   string        `json:"tool_call_id,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
 }
 
 // https://platform.openai.com/docs/api-reference/chat/create
 type ReqChatGPT struct {
-	Model            string           `json:"model"`
-	Message          []ChatGPTMessage `json:"messages"`
-	Temperature      float64          `json:"temperature"`
-	TopP             float64          `json:"top_p"`
-	N                int              `json:"n"`
-	Stream           bool             `json:"stream"`
-	Stop             []string         `json:"stop"`
-	MaxTokens        int              `json:"max_tokens"`
-	FrequencyPenalty int              `json:"frequency_penalty"`
-	PresencePenalty  float64          `json:"presence_penalty"`
-	User             string           `json:"user"`
+	Model   string           `json:"model"`
+	Message []ChatGPTMessage `json:"messages"`
+	// 未指定时不发送，使用上游的默认值
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	N           int      `json:"n"`
+	Stream      bool     `json:"stream"`
+	// 字符串或最多 4 个字符串的数组
+	Stop             StringList `json:"stop,omitempty"`
+	MaxTokens        int        `json:"max_tokens"`
+	FrequencyPenalty float64    `json:"frequency_penalty,omitempty"`
+	PresencePenalty  float64    `json:"presence_penalty,omitempty"`
+	// token id -> -100 到 100 的偏置
+	LogitBias      map[string]int  `json:"logit_bias,omitempty"`
+	Seed           *int64          `json:"seed,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	Logprobs       bool            `json:"logprobs,omitempty"`
+	// 每个位置返回的候选 token 数，需要 logprobs 为 true
+	TopLogprobs *int   `json:"top_logprobs,omitempty"`
+	User        string `json:"user"`
+	Tools       []Tool `json:"tools,omitempty"`
+	// "none" / "auto" / "required" 或 {"type":"function","function":{"name":"..."}}
+	ToolChoice        json.RawMessage `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool           `json:"parallel_tool_calls,omitempty"`
+	// 旧的 function calling，不能与 tools 同时使用
+	Functions    []FunctionDef   `json:"functions,omitempty"`
+	FunctionCall json.RawMessage `json:"function_call,omitempty"`
 }
 
+const (
+	// DefaultMaxTokens 请求中未指定 max_tokens 时的值
+	DefaultMaxTokens = 200
+	// DefaultChatModel 请求中未指定 model 时的值
+	DefaultChatModel = "gpt-3.5-turbo-0301"
+)
+
 type ReqChatGPTFromCient struct {
 	ReqChatGPT
 	UserID int64 `json:"user_id"`
+	// 由服务端执行的工具，见 tools 包
+	ServerTools []string `json:"server_tools,omitempty"`
+	// 调用方，由 auth.Caller 校验 X-Caller-Token 得到（信任请求头的部署见 auth.trust_caller_header），用于工具权限和任务归属
+	Caller string `json:"-"`
+	// 从知识库检索资料加入 system prompt，回答中返回 citations
+	KnowledgeBaseID string `json:"knowledge_base_id,omitempty"`
 }
 
 func (msg ReqChatGPT) ToJson() []byte {
@@ -151,71 +239,72 @@ func (msg ReqChatGPT) ToJson() []byte {
 	return body
 }
 
-func CreateReqChatGPT(req *ReqChatGPTFromCient) *bytes.Buffer {
+// CreateReqChatGPT 补全默认参数并校验，参数不合法时返回 *FieldError
+func CreateReqChatGPT(req *ReqChatGPTFromCient) (*bytes.Buffer, error) {
 	if req == nil {
-		return nil
+		return nil, fieldError("body", "is required")
 	}
 	if req.Model == "" {
-		req.Model = "gpt-3.5-turbo-0301" //gpt-3.5-turbo or gpt-3.5-turbo-0301
+		req.Model = DefaultChatModel
 	}
 	if req.UserID > 0 {
 		req.User = fmt.Sprintf("client_user_%d", req.UserID)
 	}
-	if req.N < 1 {
+	if req.N == 0 {
 		req.N = 1
 	}
-	if req.N > 5 {
-		req.N = 5
-	}
-	if len(req.Message) == 0 || strings.TrimSpace(req.Message[0].Content) == "" {
-		return nil
+	if len(req.Message) == 0 || (strings.TrimSpace(req.Message[0].Text()) == "" && len(req.Message[0].Images()) == 0) {
+		return nil, fieldError("messages", "the first message is empty")
 	}
-	if req.MaxTokens == 0 {
-		req.MaxTokens = 200
-	}
-	if req.MaxTokens > 4096 {
-		req.MaxTokens = 4096
-	}
-	if req.Temperature < 0 || req.Temperature > 2 {
-		req.Temperature = 0.9
-	}
-	if req.TopP > 0 {
-		req.Temperature = 0
-		if req.TopP > 1 {
-			req.TopP = 1
-		}
+	if err := validateContent(req.Message); err != nil {
+		return nil, err
 	}
-	if req.Temperature == 0 && req.TopP == 0 {
-		req.TopP = 1
+	if err := req.validateTools(); err != nil {
+		return nil, err
 	}
-	if req.FrequencyPenalty > 2 || req.FrequencyPenalty < (-2) {
-		req.FrequencyPenalty = 0
+	if err := req.validateParams(); err != nil {
+		return nil, err
 	}
-	if req.PresencePenalty > 2 || req.PresencePenalty < (-2) {
-		req.PresencePenalty = 0.6
+	if req.MaxTokens == 0 {
+		req.MaxTokens = DefaultMaxTokens
 	}
 
-	return bytes.NewBuffer(req.ToJson())
+	return bytes.NewBuffer(req.ToJson()), nil
 }
 
 type ChatChoice struct {
 	Index        int `json:"index"`
 	Message      ChatGPTMessage
 	FinishReason string `json:"finish_reason"`
+	// 请求 logprobs 时返回，内容经过审核替换时不返回
+	Logprobs *ChoiceLogprobs `json:"logprobs,omitempty"`
+	// response_format 为 json_schema 时通过校验的内容
+	Parsed json.RawMessage `json:"parsed,omitempty"`
 }
 
 type ChatUsage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// prompt_tokens 中图片的估算部分
+	ImageTokens int `json:"image_tokens,omitempty"`
 }
 
 type RespChatGPT struct {
-	ID      string       `json:"id"`
-	Object  string       `json:"object"`
-	Created int64        `json:"created"`
-	Choices []ChatChoice `json:"choices"`
-	Usage   ChatUsage
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model,omitempty"`
+	// 与 seed 一起用于判断结果能否复现
+	SystemFingerprint string       `json:"system_fingerprint,omitempty"`
+	Choices           []ChatChoice `json:"choices"`
+	Usage             ChatUsage
+	// 服务端执行的工具调用
+	ToolTrace []ToolTrace `json:"tool_trace,omitempty"`
+	// 知识库中被注入 system prompt 的资料
+	Citations []Citation `json:"citations,omitempty"`
+	// 上游返回的错误，不输出给客户端
+	Error *OpenApiError `json:"error,omitempty"`
 }
 
 func ToRespChatGPT(body []byte) (*RespChatGPT, error) {
@@ -226,3 +315,33 @@ func ToRespChatGPT(body []byte) (*RespChatGPT, error) {
 	}
 	return msg, err
 }
+
+type ChatChunkChoice struct {
+	Index        int             `json:"index"`
+	Delta        ChatGPTMessage  `json:"delta"`
+	FinishReason string          `json:"finish_reason"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs,omitempty"`
+}
+
+// RespChatGPTChunk stream 模式下每个 data 事件的内容
+type RespChatGPTChunk struct {
+	ID                string            `json:"id"`
+	Object            string            `json:"object"`
+	Created           int64             `json:"created"`
+	Model             string            `json:"model"`
+	SystemFingerprint string            `json:"system_fingerprint,omitempty"`
+	Choices           []ChatChunkChoice `json:"choices"`
+	// 使用知识库时只在第一个分片中返回
+	Citations []Citation `json:"citations,omitempty"`
+	// 只在最后一个分片中返回，为估算值，包含图片的估算部分
+	Usage *ChatUsage `json:"usage,omitempty"`
+}
+
+func ToRespChatGPTChunk(body []byte) (*RespChatGPTChunk, error) {
+	msg := new(RespChatGPTChunk)
+	err := json.Unmarshal(body, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, err
+}
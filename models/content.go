@@ -0,0 +1,135 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	ContentTypeText     = "text"
+	ContentTypeImageURL = "image_url"
+
+	ImageDetailAuto = "auto"
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+)
+
+type ImageURL struct {
+	// http(s) 地址或 data:image/...;base64,... 格式的 data URL
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ContentPart content 为数组时的一项
+// https://platform.openai.com/docs/api-reference/chat/create#chat-create-messages
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// chatGPTMessage 与 ChatGPTMessage 字段相同，用于自定义 json 编解码
+type chatGPTMessage ChatGPTMessage
+
+// MarshalJSON Parts 不为空时 content 输出为数组，否则为字符串
+func (m ChatGPTMessage) MarshalJSON() ([]byte, error) {
+	var content interface{} = m.Content
+	if len(m.Parts) > 0 {
+		content = m.Parts
+	}
+	return json.Marshal(struct {
+		chatGPTMessage
+		Content interface{} `json:"content"`
+	}{chatGPTMessage(m), content})
+}
+
+// UnmarshalJSON content 可以是字符串、数组或 null
+func (m *ChatGPTMessage) UnmarshalJSON(data []byte) error {
+	var msg struct {
+		*chatGPTMessage
+		Content json.RawMessage `json:"content"`
+	}
+	msg.chatGPTMessage = (*chatGPTMessage)(m)
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	content := bytes.TrimSpace(msg.Content)
+	m.Content, m.Parts = "", nil
+	switch {
+	case len(content) == 0 || string(content) == "null":
+		return nil
+	case content[0] == '[':
+		return json.Unmarshal(content, &m.Parts)
+	default:
+		return json.Unmarshal(content, &m.Content)
+	}
+}
+
+// Text 消息中的全部文本，content 为数组时按顺序拼接 text 部分
+func (m ChatGPTMessage) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+	texts := make([]string, 0, len(m.Parts))
+	for _, part := range m.Parts {
+		if part.Type == ContentTypeText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Images content 数组中的图片
+func (m ChatGPTMessage) Images() []*ImageURL {
+	var images []*ImageURL
+	for _, part := range m.Parts {
+		if part.Type == ContentTypeImageURL && part.ImageURL != nil {
+			images = append(images, part.ImageURL)
+		}
+	}
+	return images
+}
+
+// MapText 对 content 字符串和数组中的每个 text 部分调用 fn，fn 返回错误时停止
+func (m *ChatGPTMessage) MapText(fn func(string) (string, error)) error {
+	if len(m.Parts) == 0 {
+		text, err := fn(m.Content)
+		if err != nil {
+			return err
+		}
+		m.Content = text
+		return nil
+	}
+	for i := range m.Parts {
+		if m.Parts[i].Type != ContentTypeText {
+			continue
+		}
+		text, err := fn(m.Parts[i].Text)
+		if err != nil {
+			return err
+		}
+		m.Parts[i].Text = text
+	}
+	return nil
+}
+
+// validateContent 校验 content 数组中每一项的类型，图片的格式和大小由 vision 包校验
+func validateContent(messages []ChatGPTMessage) error {
+	for i, message := range messages {
+		for j, part := range message.Parts {
+			field := fmt.Sprintf("messages[%d].content[%d]", i, j)
+			switch part.Type {
+			case ContentTypeText:
+			case ContentTypeImageURL:
+				if part.ImageURL == nil || part.ImageURL.URL == "" {
+					return fieldError(field+".image_url.url", "is required")
+				}
+			default:
+				return fieldError(field+".type", "must be %q or %q", ContentTypeText, ContentTypeImageURL)
+			}
+		}
+	}
+	return nil
+}
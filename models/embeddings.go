@@ -0,0 +1,99 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const DefaultEmbeddingModel = "text-embedding-ada-002"
+
+// StringList 可以是单个字符串或字符串数组
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*l = StringList{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// https://platform.openai.com/docs/api-reference/embeddings/create
+type ReqEmbeddings struct {
+	Model string     `json:"model,omitempty"`
+	Input StringList `json:"input"`
+	User  string     `json:"user,omitempty"`
+}
+
+type ReqEmbeddingsFromClient struct {
+	ReqEmbeddings
+	UserID int64 `json:"user_id"`
+}
+
+// Validate 设置默认模型并校验输入，maxInputs 为单次请求最多的输入数
+func (req *ReqEmbeddings) Validate(maxInputs int) error {
+	if req.Model == "" {
+		req.Model = DefaultEmbeddingModel
+	}
+	if len(req.Input) == 0 {
+		return fieldError("input", "is required")
+	}
+	if len(req.Input) > maxInputs {
+		return fieldError("input", "at most %d inputs", maxInputs)
+	}
+	for i, input := range req.Input {
+		if strings.TrimSpace(input) == "" {
+			return fieldError(fmt.Sprintf("input[%d]", i), "is empty")
+		}
+	}
+	return nil
+}
+
+func CreateReqEmbeddings(model string, inputs []string, userID int64) *bytes.Buffer {
+	req := ReqEmbeddings{Model: model, Input: inputs}
+	if userID > 0 {
+		req.User = fmt.Sprintf("client_user_%d", userID)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewBuffer(body)
+}
+
+type Embedding struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+	// 命中缓存、未请求上游的输入数
+	CachedInputs int `json:"cached_inputs,omitempty"`
+}
+
+type RespEmbeddings struct {
+	Object string         `json:"object"`
+	Data   []Embedding    `json:"data"`
+	Model  string         `json:"model"`
+	Usage  EmbeddingUsage `json:"usage"`
+	Error  *OpenApiError  `json:"error,omitempty"`
+}
+
+func ToRespEmbeddings(body []byte) (*RespEmbeddings, error) {
+	msg := new(RespEmbeddings)
+	err := json.Unmarshal(body, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,22 @@
+package models
+
+// ReqExtract 上传的文件，KnowledgeBaseID 不为空时提取的文字同时加入知识库
+type ReqExtract struct {
+	Filename        string
+	Data            []byte
+	KnowledgeBaseID string
+	DocumentID      string
+	UserID          int64
+}
+
+// RespExtract 提取的文字，标题以 # 标出，PDF 每页前有 [Page N]
+type RespExtract struct {
+	Filename  string      `json:"filename"`
+	Format    string      `json:"format"`
+	Title     string      `json:"title"`
+	Pages     int         `json:"pages,omitempty"`
+	Chars     int         `json:"chars"`
+	Truncated bool        `json:"truncated"`
+	Text      string      `json:"text"`
+	Document  *KBDocument `json:"document,omitempty"`
+}
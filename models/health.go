@@ -0,0 +1,18 @@
+package models
+
+const (
+	HealthOK       = "ok"
+	HealthFail     = "fail"
+	HealthDisabled = "disabled"
+)
+
+type DependencyStatus struct {
+	Status string      `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+	Extra  interface{} `json:"extra,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	DefaultImageModel = "dall-e-3"
+
+	ImageFormatURL = "url"
+	ImageFormatB64 = "b64_json"
+
+	// 提示词的最大字符数
+	MaxImagePromptLen = 4000
+)
+
+// imageSizes 各模型支持的尺寸，第一个为默认值
+var imageSizes = map[string][]string{
+	"dall-e-2": {"1024x1024", "256x256", "512x512"},
+	"dall-e-3": {"1024x1024", "1792x1024", "1024x1792"},
+}
+
+// imageMaxN 各模型单次最多生成的张数
+var imageMaxN = map[string]int{
+	"dall-e-2": 10,
+	"dall-e-3": 1,
+}
+
+// https://platform.openai.com/docs/api-reference/images/create
+type ReqImageGenerate struct {
+	Prompt         string `json:"prompt"`
+	Model          string `json:"model,omitempty"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	Style          string `json:"style,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+	User           string `json:"user,omitempty"`
+}
+
+type ReqImageGenerateFromClient struct {
+	ReqImageGenerate
+	UserID int64 `json:"user_id"`
+}
+
+// Validate 设置默认值并校验参数，quality 和 style 只有 dall-e-3 支持
+func (req *ReqImageGenerate) Validate(defaultModel string) error {
+	if req.Model == "" {
+		req.Model = defaultModel
+	}
+	sizes, ok := imageSizes[req.Model]
+	if !ok {
+		return fieldError("model", "must be dall-e-2 or dall-e-3")
+	}
+	if req.Prompt == "" {
+		return fieldError("prompt", "is required")
+	}
+	if utf8.RuneCountInString(req.Prompt) > MaxImagePromptLen {
+		return fieldError("prompt", "at most %d characters", MaxImagePromptLen)
+	}
+	if req.N == 0 {
+		req.N = 1
+	}
+	if req.N < 1 || req.N > imageMaxN[req.Model] {
+		return fieldError("n", "must be between 1 and %d for %s", imageMaxN[req.Model], req.Model)
+	}
+	if req.Size == "" {
+		req.Size = sizes[0]
+	}
+	if !containsString(sizes, req.Size) {
+		return fieldError("size", "must be one of %v for %s", sizes, req.Model)
+	}
+	if req.ResponseFormat == "" {
+		req.ResponseFormat = ImageFormatURL
+	}
+	if req.ResponseFormat != ImageFormatURL && req.ResponseFormat != ImageFormatB64 {
+		return fieldError("response_format", "must be url or b64_json")
+	}
+	if req.Model != "dall-e-3" && (req.Quality != "" || req.Style != "") {
+		return fieldError("quality", "quality and style are only supported by dall-e-3")
+	}
+	if req.Quality != "" && req.Quality != "standard" && req.Quality != "hd" {
+		return fieldError("quality", "must be standard or hd")
+	}
+	if req.Style != "" && req.Style != "vivid" && req.Style != "natural" {
+		return fieldError("style", "must be vivid or natural")
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func CreateReqImageGenerate(req ReqImageGenerate, userID int64) *bytes.Buffer {
+	if userID > 0 {
+		req.User = fmt.Sprintf("client_user_%d", userID)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewBuffer(body)
+}
+
+type ImageData struct {
+	URL           string `json:"url,omitempty"`
+	B64JSON       string `json:"b64_json,omitempty"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+}
+
+type RespImages struct {
+	Created int64       `json:"created"`
+	Data    []ImageData `json:"data"`
+	// 今日剩余的张数，未配置额度时为空
+	QuotaRemaining *int          `json:"quota_remaining,omitempty"`
+	Error          *OpenApiError `json:"error,omitempty"`
+}
+
+func ToRespImages(body []byte) (*RespImages, error) {
+	msg := new(RespImages)
+	err := json.Unmarshal(body, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
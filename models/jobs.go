@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// ReqJob 异步执行的聊天请求，CallbackURL 不为空时任务结束后回调
+type ReqJob struct {
+	ReqChatGPTFromCient
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Validate 校验回调地址，只允许 http 和 https
+func (req *ReqJob) Validate() error {
+	if req.CallbackURL == "" {
+		return nil
+	}
+	u, err := url.Parse(req.CallbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fieldError("callback_url", "must be an http or https url")
+	}
+	return nil
+}
+
+// ErrorDetail 异步任务、kafka 任务或批量请求中单项的错误，与接口返回的 code 和 msg 一致
+type ErrorDetail struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// Job 异步任务的状态和结果，时间为 unix 毫秒
+type Job struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	UserID int64  `json:"user_id"`
+	// 提交任务的调用方，只有同一调用方可以查询和取消
+	Caller      string       `json:"caller,omitempty"`
+	CallbackURL string       `json:"callback_url,omitempty"`
+	Result      *RespChatGPT `json:"result,omitempty"`
+	Error       *ErrorDetail `json:"error,omitempty"`
+	// 回调的结果，delivered 或 failed
+	Webhook    string `json:"webhook_status,omitempty"`
+	CreatedAt  int64  `json:"created_at"`
+	StartedAt  int64  `json:"started_at,omitempty"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
+// Finished 任务是否已经结束
+func (j *Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+func ToJob(body []byte) (*Job, error) {
+	job := new(Job)
+	err := json.Unmarshal(body, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,35 @@
+package models
+
+// Citation 回答引用的资料，Index 为 system prompt 中的 [编号]
+type Citation struct {
+	Index      int     `json:"index"`
+	DocumentID string  `json:"document_id"`
+	ChunkID    string  `json:"chunk_id"`
+	Title      string  `json:"title,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	Score      float32 `json:"score"`
+}
+
+// ReqKBDocument 上传到知识库的文档，ID 为空时自动生成，ID 已存在时替换原文档
+type ReqKBDocument struct {
+	ID     string `json:"id,omitempty"`
+	Title  string `json:"title"`
+	Source string `json:"source,omitempty"`
+	Text   string `json:"text"`
+	UserID int64  `json:"user_id"`
+}
+
+type KBDocument struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Source string `json:"source,omitempty"`
+	Chunks int    `json:"chunks"`
+}
+
+type RespKBDocuments struct {
+	Documents []KBDocument `json:"documents"`
+}
+
+type RespKBDelete struct {
+	Deleted int `json:"deleted_chunks"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// https://platform.openai.com/docs/api-reference/moderations/create
+type ReqModeration struct {
+	Input []string `json:"input"`
+	Model string   `json:"model,omitempty"`
+}
+
+func (msg ReqModeration) ToJson() []byte {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+func CreateReqModeration(inputs []string, model string) *bytes.Buffer {
+	if len(inputs) == 0 {
+		return nil
+	}
+	return bytes.NewBuffer(ReqModeration{
+		Input: inputs,
+		Model: model,
+	}.ToJson())
+}
+
+type ModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type RespModeration struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []ModerationResult `json:"results"`
+	Error   OpenApiError       `json:"error"`
+}
+
+func ToRespModeration(body []byte) (*RespModeration, error) {
+	msg := new(RespModeration)
+	err := json.Unmarshal(body, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, err
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"chatgpt_server/jsonschema"
+)
+
+const (
+	// n 的上限
+	MaxChoices = 5
+	// max_tokens 的上限
+	MaxCompletionTokens = 4096
+	MaxStopSequences    = 4
+	MaxTopLogprobs      = 20
+
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	// 按 JSON Schema 输出，服务端校验并在不符合时要求模型修正，结果在 choices[].parsed 中返回
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// ResponseFormat https://platform.openai.com/docs/api-reference/chat/create#chat-create-response_format
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	// 上游的严格模式，要求 schema 中所有属性 required 且 additionalProperties 为 false
+	Strict *bool `json:"strict,omitempty"`
+}
+
+// TopLogprob 某个位置上的候选 token
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+type TokenLogprob struct {
+	TopLogprob
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+// ChoiceLogprobs 回答中每个 token 的对数概率
+type ChoiceLogprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+// validateParams 校验采样参数，不合法时返回错误而不是改写
+func (req *ReqChatGPT) validateParams() error {
+	if req.N < 1 || req.N > MaxChoices {
+		return fieldError("n", "must be between 1 and %d", MaxChoices)
+	}
+	if req.MaxTokens < 0 || req.MaxTokens > MaxCompletionTokens {
+		return fieldError("max_tokens", "must be between 1 and %d", MaxCompletionTokens)
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2) {
+		return fieldError("temperature", "must be between 0 and 2")
+	}
+	if req.TopP != nil && (*req.TopP < 0 || *req.TopP > 1) {
+		return fieldError("top_p", "must be between 0 and 1")
+	}
+	if req.FrequencyPenalty < -2 || req.FrequencyPenalty > 2 {
+		return fieldError("frequency_penalty", "must be between -2 and 2")
+	}
+	if req.PresencePenalty < -2 || req.PresencePenalty > 2 {
+		return fieldError("presence_penalty", "must be between -2 and 2")
+	}
+	if len(req.Stop) > MaxStopSequences {
+		return fieldError("stop", "at most %d sequences", MaxStopSequences)
+	}
+	for i, stop := range req.Stop {
+		if stop == "" {
+			return fieldError("stop["+strconv.Itoa(i)+"]", "is empty")
+		}
+	}
+	for token, bias := range req.LogitBias {
+		if _, err := strconv.Atoi(token); err != nil {
+			return fieldError("logit_bias", "key %q is not a token id", token)
+		}
+		if bias < -100 || bias > 100 {
+			return fieldError("logit_bias."+token, "must be between -100 and 100")
+		}
+	}
+	if req.TopLogprobs != nil {
+		if !req.Logprobs {
+			return fieldError("top_logprobs", "requires logprobs to be true")
+		}
+		if *req.TopLogprobs < 0 || *req.TopLogprobs > MaxTopLogprobs {
+			return fieldError("top_logprobs", "must be between 0 and %d", MaxTopLogprobs)
+		}
+	}
+	return req.validateResponseFormat()
+}
+
+func (req *ReqChatGPT) validateResponseFormat() error {
+	if req.ResponseFormat == nil {
+		return nil
+	}
+	switch req.ResponseFormat.Type {
+	case ResponseFormatText:
+	case ResponseFormatJSONObject:
+		// 上游要求消息中提到 JSON，否则可能一直输出空白直到 max_tokens
+		for _, message := range req.Message {
+			if strings.Contains(strings.ToLower(message.Text()), "json") {
+				return nil
+			}
+		}
+		return fieldError("response_format", "json_object requires the word JSON in the messages")
+	case ResponseFormatJSONSchema:
+		return req.validateJSONSchema()
+	default:
+		return fieldError("response_format.type", "must be text, json_object or json_schema")
+	}
+	return nil
+}
+
+func (req *ReqChatGPT) validateJSONSchema() error {
+	format := req.ResponseFormat.JSONSchema
+	if format == nil {
+		return fieldError("response_format.json_schema", "is required")
+	}
+	if !functionNamePattern.MatchString(format.Name) {
+		return fieldError("response_format.json_schema.name", "must match %s", functionNamePattern.String())
+	}
+	if len(format.Schema) == 0 {
+		return fieldError("response_format.json_schema.schema", "is required")
+	}
+	if _, err := jsonschema.Compile(format.Schema); err != nil {
+		return fieldError("response_format.json_schema.schema", "%v", err)
+	}
+	// 只校验并修正一个回答，stream 时内容发出后无法再修正
+	if req.N > 1 {
+		return fieldError("n", "must be 1 with json_schema")
+	}
+	if req.Stream {
+		return fieldError("response_format", "json_schema is not supported in stream mode")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// 任务 ID 的最大长度
+const MaxTaskIDLen = 128
+
+// ChatTask 从 kafka 消费的聊天任务，结果以相同的 ID 发布到回复 topic
+// 同一 ID 重复投递时不会重复调用上游
+type ChatTask struct {
+	ID      string              `json:"id"`
+	Request ReqChatGPTFromCient `json:"request"`
+	// 调用方，用于工具权限。能写入任务 topic 的生产者即被信任，topic 的写权限需在 kafka 中限制
+	Caller string `json:"caller,omitempty"`
+}
+
+func (t *ChatTask) Validate() error {
+	if t.ID == "" {
+		return fieldError("id", "is required")
+	}
+	if len(t.ID) > MaxTaskIDLen {
+		return fieldError("id", "at most %d bytes", MaxTaskIDLen)
+	}
+	return nil
+}
+
+func ToChatTask(body []byte) (*ChatTask, error) {
+	task := new(ChatTask)
+	err := json.Unmarshal(body, task)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// ChatTaskResult 发布到回复 topic 的结果，Status 为 succeeded 或 failed
+type ChatTaskResult struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Response   *RespChatGPT `json:"response,omitempty"`
+	Error      *ErrorDetail `json:"error,omitempty"`
+	FinishedAt int64        `json:"finished_at"`
+}
+
+// DeadLetter 无法处理的消息，原样保留 key 和 value 以便排查后重新投递
+type DeadLetter struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Key       string `json:"key,omitempty"`
+	Value     string `json:"value"`
+	Error     string `json:"error"`
+	Attempts  int    `json:"attempts"`
+	FailedAt  int64  `json:"failed_at"`
+}
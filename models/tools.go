@@ -0,0 +1,217 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+	// RoleFunction 旧的 function calling，对应 functions / function_call
+	RoleFunction = "function"
+
+	ToolTypeFunction = "function"
+
+	ToolChoiceNone     = "none"
+	ToolChoiceAuto     = "auto"
+	ToolChoiceRequired = "required"
+
+	FinishReasonToolCalls    = "tool_calls"
+	FinishReasonFunctionCall = "function_call"
+)
+
+// FunctionDef 可供模型调用的函数，Parameters 为 JSON Schema
+type FunctionDef struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// https://platform.openai.com/docs/api-reference/chat/create#chat-create-tools
+type Tool struct {
+	Type     string      `json:"type"`
+	Function FunctionDef `json:"function"`
+}
+
+// FunctionCall 模型返回的函数调用，Arguments 为 JSON 字符串，stream 模式下分多次返回
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCall assistant 消息中的工具调用，Index 只在 stream 的 delta 中出现，用于拼接并行调用的参数
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// ToolTrace 服务端执行的一次工具调用，Arguments 和 Result 为原始 json
+type ToolTrace struct {
+	// 第几轮请求模型时返回的调用，从 1 开始
+	Step      int    `json:"step"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+	// 毫秒
+	Duration int64 `json:"duration_ms"`
+}
+
+// FieldError 请求参数校验错误
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+func fieldError(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Msg: fmt.Sprintf(format, args...)}
+}
+
+var functionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+func validateFunctionDef(field string, def FunctionDef) error {
+	if !functionNamePattern.MatchString(def.Name) {
+		return fieldError(field+".name", "must match %s", functionNamePattern)
+	}
+	if len(def.Parameters) > 0 {
+		var schema map[string]interface{}
+		if err := json.Unmarshal(def.Parameters, &schema); err != nil {
+			return fieldError(field+".parameters", "must be a JSON Schema object")
+		}
+	}
+	return nil
+}
+
+// validateChoice tool_choice / function_call 为字符串或指定函数的对象，names 为可选的函数
+func validateChoice(field string, raw json.RawMessage, names map[string]bool, allowed ...string) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		for _, a := range allowed {
+			if mode == a {
+				if mode != ToolChoiceNone && len(names) == 0 {
+					return fieldError(field, "%q requires tools", mode)
+				}
+				return nil
+			}
+		}
+		return fieldError(field, "must be one of %v or an object", allowed)
+	}
+	var choice struct {
+		Type     string `json:"type"`
+		Name     string `json:"name"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &choice); err != nil {
+		return fieldError(field, "must be one of %v or an object", allowed)
+	}
+	name := choice.Name
+	if field == "tool_choice" {
+		if choice.Type != ToolTypeFunction {
+			return fieldError(field+".type", "must be %q", ToolTypeFunction)
+		}
+		name = choice.Function.Name
+	}
+	if !names[name] {
+		return fieldError(field, "function %q is not defined", name)
+	}
+	return nil
+}
+
+// validateTools 校验 tools / functions 定义、tool_choice 以及消息中的工具调用
+func (req *ReqChatGPT) validateTools() error {
+	if len(req.Tools) > 0 && len(req.Functions) > 0 {
+		return fieldError("functions", "can not be used together with tools")
+	}
+	toolNames := make(map[string]bool, len(req.Tools))
+	for i, tool := range req.Tools {
+		field := fmt.Sprintf("tools[%d]", i)
+		if tool.Type != ToolTypeFunction {
+			return fieldError(field+".type", "must be %q", ToolTypeFunction)
+		}
+		if err := validateFunctionDef(field+".function", tool.Function); err != nil {
+			return err
+		}
+		if toolNames[tool.Function.Name] {
+			return fieldError(field+".function.name", "duplicate function %q", tool.Function.Name)
+		}
+		toolNames[tool.Function.Name] = true
+	}
+	functionNames := make(map[string]bool, len(req.Functions))
+	for i, def := range req.Functions {
+		field := fmt.Sprintf("functions[%d]", i)
+		if err := validateFunctionDef(field, def); err != nil {
+			return err
+		}
+		if functionNames[def.Name] {
+			return fieldError(field+".name", "duplicate function %q", def.Name)
+		}
+		functionNames[def.Name] = true
+	}
+	if err := validateChoice("tool_choice", req.ToolChoice, toolNames,
+		ToolChoiceNone, ToolChoiceAuto, ToolChoiceRequired); err != nil {
+		return err
+	}
+	if err := validateChoice("function_call", req.FunctionCall, functionNames,
+		ToolChoiceNone, ToolChoiceAuto); err != nil {
+		return err
+	}
+	if req.ParallelToolCalls != nil && len(req.Tools) == 0 {
+		return fieldError("parallel_tool_calls", "requires tools")
+	}
+	return validateToolMessages(req.Message)
+}
+
+// validateToolMessages tool 消息必须回应之前 assistant 消息中的 tool_call_id
+func validateToolMessages(messages []ChatGPTMessage) error {
+	pending := map[string]bool{}
+	for i, message := range messages {
+		field := fmt.Sprintf("messages[%d]", i)
+		switch message.Role {
+		case RoleSystem, RoleUser:
+		case RoleAssistant:
+			for j, call := range message.ToolCalls {
+				callField := fmt.Sprintf("%s.tool_calls[%d]", field, j)
+				if call.ID == "" {
+					return fieldError(callField+".id", "is required")
+				}
+				if call.Type != ToolTypeFunction {
+					return fieldError(callField+".type", "must be %q", ToolTypeFunction)
+				}
+				if call.Function.Name == "" {
+					return fieldError(callField+".function.name", "is required")
+				}
+				pending[call.ID] = true
+			}
+		case RoleTool:
+			if message.ToolCallID == "" {
+				return fieldError(field+".tool_call_id", "is required")
+			}
+			if !pending[message.ToolCallID] {
+				return fieldError(field+".tool_call_id", "no assistant tool call %q before it", message.ToolCallID)
+			}
+		case RoleFunction:
+			if message.Name == "" {
+				return fieldError(field+".name", "is required")
+			}
+		default:
+			return fieldError(field+".role", "unknown role %q", message.Role)
+		}
+	}
+	return nil
+}
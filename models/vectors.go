@@ -0,0 +1,70 @@
+package models
+
+import "fmt"
+
+// VectorItem Vector 为空时用 Model 对 Text 生成向量
+type VectorItem struct {
+	ID       string            `json:"id"`
+	Vector   []float32         `json:"vector,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type ReqVectorUpsert struct {
+	Model  string       `json:"model,omitempty"`
+	Items  []VectorItem `json:"items"`
+	UserID int64        `json:"user_id"`
+}
+
+// Validate maxItems 为单次请求最多的条数
+func (req *ReqVectorUpsert) Validate(maxItems int) error {
+	if len(req.Items) == 0 {
+		return fieldError("items", "is required")
+	}
+	if len(req.Items) > maxItems {
+		return fieldError("items", "at most %d items", maxItems)
+	}
+	for i, item := range req.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		if item.ID == "" {
+			return fieldError(field+".id", "is required")
+		}
+		if len(item.Vector) == 0 && item.Text == "" {
+			return fieldError(field, "vector or text is required")
+		}
+	}
+	return nil
+}
+
+type ReqVectorDelete struct {
+	IDs []string `json:"ids"`
+}
+
+// ReqVectorQuery Vector 为空时用 Model 对 Text 生成向量
+type ReqVectorQuery struct {
+	Vector []float32 `json:"vector,omitempty"`
+	Text   string    `json:"text,omitempty"`
+	Model  string    `json:"model,omitempty"`
+	TopK   int       `json:"top_k"`
+	UserID int64     `json:"user_id"`
+}
+
+type VectorMatch struct {
+	ID       string            `json:"id"`
+	Score    float32           `json:"score"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type RespVectorUpsert struct {
+	Upserted int `json:"upserted"`
+	Total    int `json:"total"`
+}
+
+type RespVectorDelete struct {
+	Deleted int `json:"deleted"`
+	Total   int `json:"total"`
+}
+
+type RespVectorQuery struct {
+	Matches []VectorMatch `json:"matches"`
+}
@@ -0,0 +1,159 @@
+package moderation
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Hit 一次敏感词命中，Start/End 为原文中的字节偏移
+type Hit struct {
+	Word     string `json:"word"`
+	Category string `json:"category"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type acNode struct {
+	next map[rune]int
+	fail int
+	// 以该节点结尾的词在 patterns 中的下标
+	outs []int
+}
+
+type pattern struct {
+	word     string
+	category string
+	length   int // rune 个数
+}
+
+// Matcher Aho-Corasick 自动机，构建后只读，可并发使用
+type Matcher struct {
+	nodes    []acNode
+	patterns []pattern
+	maxLen   int
+}
+
+func fold(r rune) rune {
+	return unicode.ToLower(r)
+}
+
+// NewMatcher 按分类构建自动机，words 的 key 为分类名
+func NewMatcher(words map[string][]string) *Matcher {
+	m := &Matcher{
+		nodes: []acNode{{next: map[rune]int{}}},
+	}
+	for category, list := range words {
+		for _, word := range list {
+			m.add(word, category)
+		}
+	}
+	m.build()
+	return m
+}
+
+func (m *Matcher) add(word, category string) {
+	if word == "" {
+		return
+	}
+	cur := 0
+	length := 0
+	for _, r := range word {
+		r = fold(r)
+		length++
+		nxt, ok := m.nodes[cur].next[r]
+		if !ok {
+			m.nodes = append(m.nodes, acNode{next: map[rune]int{}})
+			nxt = len(m.nodes) - 1
+			m.nodes[cur].next[r] = nxt
+		}
+		cur = nxt
+	}
+	m.patterns = append(m.patterns, pattern{word: word, category: category, length: length})
+	m.nodes[cur].outs = append(m.nodes[cur].outs, len(m.patterns)-1)
+	if length > m.maxLen {
+		m.maxLen = length
+	}
+}
+
+// build 广度优先计算失败指针，并把失败链上的输出合并到当前节点
+func (m *Matcher) build() {
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		m.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range m.nodes[cur].next {
+			f := m.nodes[cur].fail
+			for f != 0 {
+				if _, ok := m.nodes[f].next[r]; ok {
+					break
+				}
+				f = m.nodes[f].fail
+			}
+			if nxt, ok := m.nodes[f].next[r]; ok && nxt != child {
+				m.nodes[child].fail = nxt
+			} else {
+				m.nodes[child].fail = 0
+			}
+			m.nodes[child].outs = append(m.nodes[child].outs, m.nodes[m.nodes[child].fail].outs...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// MaxLen 最长词的 rune 个数，流式匹配时据此保留尾部
+func (m *Matcher) MaxLen() int {
+	if m == nil {
+		return 0
+	}
+	return m.maxLen
+}
+
+// Empty 是否没有加载任何词
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// FindAll 返回文本中所有命中（含重叠），按结束位置排序
+func (m *Matcher) FindAll(text string) []Hit {
+	if m.Empty() || text == "" {
+		return nil
+	}
+	var hits []Hit
+	// 记录每个 rune 的起始字节偏移，用于回推命中的起点
+	offsets := make([]int, 0, utf8.RuneCountInString(text))
+	cur := 0
+	for pos, r := range text {
+		offsets = append(offsets, pos)
+		r = fold(r)
+		for {
+			if nxt, ok := m.nodes[cur].next[r]; ok {
+				cur = nxt
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+		if len(m.nodes[cur].outs) == 0 {
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		end := pos + size
+		idx := len(offsets) - 1
+		for _, p := range m.nodes[cur].outs {
+			pt := m.patterns[p]
+			hits = append(hits, Hit{
+				Word:     pt.word,
+				Category: pt.category,
+				Start:    offsets[idx-pt.length+1],
+				End:      end,
+			})
+		}
+	}
+	return hits
+}
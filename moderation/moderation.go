@@ -0,0 +1,171 @@
+package moderation
+
+import (
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+type Action string
+
+const (
+	// 拒绝整条内容
+	ActionBlock Action = "block"
+	// 命中的词替换为 *
+	ActionMask Action = "mask"
+	// 放行，记录待人工审核
+	ActionFlag Action = "flag"
+
+	maskRune = '*'
+)
+
+var actions atomic.Value
+
+func init() {
+	actions.Store(map[string]Action{})
+}
+
+// loadActions 解析 moderation.actions，格式如 "politics:block,ads:mask,abuse:flag"
+func loadActions() {
+	m := map[string]Action{}
+	for _, item := range strings.Split(config.GetStr("moderation.actions"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch action := Action(strings.TrimSpace(kv[1])); action {
+		case ActionBlock, ActionMask, ActionFlag:
+			m[strings.TrimSpace(kv[0])] = action
+		}
+	}
+	actions.Store(m)
+}
+
+func actionOf(category string) Action {
+	if action, ok := actions.Load().(map[string]Action)[category]; ok {
+		return action
+	}
+	switch action := Action(config.GetStr("moderation.default_action")); action {
+	case ActionBlock, ActionMask:
+		return action
+	}
+	return ActionFlag
+}
+
+type Result struct {
+	// 处理后的文本，mask 的词已被替换
+	Text    string
+	Blocked bool
+	// 需要送审的命中
+	Flags []Hit
+	// 所有命中
+	Hits []Hit
+}
+
+// apply 按分类配置处理命中
+func apply(text string, hits []Hit) Result {
+	res := Result{Text: text, Hits: hits}
+	if len(hits) == 0 {
+		return res
+	}
+	masked := []byte(text)
+	needMask := false
+	for _, hit := range hits {
+		switch actionOf(hit.Category) {
+		case ActionBlock:
+			res.Blocked = true
+		case ActionMask:
+			needMask = true
+			for i := hit.Start; i < hit.End; i++ {
+				masked[i] = 0
+			}
+		default:
+			res.Flags = append(res.Flags, hit)
+		}
+	}
+	if needMask {
+		res.Text = maskText(text, masked)
+	}
+	return res
+}
+
+// maskText marks 中为 0 的字节所在的 rune 替换为 *
+func maskText(text string, marks []byte) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for pos, r := range text {
+		if marks[pos] == 0 {
+			b.WriteRune(maskRune)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type Moderator interface {
+	// Check 检查一段完整文本
+	Check(text string) Result
+	// NewStream 创建流式过滤器，用于逐段输出的内容
+	NewStream() *StreamFilter
+}
+
+type moderator struct {
+}
+
+func NewModerator() Moderator {
+	return new(moderator)
+}
+
+func (m moderator) Check(text string) Result {
+	return apply(text, loadDict().matcher.FindAll(text))
+}
+
+func (m moderator) NewStream() *StreamFilter {
+	return &StreamFilter{matcher: loadDict().matcher}
+}
+
+// StreamFilter 流式内容过滤
+// 每次保留最长词长度-1 个字符暂不输出，以匹配跨分片的敏感词
+type StreamFilter struct {
+	matcher *Matcher
+	held    string
+}
+
+// Write 写入一个分片，Result.Text 为可以立即输出的部分
+func (f *StreamFilter) Write(delta string) Result {
+	if f.matcher.Empty() {
+		return Result{Text: delta}
+	}
+	text := f.held + delta
+	// 结束位置落在上次保留部分内的命中已经处理过
+	var hits []Hit
+	for _, hit := range f.matcher.FindAll(text) {
+		if hit.End > len(f.held) {
+			hits = append(hits, hit)
+		}
+	}
+	res := apply(text, hits)
+	if res.Blocked {
+		f.held = ""
+		return res
+	}
+	keep := f.matcher.MaxLen() - 1
+	split := len(res.Text)
+	for i := 0; i < keep && split > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(res.Text[:split])
+		split -= size
+	}
+	f.held = res.Text[split:]
+	res.Text = res.Text[:split]
+	return res
+}
+
+// Flush 输出剩余保留的内容
+func (f *StreamFilter) Flush() string {
+	held := f.held
+	f.held = ""
+	return held
+}
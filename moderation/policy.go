@@ -0,0 +1,72 @@
+package moderation
+
+import (
+	"strconv"
+	"strings"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+)
+
+// Policy 上游审核接口的处理策略
+type Policy struct {
+	CheckInput  bool
+	CheckOutput bool
+	// 上游调用失败时是否放行
+	FailOpen bool
+	// 分类 -> 阈值，分数大于等于阈值视为违规
+	thresholds map[string]float64
+	// 未配置阈值的分类使用该值，<=0 时以上游返回的 categories 为准
+	dftThreshold float64
+}
+
+// NewPolicy 读取配置:
+// moderation.check_input / moderation.check_output / moderation.fail_open
+// moderation.thresholds 格式如 "hate:0.5,violence:0.7"
+// moderation.default_threshold
+func NewPolicy() *Policy {
+	p := &Policy{
+		CheckInput:   config.GetBool("moderation.check_input", true),
+		CheckOutput:  config.GetBool("moderation.check_output", false),
+		FailOpen:     config.GetBool("moderation.fail_open", false),
+		thresholds:   map[string]float64{},
+		dftThreshold: parseScore(config.GetStr("moderation.default_threshold")),
+	}
+	for _, item := range strings.Split(config.GetStr("moderation.thresholds"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if score := parseScore(kv[1]); score > 0 {
+			p.thresholds[strings.TrimSpace(kv[0])] = score
+		}
+	}
+	return p
+}
+
+func parseScore(s string) float64 {
+	score, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return score
+}
+
+// Violations 返回超过阈值的分类及其分数
+func (p *Policy) Violations(result models.ModerationResult) map[string]float64 {
+	violations := map[string]float64{}
+	for category, score := range result.CategoryScores {
+		threshold, ok := p.thresholds[category]
+		if !ok {
+			threshold = p.dftThreshold
+		}
+		if threshold > 0 {
+			if score >= threshold {
+				violations[category] = score
+			}
+			continue
+		}
+		if result.Categories[category] {
+			violations[category] = score
+		}
+	}
+	return violations
+}
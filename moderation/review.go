@@ -0,0 +1,84 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+)
+
+const (
+	SourceWordList = "wordlist"
+	SourceUpstream = "upstream"
+)
+
+// ReviewRecord 待人工审核的记录
+type ReviewRecord struct {
+	Time      time.Time          `json:"time"`
+	RequestID string             `json:"request_id"`
+	UserID    int64              `json:"user_id"`
+	Stage     string             `json:"stage"`
+	Source    string             `json:"source"`
+	Blocked   bool               `json:"blocked"`
+	Hits      []Hit              `json:"hits,omitempty"`
+	Scores    map[string]float64 `json:"scores,omitempty"`
+	Content   string             `json:"content"`
+}
+
+type Recorder interface {
+	Record(ctx context.Context, record ReviewRecord)
+}
+
+var reviewFile = struct {
+	f *os.File
+	sync.Mutex
+}{}
+
+// InitReview 配置了 moderation.review_file 时审核记录按行写入该文件，否则写日志
+func InitReview() {
+	path := config.GetStr("moderation.review_file")
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		panic("open moderation review file error: " + err.Error())
+	}
+	reviewFile.f = f
+}
+
+type recorder struct {
+}
+
+func NewRecorder() Recorder {
+	return new(recorder)
+}
+
+func (r recorder) Record(ctx context.Context, record ReviewRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.RequestID = log.ParseRequestID(ctx)
+	reviewFile.Lock()
+	defer reviewFile.Unlock()
+	if reviewFile.f == nil {
+		log.WithCtxFields(ctx, log.Fields{
+			"review": record,
+		}).Warnln("moderation review")
+		return
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = reviewFile.f.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{
+			"review": record,
+			"error":  err,
+		}).Errorln("write moderation review error")
+	}
+}
@@ -0,0 +1,130 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+)
+
+const (
+	wordFileExt           = ".txt"
+	DefaultReloadInterval = 60 * time.Second
+)
+
+// dict 当前生效的词库，整体替换以实现热加载
+type dict struct {
+	matcher *Matcher
+	// 用于判断词库文件是否有变化
+	stamp string
+}
+
+var currentDict atomic.Value
+
+func init() {
+	currentDict.Store(&dict{matcher: NewMatcher(nil)})
+}
+
+func loadDict() *dict {
+	return currentDict.Load().(*dict)
+}
+
+// readWordDir 读取目录下所有 <category>.txt，每行一个词，# 开头为注释
+func readWordDir(dir string) (map[string][]string, string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+wordFileExt))
+	if err != nil {
+		return nil, "", err
+	}
+	words := make(map[string][]string, len(files))
+	stamps := make([]string, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, "", err
+		}
+		stamps = append(stamps, file+"@"+info.ModTime().String())
+		category := strings.TrimSuffix(filepath.Base(file), wordFileExt)
+		list, err := readWordFile(file)
+		if err != nil {
+			return nil, "", err
+		}
+		words[category] = list
+	}
+	return words, strings.Join(stamps, ";"), nil
+}
+
+func readWordFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var list []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		list = append(list, word)
+	}
+	return list, scanner.Err()
+}
+
+// reloadWordDir 词库文件有变化时重建自动机
+func reloadWordDir(dir string) error {
+	words, stamp, err := readWordDir(dir)
+	if err != nil {
+		return err
+	}
+	if stamp == loadDict().stamp {
+		return nil
+	}
+	currentDict.Store(&dict{
+		matcher: NewMatcher(words),
+		stamp:   stamp,
+	})
+	count := 0
+	for _, list := range words {
+		count += len(list)
+	}
+	log.WithCtxFields(context.Background(), log.Fields{
+		"dir":        dir,
+		"categories": len(words),
+		"words":      count,
+	}).Infoln("moderation word lists loaded")
+	return nil
+}
+
+// InitWordLists 加载 moderation.word_dir 下的词库，并定时检查文件变化热加载，同时重新读取 moderation.actions
+func InitWordLists() {
+	loadActions()
+	dir := config.GetStr("moderation.word_dir")
+	if dir == "" {
+		return
+	}
+	if err := reloadWordDir(dir); err != nil {
+		panic("load moderation word lists error: " + err.Error())
+	}
+	interval := time.Duration(config.GetIntDft("moderation.reload_seconds", int(DefaultReloadInterval/time.Second))) * time.Second
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			loadActions()
+			if err := reloadWordDir(dir); err != nil {
+				log.WithCtxFields(context.Background(), log.Fields{
+					"dir":   dir,
+					"error": err,
+				}).Errorln("reload moderation word lists error")
+			}
+		}
+	}()
+}
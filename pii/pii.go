@@ -0,0 +1,191 @@
+package pii
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+type detector struct {
+	kind string
+	re   *regexp.Regexp
+	// 匹配两侧不能紧挨的字符，避免把长数字的一部分当成手机号
+	boundary func(r rune) bool
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isWordChar(r rune) bool {
+	return r < unicode.MaxASCII && (r == '_' || r == '.' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// detectors 按优先级排列，先替换的内容不会再被后面的规则匹配
+var detectors = []detector{
+	{kind: "EMAIL", re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), boundary: isWordChar},
+	{kind: "ID_CARD", re: regexp.MustCompile(`[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]`), boundary: isDigit},
+	{kind: "BANK_CARD", re: regexp.MustCompile(`[1-9]\d{15,18}`), boundary: isDigit},
+	{kind: "PHONE", re: regexp.MustCompile(`(?:\+?86[ \-]?)?1[3-9]\d{9}`), boundary: isDigit},
+	{kind: "PHONE", re: regexp.MustCompile(`0\d{2,3}-\d{7,8}`), boundary: isDigit},
+	{kind: "ADDRESS", re: regexp.MustCompile(`\p{Han}{2,}(?:省|自治区|市)\p{Han}{0,10}?(?:市|区|县)\p{Han}{0,20}?(?:路|街|道|巷|村|小区)\p{Han}{0,10}?\d+(?:号|弄|栋|幢)(?:\d+(?:单元|室|楼))*`)},
+}
+
+const (
+	placeholderOpen  = "<"
+	placeholderClose = ">"
+	// 占位符最大长度，流式还原时据此决定保留多少尾部
+	maxPlaceholderLen = 32
+)
+
+var placeholderRe = regexp.MustCompile(`<[A-Z_]+_\d+>`)
+
+// Enabled pii.enable 为 true 时开启
+func Enabled() bool {
+	return config.GetBool("pii.enable", false)
+}
+
+// enabledKinds pii.types 为空时启用全部规则
+func enabledKinds() map[string]bool {
+	kinds := map[string]bool{}
+	for _, kind := range strings.Split(config.GetStr("pii.types"), ",") {
+		kind = strings.ToUpper(strings.TrimSpace(kind))
+		if kind != "" {
+			kinds[kind] = true
+		}
+	}
+	return kinds
+}
+
+// Masker 单次请求内的占位符映射，nil 表示未开启，同一个值始终替换为同一个占位符
+// 映射只保存在内存中，不能打日志也不能序列化
+type Masker struct {
+	kinds    map[string]bool
+	toHolder map[string]string
+	toOrigin map[string]string
+	counters map[string]int
+}
+
+func NewMasker() *Masker {
+	return &Masker{
+		kinds:    enabledKinds(),
+		toHolder: map[string]string{},
+		toOrigin: map[string]string{},
+		counters: map[string]int{},
+	}
+}
+
+// String 防止映射被打印到日志
+func (m *Masker) String() string {
+	if m == nil {
+		return "pii.Masker{}"
+	}
+	return fmt.Sprintf("pii.Masker{%d values}", len(m.toOrigin))
+}
+
+// MarshalJSON 防止映射被序列化到日志
+func (m *Masker) MarshalJSON() ([]byte, error) {
+	return []byte(`"[REDACTED]"`), nil
+}
+
+// Empty 没有替换过任何内容
+func (m *Masker) Empty() bool {
+	return m == nil || len(m.toOrigin) == 0
+}
+
+func (m *Masker) holder(kind, value string) string {
+	if holder, ok := m.toHolder[value]; ok {
+		return holder
+	}
+	m.counters[kind]++
+	holder := fmt.Sprintf("%s%s_%d%s", placeholderOpen, kind, m.counters[kind], placeholderClose)
+	m.toHolder[value] = holder
+	m.toOrigin[holder] = value
+	return holder
+}
+
+// Mask 把文本中的敏感信息替换为占位符，m 为 nil 时原样返回
+func (m *Masker) Mask(text string) string {
+	if m == nil {
+		return text
+	}
+	for _, d := range detectors {
+		if len(m.kinds) > 0 && !m.kinds[d.kind] {
+			continue
+		}
+		text = m.replace(text, d)
+	}
+	return text
+}
+
+func (m *Masker) replace(text string, d detector) string {
+	locs := d.re.FindAllStringIndex(text, -1)
+	if len(locs) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range locs {
+		if d.boundary != nil {
+			if r, _ := utf8.DecodeLastRuneInString(text[:loc[0]]); loc[0] > 0 && d.boundary(r) {
+				continue
+			}
+			if r, _ := utf8.DecodeRuneInString(text[loc[1]:]); loc[1] < len(text) && d.boundary(r) {
+				continue
+			}
+		}
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(m.holder(d.kind, text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Restore 把占位符还原为原始内容，模型编造的未知占位符保持原样
+func (m *Masker) Restore(text string) string {
+	if m.Empty() {
+		return text
+	}
+	return placeholderRe.ReplaceAllStringFunc(text, func(holder string) string {
+		if origin, ok := m.toOrigin[holder]; ok {
+			return origin
+		}
+		return holder
+	})
+}
+
+// NewStream 创建流式还原器，m 为 nil 时原样输出
+func (m *Masker) NewStream() *StreamRestorer {
+	return &StreamRestorer{masker: m}
+}
+
+// StreamRestorer 流式还原，占位符可能被拆到多个分片中，未闭合的 < 之后的内容暂不输出
+type StreamRestorer struct {
+	masker *Masker
+	held   string
+}
+
+func (s *StreamRestorer) Write(delta string) string {
+	if s.masker.Empty() {
+		return delta
+	}
+	text := s.held + delta
+	s.held = ""
+	if i := strings.LastIndex(text, placeholderOpen); i >= 0 &&
+		!strings.Contains(text[i:], placeholderClose) && len(text)-i < maxPlaceholderLen {
+		s.held = text[i:]
+		text = text[:i]
+	}
+	return s.masker.Restore(text)
+}
+
+func (s *StreamRestorer) Flush() string {
+	held := s.held
+	s.held = ""
+	return s.masker.Restore(held)
+}
@@ -0,0 +1,141 @@
+package repos
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+type Audio interface {
+	// Transcribe 语音转文字，req 已经过 Validate
+	Transcribe(ctx context.Context, req models.ReqTranscription) (*models.RespTranscription, error)
+	// Speech 文字转语音，返回上游的音频流，调用方读完后需要 Close
+	Speech(ctx context.Context, userID int64, req models.ReqSpeech) (io.ReadCloser, error)
+}
+
+type audio struct {
+}
+
+func NewAudio() Audio {
+	return new(audio)
+}
+
+func (a audio) Transcribe(ctx context.Context, request models.ReqTranscription) (*models.RespTranscription, error) {
+	chatgpt := gptClients.Get(request.UserID)
+	call, callCtx := startUpstreamCall(ctx, "audio.transcriptions", request.Model, chatgpt)
+	body, contentType := models.CreateReqTranscription(request)
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/audio/transcriptions"), body)
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("make request to transcriptions error")
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+chatgpt.APIKey)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := chatgpt.Client.Do(req)
+	if err != nil {
+		call.done(0, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("send transcriptions request error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		call.done(resp.StatusCode, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("read transcriptions response error")
+		return nil, err
+	}
+	rspData, err := models.ToRespTranscription(bodyBytes)
+	if err != nil {
+		call.done(resp.StatusCode, ErrTypeDecode)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+			"resp":  string(bodyBytes),
+		}).Errorln("transcriptions respose data error")
+		return nil, err
+	}
+	if rspData.Error != nil && rspData.Error.Message != "" {
+		call.done(resp.StatusCode, ErrTypeAPI)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": rspData.Error.Message,
+		}).Errorln("transcriptions server error")
+		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+	call.done(resp.StatusCode, ErrTypeNone)
+	return rspData, nil
+}
+
+func (a audio) Speech(ctx context.Context, userID int64, request models.ReqSpeech) (io.ReadCloser, error) {
+	chatgpt := gptClients.Get(userID)
+	call, callCtx := startUpstreamCall(ctx, "audio.speech", request.Model, chatgpt)
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/audio/speech"), models.CreateReqSpeech(request))
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("make request to speech error")
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+chatgpt.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := chatgpt.Client.Do(req)
+	if err != nil {
+		call.done(0, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("send speech request error")
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		call.done(resp.StatusCode, ErrTypeAPI)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.WithCtxFields(ctx, log.Fields{
+			"status": resp.StatusCode,
+			"resp":   string(bodyBytes),
+		}).Errorln("speech server error")
+		rspData, err := models.ToRespOpenApi(bodyBytes)
+		if err != nil || rspData.Error.Message == "" {
+			return nil, utils.ErrorChatGPTError
+		}
+		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+	return &upstreamBody{ReadCloser: resp.Body, call: call, status: resp.StatusCode}, nil
+}
+
+// upstreamBody 流式响应读完或关闭时结束上游调用的统计
+type upstreamBody struct {
+	io.ReadCloser
+	call   *upstreamCall
+	status int
+	err    error
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *upstreamBody) Close() error {
+	if b.call != nil {
+		b.call.done(b.status, errType(b.err))
+		b.call = nil
+	}
+	return b.ReadCloser.Close()
+}
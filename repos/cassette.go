@@ -0,0 +1,404 @@
+package repos
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+)
+
+const (
+	// CassetteRecord 上游请求和响应写入 cassette.file
+	CassetteRecord = "record"
+	// CassetteReplay 不访问上游，从 cassette.file 中回放响应
+	CassetteReplay = "replay"
+)
+
+// CassetteChunk 响应 body 的一次读取，stream 模式下约等于上游的一个分片
+type CassetteChunk struct {
+	// 距收到响应头的毫秒数
+	Offset int64  `json:"offset_ms"`
+	Data   string `json:"data"`
+}
+
+// CassetteEntry cassette 文件的一行，即一次上游请求。
+// 响应中包含完整的回答，cassette 文件属于敏感数据，只能在受控的环境中录制和保存
+type CassetteEntry struct {
+	Time time.Time `json:"time"`
+	// 发起请求的 request_id，便于按投诉的请求查找
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method"`
+	// 只保留 path 和 query，回放时可以指向不同的 base_url
+	URL           string      `json:"url"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+	// 请求 body 规范化后的摘要，回放时按它匹配
+	RequestHash string `json:"request_hash,omitempty"`
+	// 包含完整的提示词，只在 cassette.keep_request_body 为 true 时写入
+	RequestBody    string      `json:"request_body,omitempty"`
+	Status         int         `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	// 发出请求到收到响应头的毫秒数
+	HeaderDelay int64           `json:"header_delay_ms"`
+	Chunks      []CassetteChunk `json:"chunks,omitempty"`
+	// 请求或读取 body 出错时的错误
+	Error string `json:"error,omitempty"`
+}
+
+func (e *CassetteEntry) key() string {
+	hash := e.RequestHash
+	if hash == "" {
+		hash = requestHash(e.RequestBody)
+	}
+	return e.Method + " " + e.URL + "\n" + hash
+}
+
+// requestHash 请求 body 为 JSON 时按排序后的字段计算摘要，字段顺序和空白不影响匹配
+func requestHash(body string) string {
+	canonical := []byte(body)
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err == nil {
+		if data, err := json.Marshal(value); err == nil {
+			canonical = data
+		}
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// sensitiveHeaders 不写入 cassette 的请求头和响应头
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"Openai-Organization",
+	"Api-Key",
+	"X-Api-Key",
+}
+
+// apiKeyPattern 上游的错误信息中可能带有部分 key
+var apiKeyPattern = regexp.MustCompile(`sk-[A-Za-z0-9_\-*]{4,}`)
+
+func sanitizeHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	header = header.Clone()
+	for _, name := range sensitiveHeaders {
+		header.Del(name)
+	}
+	return header
+}
+
+func sanitizeBody(body string) string {
+	return apiKeyPattern.ReplaceAllString(body, "sk-***")
+}
+
+// CassetteMode cassette.mode 配置，record / replay，为空时直接访问上游
+func CassetteMode() string {
+	return config.GetStr("cassette.mode")
+}
+
+// cassetteTransport 按 cassette.mode 包装访问上游的 transport
+func cassetteTransport(rt http.RoundTripper) http.RoundTripper {
+	switch CassetteMode() {
+	case CassetteRecord:
+		return &recordTransport{next: rt, cassette: openCassette()}
+	case CassetteReplay:
+		return openReplay()
+	}
+	return rt
+}
+
+func cassetteFile() string {
+	path := config.GetStr("cassette.file")
+	if path == "" {
+		panic("cassette.file is required when cassette.mode is " + CassetteMode())
+	}
+	return path
+}
+
+type cassetteWriter struct {
+	f *os.File
+	sync.Mutex
+}
+
+var (
+	cassetteOutputOnce sync.Once
+	cassetteOutput     *cassetteWriter
+	cassetteReplayOnce sync.Once
+	cassetteReplay     *replayTransport
+)
+
+// openCassette 所有 key 共用一个 cassette 文件，文件只允许当前用户读写
+func openCassette() *cassetteWriter {
+	cassetteOutputOnce.Do(func() {
+		f, err := os.OpenFile(cassetteFile(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			panic("open cassette file error: " + err.Error())
+		}
+		cassetteOutput = &cassetteWriter{f: f}
+	})
+	return cassetteOutput
+}
+
+func (w *cassetteWriter) write(entry *CassetteEntry) {
+	entry.RequestBody = sanitizeBody(entry.RequestBody)
+	entry.RequestHash = requestHash(entry.RequestBody)
+	if !config.GetBool("cassette.keep_request_body", false) {
+		entry.RequestBody = ""
+	}
+	for i := range entry.Chunks {
+		entry.Chunks[i].Data = sanitizeBody(entry.Chunks[i].Data)
+	}
+	entry.Error = sanitizeBody(entry.Error)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	if _, err := w.f.Write(append(line, '\n')); err != nil {
+		log.WithCtxFields(context.Background(), log.Fields{
+			"url":   entry.URL,
+			"error": err,
+		}).Errorln("write cassette error")
+	}
+}
+
+// requestURI 去掉 scheme 和 host 的地址
+func requestURI(req *http.Request) string {
+	return req.URL.RequestURI()
+}
+
+type recordTransport struct {
+	next     http.RoundTripper
+	cassette *cassetteWriter
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := &CassetteEntry{
+		Time:          time.Now(),
+		RequestID:     log.ParseRequestID(req.Context()),
+		Method:        req.Method,
+		URL:           requestURI(req),
+		RequestHeader: sanitizeHeader(req.Header),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		entry.RequestBody = string(body)
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := t.next.RoundTrip(req)
+	entry.HeaderDelay = time.Since(entry.Time).Milliseconds()
+	if err != nil {
+		entry.Error = err.Error()
+		t.cassette.write(entry)
+		return nil, err
+	}
+	entry.Status = resp.StatusCode
+	entry.ResponseHeader = sanitizeHeader(resp.Header)
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		entry:      entry,
+		start:      time.Now(),
+		cassette:   t.cassette,
+	}
+	return resp, nil
+}
+
+// recordingBody 记录每次读取到的数据和时间，读完或关闭时写入 cassette
+type recordingBody struct {
+	io.ReadCloser
+	entry    *CassetteEntry
+	start    time.Time
+	cassette *cassetteWriter
+	once     sync.Once
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.entry.Chunks = append(b.entry.Chunks, CassetteChunk{
+			Offset: time.Since(b.start).Milliseconds(),
+			Data:   string(p[:n]),
+		})
+	}
+	if err != nil {
+		if err != io.EOF {
+			b.entry.Error = err.Error()
+		}
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) finish() {
+	b.once.Do(func() {
+		b.cassette.write(b.entry)
+	})
+}
+
+// ErrCassetteMiss 回放时 cassette 中没有匹配的请求
+var ErrCassetteMiss = errors.New("cassette: no recorded response")
+
+// replayTransport 按 method、path 和请求 body 的摘要匹配录制的响应，
+// 同一请求录制了多次时按顺序返回，用完后重复最后一次
+type replayTransport struct {
+	entries map[string][]*CassetteEntry
+	next    map[string]int
+	// 是否按录制时的间隔返回响应头和分片
+	timing bool
+	sync.Mutex
+}
+
+// openReplay 所有 key 共用一份回放记录
+func openReplay() *replayTransport {
+	cassetteReplayOnce.Do(func() {
+		cassetteReplay = newReplayTransport()
+	})
+	return cassetteReplay
+}
+
+func newReplayTransport() *replayTransport {
+	f, err := os.Open(cassetteFile())
+	if err != nil {
+		panic("open cassette file error: " + err.Error())
+	}
+	defer f.Close()
+	t := &replayTransport{
+		entries: map[string][]*CassetteEntry{},
+		next:    map[string]int{},
+		timing:  config.GetBool("cassette.replay_timing", true),
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := new(CassetteEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			panic(fmt.Sprintf("cassette line %d error: %v", line, err))
+		}
+		t.entries[entry.key()] = append(t.entries[entry.key()], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		panic("read cassette file error: " + err.Error())
+	}
+	return t
+}
+
+func (t *replayTransport) match(key string) *CassetteEntry {
+	t.Lock()
+	defer t.Unlock()
+	entries := t.entries[key]
+	if len(entries) == 0 {
+		return nil
+	}
+	i := t.next[key]
+	if i < len(entries)-1 {
+		t.next[key] = i + 1
+	}
+	return entries[i]
+}
+
+// wait 回放时按录制的时间等待，ctx 取消时返回错误
+func (t *replayTransport) wait(ctx context.Context, until time.Time) error {
+	if !t.timing {
+		return nil
+	}
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe := &CassetteEntry{Method: req.Method, URL: requestURI(req)}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		probe.RequestBody = sanitizeBody(string(body))
+	}
+	probe.RequestHash = requestHash(probe.RequestBody)
+	entry := t.match(probe.key())
+	if entry == nil {
+		return nil, fmt.Errorf("%w for %s %s", ErrCassetteMiss, probe.Method, probe.URL)
+	}
+	ctx := req.Context()
+	start := time.Now()
+	if err := t.wait(ctx, start.Add(time.Duration(entry.HeaderDelay)*time.Millisecond)); err != nil {
+		return nil, err
+	}
+	if entry.Status == 0 {
+		return nil, errors.New(entry.Error)
+	}
+
+	body, writer := io.Pipe()
+	go func() {
+		start := time.Now()
+		for _, chunk := range entry.Chunks {
+			if err := t.wait(ctx, start.Add(time.Duration(chunk.Offset)*time.Millisecond)); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			if _, err := io.WriteString(writer, chunk.Data); err != nil {
+				return
+			}
+		}
+		if entry.Error != "" {
+			writer.CloseWithError(errors.New(entry.Error))
+			return
+		}
+		writer.Close()
+	}()
+	header := entry.ResponseHeader.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
@@ -1,151 +1,113 @@
 package repos
 
 import (
-	"context"
-	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"meipian.cn/meigo/v2/config"
-	"meipian.cn/meigo/v2/log"
-
-	"chatgpt_server/models"
-	"chatgpt_server/utils"
 )
 
 type GPTConfig struct {
 	APIKey string
-	Client *http.Client
+	// APIKey 的摘要，用于指标和日志
+	KeyHash string
+	Client  *http.Client
+	// stream 请求使用，没有总超时，由 ctx 和 streamWatchdog 控制
+	StreamClient *http.Client
+	// 上游返回 401/429 等状态时暂停使用到该时间 (UnixNano)
+	disabledUntil  atomic.Int64
+	disabledReason atomic.Value
 }
 
 type GPTClients []*GPTConfig
 
+// Get 按用户取 key，该 key 不可用时顺延到下一个可用的 key，全部不可用时仍返回原 key
 func (g GPTClients) Get(userID int64) *GPTConfig {
-	return g[userID%int64(len(g))]
+	start := int(userID % int64(len(g)))
+	if start < 0 {
+		start += len(g)
+	}
+	for i := 0; i < len(g); i++ {
+		if gpt := g[(start+i)%len(g)]; gpt.Usable() {
+			return gpt
+		}
+	}
+	return g[start]
 }
 
 var gptClients = make(GPTClients, 0, 5)
 
-type Chat interface {
-	SendMsg(ctx context.Context, req models.ReqChat) (*models.RespGPT3, error)
-}
-
-type chat struct {
-}
-
 func getAPIKeys() []string {
 	apiKeyStr := config.GetStr("default_api_keys")
+	if apiKeyStr == "" && Provider() == ProviderMock {
+		apiKeyStr = "sk-mock"
+	}
 	return strings.Split(apiKeyStr, ",")
 }
 
 const (
-	DefaultRequestTimeout      = 30 * time.Second
+	DefaultRequestTimeout = 30 * time.Second
+	// stream 请求等待响应头的时间，和两次收到数据之间的最长间隔
+	DefaultStreamHeaderTimeout = 30 * time.Second
+	DefaultStreamIdleTimeout   = 60 * time.Second
 	DefaultMaxIdleConns        = 1000
 	DefaultMaxIdleConnsPerHost = 50
 	DefaultMaxConnsPerHost     = 500
 	DefaultIdleConnTimeout     = 20 * time.Minute
 )
 
+// ProxyDirect proxy_url 配置为该值时不使用代理
+const ProxyDirect = "direct"
+
+// ProxyURL 访问上游使用的代理
+func ProxyURL() string {
+	return config.GetDft("proxy_url", "http://127.0.0.1:7890")
+}
+
+// apiURL 上游接口地址，openai.base_url 可指向兼容 OpenAI 的其他服务或本地 mock
+func apiURL(path string) string {
+	return strings.TrimRight(config.GetDft("openai.base_url", "https://api.openai.com"), "/") + path
+}
+
+// newUpstreamTransport 访问上游的 transport，mock 时在进程内处理请求
+func newUpstreamTransport() http.RoundTripper {
+	if Provider() == ProviderMock {
+		return newMockTransport()
+	}
+	var proxy func(*http.Request) (*url.URL, error)
+	if proxyURL := ProxyURL(); proxyURL != ProxyDirect {
+		u, _ := url.Parse(proxyURL)
+		proxy = http.ProxyURL(u)
+	}
+	return &http.Transport{
+		MaxIdleConns:        DefaultMaxIdleConns,
+		MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		MaxConnsPerHost:     DefaultMaxConnsPerHost,
+		IdleConnTimeout:     DefaultIdleConnTimeout,
+		Proxy:               proxy,
+	}
+}
+
 func InitChatGPTs() {
-	proxyUrl := "http://127.0.0.1:7890"
-	u, _ := url.Parse(proxyUrl)
 	apiKeys := getAPIKeys()
 	if len(apiKeys) == 0 {
 		panic("no avalible api keys")
 	}
 	for _, apiKey := range apiKeys {
+		transport := tracedTransport(cassetteTransport(newUpstreamTransport()))
 		gpt := &GPTConfig{
-			APIKey: apiKey,
+			APIKey:  apiKey,
+			KeyHash: HashKey(apiKey),
 			Client: &http.Client{
-				Transport: &http.Transport{
-					MaxIdleConns:        DefaultMaxIdleConns,
-					MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
-					MaxConnsPerHost:     DefaultMaxConnsPerHost,
-					IdleConnTimeout:     DefaultIdleConnTimeout,
-					Proxy:               http.ProxyURL(u),
-				},
-				Timeout: DefaultRequestTimeout,
+				Transport: transport,
+				Timeout:   DefaultRequestTimeout,
 			},
+			// 总超时包括读取 body 的时间，会截断较长的 stream 回答
+			StreamClient: &http.Client{Transport: transport},
 		}
 		gptClients = append(gptClients, gpt)
 	}
 }
-
-func NewChat() Chat {
-	return new(chat)
-}
-
-func (c chat) SendMsg(ctx context.Context, request models.ReqChat) (*models.RespGPT3, error) {
-	gptClient := gptClients.Get(request.UserID)
-	gptReq := models.CreateReqGPT3(&request)
-	if gptReq == nil {
-		return nil, utils.ErrorParamsInvalid
-	}
-	// 发送请求
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/completions", gptReq)
-	if err != nil {
-		log.WithCtxFields(ctx, log.Fields{
-			"req":   request,
-			"error": err,
-		}).Errorln("make request to send msg error")
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+gptClient.APIKey)
-	// req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.1 Safari/605.1.15")
-	// req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := gptClient.Client.Do(req)
-	if err != nil {
-		log.WithCtxFields(ctx, log.Fields{
-			"req":   request,
-			"error": err,
-		}).Errorln("send msg to chat gpt error")
-		return nil, err
-	}
-	defer resp.Body.Close()
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.WithCtxFields(ctx, log.Fields{
-			"req":   request,
-			"resp":  string(bodyBytes),
-			"error": err,
-		}).Errorln("send msg to chat gpt error")
-		return nil, err
-	}
-	rspData, err := models.ToRespOpenApi(bodyBytes)
-	if err != nil {
-		log.WithCtxFields(ctx, log.Fields{
-			"req":   request,
-			"error": err,
-			"resp":  string(bodyBytes),
-		}).Errorln("gpt respose data error")
-		return nil, err
-	}
-	if rspData.Error.Message != "" {
-		// fmt.Printf("ChatGPT Server error:%v\n", rspData.Error.Message)
-		log.WithCtxFields(ctx, log.Fields{
-			"req":   request,
-			"error": rspData.Error.Message,
-			"resp":  string(bodyBytes),
-		}).Errorln("ChatGPT Server error")
-		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
-	}
-	return models.ToRespGPT3(request, *rspData), nil
-	// line := bytes.Split(bodyBytes, []byte("\n\n"))
-	// if len(line) < 2 {
-	// 	log.WithCtxFields(ctx, log.Fields{
-	// 		"req":   request,
-	// 		"error": err,
-	// 		"resp":  string(bodyBytes),
-	// 	}).Errorln("gpt respose data error")
-	// 	fmt.Println("============================")
-	// 	fmt.Println(string(bodyBytes))
-	// 	return nil, err
-	// }
-	// endBlock := line[len(line)-3][6:]
-	// return models.ToRespChatGPT(endBlock)
-
-}
@@ -1,9 +1,14 @@
 package repos
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"meipian.cn/meigo/v2/log"
 
@@ -13,8 +18,17 @@ import (
 
 type ChatGPT interface {
 	SendMsg(ctx context.Context, req models.ReqChatGPTFromCient) (*models.RespChatGPT, error)
+	// SendMsgStream 以 stream 模式请求，每收到一个分片调用一次 onChunk，onChunk 返回错误时中断
+	SendMsgStream(ctx context.Context, req models.ReqChatGPTFromCient, onChunk func(*models.RespChatGPTChunk) error) error
 }
 
+const (
+	streamDataPrefix = "data: "
+	streamDone       = "[DONE]"
+	// 单个分片的最大长度
+	streamMaxLineSize = 1024 * 1024
+)
+
 type chatGPT struct {
 }
 
@@ -24,13 +38,15 @@ func NewChatGPT() ChatGPT {
 
 func (c chatGPT) SendMsg(ctx context.Context, request models.ReqChatGPTFromCient) (*models.RespChatGPT, error) {
 	chatgpt := gptClients.Get(request.UserID)
-	gptReq := models.CreateReqChatGPT(&request)
-	if gptReq == nil {
-		return nil, utils.ErrorParamsInvalid
+	gptReq, err := models.CreateReqChatGPT(&request)
+	if err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
 	}
+	call, callCtx := startUpstreamCall(ctx, "chat.completions", request.Model, chatgpt)
 	// 发送请求
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", gptReq)
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/chat/completions"), gptReq)
 	if err != nil {
+		call.done(0, ErrTypeNetwork)
 		log.WithCtxFields(ctx, log.Fields{
 			"req":   request,
 			"error": err,
@@ -44,6 +60,7 @@ func (c chatGPT) SendMsg(ctx context.Context, request models.ReqChatGPTFromCient
 
 	resp, err := chatgpt.Client.Do(req)
 	if err != nil {
+		call.done(0, errType(err))
 		log.WithCtxFields(ctx, log.Fields{
 			"req":   request,
 			"error": err,
@@ -53,6 +70,7 @@ func (c chatGPT) SendMsg(ctx context.Context, request models.ReqChatGPTFromCient
 	defer resp.Body.Close()
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
+		call.done(resp.StatusCode, errType(err))
 		log.WithCtxFields(ctx, log.Fields{
 			"req":   request,
 			"resp":  string(bodyBytes),
@@ -62,6 +80,7 @@ func (c chatGPT) SendMsg(ctx context.Context, request models.ReqChatGPTFromCient
 	}
 	rspData, err := models.ToRespChatGPT(bodyBytes)
 	if err != nil {
+		call.done(resp.StatusCode, ErrTypeDecode)
 		log.WithCtxFields(ctx, log.Fields{
 			"req":   request,
 			"error": err,
@@ -69,5 +88,148 @@ func (c chatGPT) SendMsg(ctx context.Context, request models.ReqChatGPTFromCient
 		}).Errorln("gpt respose data error")
 		return nil, err
 	}
+	if rspData.Error != nil && rspData.Error.Message != "" {
+		call.done(resp.StatusCode, ErrTypeAPI)
+		log.WithCtxFields(ctx, log.Fields{
+			"req":   request,
+			"error": rspData.Error.Message,
+			"resp":  string(bodyBytes),
+		}).Errorln("ChatGPT Server error")
+		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+	call.tokens(rspData.Usage.PromptTokens, rspData.Usage.CompletionTokens)
+	call.done(resp.StatusCode, ErrTypeNone)
 	return rspData, nil
 }
+
+func (c chatGPT) SendMsgStream(ctx context.Context, request models.ReqChatGPTFromCient, onChunk func(*models.RespChatGPTChunk) error) error {
+	chatgpt := gptClients.Get(request.UserID)
+	request.Stream = true
+	gptReq, err := models.CreateReqChatGPT(&request)
+	if err != nil {
+		return utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	call, callCtx := startUpstreamCall(ctx, "chat.completions", request.Model, chatgpt)
+	callCtx, watchdog := newStreamWatchdog(callCtx)
+	defer watchdog.stop()
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/chat/completions"), gptReq)
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		log.WithCtxFields(ctx, log.Fields{
+			"req":   request,
+			"error": err,
+		}).Errorln("make request to send msg error")
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+chatgpt.APIKey)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := chatgpt.StreamClient.Do(req)
+	if err != nil {
+		err = watchdog.wrap(err)
+		call.done(0, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"req":   request,
+			"error": err,
+		}).Errorln("send msg to chat gpt error")
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		call.done(resp.StatusCode, ErrTypeAPI)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.WithCtxFields(ctx, log.Fields{
+			"req":    request,
+			"status": resp.StatusCode,
+			"resp":   string(bodyBytes),
+		}).Errorln("ChatGPT Server error")
+		rspData, err := models.ToRespOpenApi(bodyBytes)
+		if err != nil || rspData.Error.Message == "" {
+			return utils.ErrorChatGPTError
+		}
+		return utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), streamMaxLineSize)
+	for scanner.Scan() {
+		watchdog.touch()
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, []byte(streamDataPrefix)) {
+			continue
+		}
+		data := bytes.TrimPrefix(line, []byte(streamDataPrefix))
+		if string(data) == streamDone {
+			call.done(resp.StatusCode, ErrTypeNone)
+			return nil
+		}
+		chunk, err := models.ToRespChatGPTChunk(data)
+		if err != nil {
+			call.done(resp.StatusCode, ErrTypeDecode)
+			log.WithCtxFields(ctx, log.Fields{
+				"req":   request,
+				"error": err,
+				"resp":  string(data),
+			}).Errorln("gpt respose data error")
+			return err
+		}
+		// stream 响应没有 usage，不上报 token 数
+		for _, choice := range chunk.Choices {
+			if choice.Index == 0 && (choice.Delta.Content != "" || len(choice.Delta.ToolCalls) > 0 || choice.Delta.FunctionCall != nil) {
+				call.delta(choice.Delta)
+			}
+		}
+		if err := onChunk(chunk); err != nil {
+			call.done(resp.StatusCode, ErrTypeCanceled)
+			return err
+		}
+		watchdog.touch()
+	}
+	if err := watchdog.wrap(scanner.Err()); err != nil {
+		call.done(resp.StatusCode, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"req":   request,
+			"error": err,
+		}).Errorln("read chat gpt stream error")
+		return err
+	}
+	call.done(resp.StatusCode, ErrTypeNone)
+	return nil
+}
+
+// streamWatchdog stream 请求没有总超时，等待响应头超过 DefaultStreamHeaderTimeout、
+// 或两次收到数据的间隔超过 DefaultStreamIdleTimeout 时取消请求
+type streamWatchdog struct {
+	timer    *time.Timer
+	cancel   context.CancelFunc
+	timedOut atomic.Bool
+}
+
+func newStreamWatchdog(ctx context.Context) (context.Context, *streamWatchdog) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &streamWatchdog{cancel: cancel}
+	w.timer = time.AfterFunc(DefaultStreamHeaderTimeout, func() {
+		w.timedOut.Store(true)
+		cancel()
+	})
+	return ctx, w
+}
+
+// touch 收到数据后重新计时
+func (w *streamWatchdog) touch() {
+	w.timer.Reset(DefaultStreamIdleTimeout)
+}
+
+func (w *streamWatchdog) stop() {
+	w.timer.Stop()
+	w.cancel()
+}
+
+// wrap 因超时取消时返回 context.DeadlineExceeded，指标中记为 timeout 而不是 canceled
+func (w *streamWatchdog) wrap(err error) error {
+	if err != nil && w.timedOut.Load() {
+		return fmt.Errorf("%w: upstream stream stalled: %v", context.DeadlineExceeded, err)
+	}
+	return err
+}
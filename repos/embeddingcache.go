@@ -0,0 +1,158 @@
+package repos
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+)
+
+const (
+	DefaultEmbeddingCacheSize = 10000
+	DefaultEmbeddingCacheTTL  = 7 * 24 * time.Hour
+
+	embeddingCachePrefix = "embedding:"
+)
+
+// EmbeddingCache 按内容哈希缓存向量，先查进程内 LRU，再查 redis
+type EmbeddingCache interface {
+	// Get 返回与 keys 一一对应的向量，未命中的为 nil
+	Get(ctx context.Context, keys []string) [][]float32
+	Set(ctx context.Context, keys []string, vectors [][]float32)
+}
+
+var (
+	embeddingCacheOnce   sync.Once
+	sharedEmbeddingCache *embeddingCache
+)
+
+// NewEmbeddingCache 所有调用方共用一个缓存，大小为 embeddings.cache_size，为 0 时不使用进程内缓存
+func NewEmbeddingCache() EmbeddingCache {
+	embeddingCacheOnce.Do(func() {
+		sharedEmbeddingCache = &embeddingCache{
+			size:  config.GetIntDft("embeddings.cache_size", DefaultEmbeddingCacheSize),
+			ttl:   time.Duration(config.GetIntDft("embeddings.cache_ttl_s", int(DefaultEmbeddingCacheTTL/time.Second))) * time.Second,
+			items: map[string]*list.Element{},
+			order: list.New(),
+		}
+	})
+	return sharedEmbeddingCache
+}
+
+type cacheEntry struct {
+	key    string
+	vector []float32
+}
+
+type embeddingCache struct {
+	size  int
+	ttl   time.Duration
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+func (c *embeddingCache) Get(ctx context.Context, keys []string) [][]float32 {
+	vectors := make([][]float32, len(keys))
+	var missed []int
+	c.mu.Lock()
+	for i, key := range keys {
+		if elem, ok := c.items[key]; ok {
+			c.order.MoveToFront(elem)
+			vectors[i] = elem.Value.(*cacheEntry).vector
+		} else {
+			missed = append(missed, i)
+		}
+	}
+	c.mu.Unlock()
+	if len(missed) == 0 || !RedisEnabled() {
+		return vectors
+	}
+	args := make([]interface{}, len(missed))
+	for j, i := range missed {
+		args[j] = redisKey(embeddingCachePrefix + keys[i])
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("embedding cache redis error")
+		return vectors
+	}
+	defer conn.Close()
+	values, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("embedding cache redis error")
+		return vectors
+	}
+	for j, i := range missed {
+		if len(values[j]) > 0 {
+			vectors[i] = decodeVector(values[j])
+			c.add(keys[i], vectors[i])
+		}
+	}
+	return vectors
+}
+
+func (c *embeddingCache) Set(ctx context.Context, keys []string, vectors [][]float32) {
+	for i, key := range keys {
+		c.add(key, vectors[i])
+	}
+	if !RedisEnabled() {
+		return
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("embedding cache redis error")
+		return
+	}
+	defer conn.Close()
+	for i, key := range keys {
+		_ = conn.Send("SETEX", redisKey(embeddingCachePrefix+key), int(c.ttl/time.Second), encodeVector(vectors[i]))
+	}
+	if _, err := conn.Do(""); err != nil {
+		log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("embedding cache redis error")
+	}
+}
+
+// add 写入进程内 LRU，超过大小时淘汰最久未使用的
+func (c *embeddingCache) add(key string, vector []float32) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).vector = vector
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key, vector})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// encodeVector 以小端 float32 存储
+func encodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
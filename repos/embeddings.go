@@ -0,0 +1,81 @@
+package repos
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+type Embeddings interface {
+	// Create 请求上游生成向量，inputs 为一批输入，返回的 Data 按 Index 对应 inputs
+	Create(ctx context.Context, userID int64, model string, inputs []string) (*models.RespEmbeddings, error)
+}
+
+type embeddings struct {
+}
+
+func NewEmbeddings() Embeddings {
+	return new(embeddings)
+}
+
+func (e embeddings) Create(ctx context.Context, userID int64, model string, inputs []string) (*models.RespEmbeddings, error) {
+	chatgpt := gptClients.Get(userID)
+	call, callCtx := startUpstreamCall(ctx, "embeddings", model, chatgpt)
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/embeddings"),
+		models.CreateReqEmbeddings(model, inputs, userID))
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("make request to embeddings error")
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+chatgpt.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := chatgpt.Client.Do(req)
+	if err != nil {
+		call.done(0, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("send embeddings request error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		call.done(resp.StatusCode, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("read embeddings response error")
+		return nil, err
+	}
+	rspData, err := models.ToRespEmbeddings(bodyBytes)
+	if err != nil {
+		call.done(resp.StatusCode, ErrTypeDecode)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+			"resp":  string(bodyBytes),
+		}).Errorln("embeddings respose data error")
+		return nil, err
+	}
+	if rspData.Error != nil && rspData.Error.Message != "" {
+		call.done(resp.StatusCode, ErrTypeAPI)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": rspData.Error.Message,
+		}).Errorln("embeddings server error")
+		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+	if len(rspData.Data) != len(inputs) {
+		call.done(resp.StatusCode, ErrTypeDecode)
+		return nil, utils.ErrorChatGPTError.NewWithMsg("embeddings count mismatch")
+	}
+	call.tokens(rspData.Usage.PromptTokens, 0)
+	call.done(resp.StatusCode, ErrTypeNone)
+	return rspData, nil
+}
@@ -0,0 +1,75 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+const (
+	DefaultFetchTimeout = 10 * time.Second
+	// 下载用户提供的地址时最多跟随的重定向次数
+	maxFetchRedirects = 3
+)
+
+// ErrTooLarge 下载的内容超过限制
+var ErrTooLarge = fmt.Errorf("content too large")
+
+// fetchClient 只连接公网地址，重定向的每一跳同样检查 scheme 和 image.fetch_hosts
+var fetchClient = &lazyClient{build: func() *http.Client {
+	return &http.Client{
+		Transport: tracedTransport(newPublicTransport()),
+		Timeout:   DefaultFetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			return checkPublicURL(req.URL, fetchHosts())
+		},
+	}
+}}
+
+// fetchHosts 允许下载的主机，逗号分隔，包含子域名，为空时允许所有公网地址
+func fetchHosts() []string {
+	return splitHosts(config.GetStr("image.fetch_hosts"))
+}
+
+// FetchURL 下载用户提供的地址，超过 limit 字节时返回 ErrTooLarge。
+// 只允许 http 和 https，不访问回环、内网和链路本地地址
+func FetchURL(ctx context.Context, rawURL string, limit int64) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkPublicURL(u, fetchHosts()); err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := fetchClient.get().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetch %s status %d", rawURL, resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return nil, "", ErrTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(body)) > limit {
+		return nil, "", ErrTooLarge
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
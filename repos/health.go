@@ -0,0 +1,80 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"chatgpt_server/models"
+)
+
+const DefaultHealthCheckTimeout = time.Second
+
+type Health interface {
+	// Keys key 池状态，没有可用 key 时为 fail
+	Keys(ctx context.Context) models.DependencyStatus
+	// Proxy 代理端口是否可连接
+	Proxy(ctx context.Context) models.DependencyStatus
+	// Redis 未配置 redis 时为 disabled
+	Redis(ctx context.Context) models.DependencyStatus
+}
+
+type health struct {
+}
+
+func NewHealth() Health {
+	return new(health)
+}
+
+func (h health) Keys(ctx context.Context) models.DependencyStatus {
+	keys, usable := KeyPoolStatus()
+	status := models.DependencyStatus{
+		Status: models.HealthOK,
+		Detail: fmt.Sprintf("%d/%d keys usable", usable, len(keys)),
+		Extra:  keys,
+	}
+	if usable == 0 {
+		status.Status = models.HealthFail
+	}
+	return status
+}
+
+func (h health) Proxy(ctx context.Context) models.DependencyStatus {
+	if ProxyURL() == ProxyDirect {
+		return models.DependencyStatus{Status: models.HealthDisabled}
+	}
+	if CassetteMode() == CassetteReplay {
+		return models.DependencyStatus{Status: models.HealthDisabled, Detail: "cassette replay"}
+	}
+	if Provider() == ProviderMock {
+		return models.DependencyStatus{Status: models.HealthDisabled, Detail: "mock provider"}
+	}
+	u, err := url.Parse(ProxyURL())
+	if err != nil {
+		return models.DependencyStatus{Status: models.HealthFail, Detail: err.Error()}
+	}
+	dialer := net.Dialer{Timeout: DefaultHealthCheckTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return models.DependencyStatus{Status: models.HealthFail, Detail: err.Error()}
+	}
+	conn.Close()
+	return models.DependencyStatus{Status: models.HealthOK, Detail: u.Host}
+}
+
+func (h health) Redis(ctx context.Context) models.DependencyStatus {
+	if !RedisEnabled() {
+		return models.DependencyStatus{Status: models.HealthDisabled}
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return models.DependencyStatus{Status: models.HealthFail, Detail: err.Error()}
+	}
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return models.DependencyStatus{Status: models.HealthFail, Detail: err.Error()}
+	}
+	return models.DependencyStatus{Status: models.HealthOK}
+}
@@ -0,0 +1,76 @@
+package repos
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+type Images interface {
+	// Generate 请求上游生成图片，req 已经过 Validate
+	Generate(ctx context.Context, userID int64, req models.ReqImageGenerate) (*models.RespImages, error)
+}
+
+type images struct {
+}
+
+func NewImages() Images {
+	return new(images)
+}
+
+func (i images) Generate(ctx context.Context, userID int64, request models.ReqImageGenerate) (*models.RespImages, error) {
+	chatgpt := gptClients.Get(userID)
+	call, callCtx := startUpstreamCall(ctx, "images.generations", request.Model, chatgpt)
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/images/generations"),
+		models.CreateReqImageGenerate(request, userID))
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("make request to images error")
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+chatgpt.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := chatgpt.Client.Do(req)
+	if err != nil {
+		call.done(0, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("send images request error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		call.done(resp.StatusCode, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("read images response error")
+		return nil, err
+	}
+	rspData, err := models.ToRespImages(bodyBytes)
+	if err != nil {
+		call.done(resp.StatusCode, ErrTypeDecode)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+			"resp":  string(bodyBytes),
+		}).Errorln("images respose data error")
+		return nil, err
+	}
+	if rspData.Error != nil && rspData.Error.Message != "" {
+		call.done(resp.StatusCode, ErrTypeAPI)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": rspData.Error.Message,
+		}).Errorln("images server error")
+		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+	call.done(resp.StatusCode, ErrTypeNone)
+	return rspData, nil
+}
@@ -0,0 +1,98 @@
+package repos
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultInternalTimeout = 5 * time.Second
+	// 内部接口响应的最大长度，超过的部分截断
+	internalMaxBody = 64 * 1024
+)
+
+// Internal 内部 HTTP 接口，供服务端工具调用
+type Internal interface {
+	// Article 按 ID 查询文章，返回接口的原始 json
+	Article(ctx context.Context, id string) ([]byte, error)
+	// UserProfile 查询用户资料，返回接口的原始 json
+	UserProfile(ctx context.Context, userID int64) ([]byte, error)
+}
+
+type internal struct {
+	client *lazyClient
+}
+
+var internalClient = &lazyClient{build: func() *http.Client {
+	return &http.Client{
+		Transport: tracedTransport(http.DefaultTransport),
+		Timeout:   DefaultInternalTimeout,
+	}
+}}
+
+func NewInternal() Internal {
+	return &internal{client: internalClient}
+}
+
+// internalURL 配置中的 {id} 替换为转义后的 id
+func internalURL(key, id string) (string, error) {
+	tpl := config.GetStr(key)
+	if tpl == "" {
+		return "", fmt.Errorf("%s is not configured", key)
+	}
+	return strings.ReplaceAll(tpl, "{id}", url.PathEscape(id)), nil
+}
+
+func (i internal) get(ctx context.Context, key, id string) ([]byte, error) {
+	u, err := internalURL(key, id)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := i.client.get().Do(req)
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{
+			"url":   u,
+			"error": err,
+		}).Errorln("request internal api error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, internalMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, utils.ErrorNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.WithCtxFields(ctx, log.Fields{
+			"url":    u,
+			"status": resp.StatusCode,
+			"resp":   string(body),
+		}).Errorln("internal api error")
+		return nil, fmt.Errorf("internal api status %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+func (i internal) Article(ctx context.Context, id string) ([]byte, error) {
+	return i.get(ctx, "tools.article_api", id)
+}
+
+func (i internal) UserProfile(ctx context.Context, userID int64) ([]byte, error) {
+	return i.get(ctx, "tools.user_profile_api", fmt.Sprint(userID))
+}
@@ -0,0 +1,181 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"chatgpt_server/models"
+)
+
+const (
+	jobPrefix  = "job:"
+	taskPrefix = "task:"
+)
+
+// JobStore 保存异步任务的状态，配置了 redis 时多实例共享，否则只在本进程内
+type JobStore interface {
+	Save(ctx context.Context, job *models.Job, ttl time.Duration) error
+	// Transition 保存的任务状态为 from 之一时才覆盖，返回是否保存，任务不存在时不保存
+	Transition(ctx context.Context, job *models.Job, ttl time.Duration, from ...string) (bool, error)
+	// Get 任务不存在或已过期时返回 nil
+	Get(ctx context.Context, id string) (*models.Job, error)
+}
+
+// NewJobStore /jobs 接口的任务
+func NewJobStore() JobStore {
+	if RedisEnabled() {
+		return &redisJobStore{prefix: jobPrefix}
+	}
+	return defaultMemoryJobStore
+}
+
+// NewTaskStore kafka 任务的状态，与 NewJobStore 分开保存，/jobs 接口不能读取或取消
+func NewTaskStore() JobStore {
+	if RedisEnabled() {
+		return &redisJobStore{prefix: taskPrefix}
+	}
+	return defaultMemoryTaskStore
+}
+
+type redisJobStore struct {
+	prefix string
+}
+
+func (s redisJobStore) Save(ctx context.Context, job *models.Job, ttl time.Duration) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Do("SET", redisKey(s.prefix+job.ID), body, "PX", ttl.Milliseconds())
+	return err
+}
+
+// transitionScript ARGV: 新的任务、ttl 毫秒、允许的当前状态...
+var transitionScript = redis.NewScript(1, `
+local current = redis.call('GET', KEYS[1])
+if not current then
+	return 0
+end
+local status = cjson.decode(current)['status']
+for i = 3, #ARGV do
+	if ARGV[i] == status then
+		redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+		return 1
+	end
+end
+return 0
+`)
+
+func (s redisJobStore) Transition(ctx context.Context, job *models.Job, ttl time.Duration, from ...string) (bool, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	args := []interface{}{redisKey(s.prefix + job.ID), body, ttl.Milliseconds()}
+	for _, status := range from {
+		args = append(args, status)
+	}
+	return redis.Bool(transitionScript.Do(conn, args...))
+}
+
+func (s redisJobStore) Get(ctx context.Context, id string) (*models.Job, error) {
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	body, err := redis.Bytes(conn.Do("GET", redisKey(s.prefix+id)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return models.ToJob(body)
+}
+
+type memoryJob struct {
+	body      []byte
+	expiresAt time.Time
+}
+
+// memoryJobStore 没有 redis 时的替身，保存时每分钟最多清理一次过期的任务
+type memoryJobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]memoryJob
+	lastSweep time.Time
+}
+
+var (
+	defaultMemoryJobStore  = &memoryJobStore{jobs: map[string]memoryJob{}}
+	defaultMemoryTaskStore = &memoryJobStore{jobs: map[string]memoryJob{}}
+)
+
+func (s *memoryJobStore) Save(ctx context.Context, job *models.Job, ttl time.Duration) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for id, j := range s.jobs {
+			if now.After(j.expiresAt) {
+				delete(s.jobs, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.jobs[job.ID] = memoryJob{body: body, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryJobStore) Transition(ctx context.Context, job *models.Job, ttl time.Duration, from ...string) (bool, error) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[job.ID]
+	if !ok || now.After(j.expiresAt) {
+		return false, nil
+	}
+	current, err := models.ToJob(j.body)
+	if err != nil {
+		return false, err
+	}
+	for _, status := range from {
+		if current.Status == status {
+			s.jobs[job.ID] = memoryJob{body: body, expiresAt: now.Add(ttl)}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryJobStore) Get(ctx context.Context, id string) (*models.Job, error) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok || time.Now().After(j.expiresAt) {
+		return nil, nil
+	}
+	return models.ToJob(j.body)
+}
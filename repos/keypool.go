@@ -0,0 +1,133 @@
+package repos
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatgpt_server/models"
+)
+
+const (
+	// key 无效或没有权限，等待较长时间后再试
+	DefaultKeyAuthCooldown = 10 * time.Minute
+	// 被限流
+	DefaultKeyRateLimitCooldown = 30 * time.Second
+)
+
+// Usable key 当前是否可用
+func (g *GPTConfig) Usable() bool {
+	return time.Now().UnixNano() >= g.disabledUntil.Load()
+}
+
+// LastError key 最近一次被停用的原因
+func (g *GPTConfig) LastError() string {
+	if reason, ok := g.disabledReason.Load().(string); ok {
+		return reason
+	}
+	return ""
+}
+
+// markStatus 根据上游响应状态码更新 key 的可用状态
+func (g *GPTConfig) markStatus(status int) {
+	var cooldown time.Duration
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		cooldown = DefaultKeyAuthCooldown
+	case http.StatusTooManyRequests:
+		cooldown = DefaultKeyRateLimitCooldown
+	default:
+		if status >= 200 && status < 300 && g.disabledUntil.Load() != 0 {
+			g.disabledUntil.Store(0)
+			g.disabledReason.Store("")
+		}
+		return
+	}
+	g.disabledUntil.Store(time.Now().Add(cooldown).UnixNano())
+	g.disabledReason.Store(http.StatusText(status))
+}
+
+// KeyStatus 单个 key 的状态，只暴露摘要
+type KeyStatus struct {
+	KeyHash   string `json:"key"`
+	Usable    bool   `json:"usable"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// KeyPoolStatus 返回所有 key 的状态和可用数量
+func KeyPoolStatus() ([]KeyStatus, int) {
+	statuses := make([]KeyStatus, 0, len(gptClients))
+	usable := 0
+	for _, gpt := range gptClients {
+		status := KeyStatus{
+			KeyHash:   gpt.KeyHash,
+			Usable:    gpt.Usable(),
+			LastError: gpt.LastError(),
+		}
+		if status.Usable {
+			usable++
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, usable
+}
+
+// KeyCheck 用 models 接口检查 key 的结果
+type KeyCheck struct {
+	KeyHash string
+	Status  int
+	Error   string
+	// 上游返回的 x-ratelimit-* 响应头
+	RateLimits map[string]string
+}
+
+const rateLimitHeaderPrefix = "X-Ratelimit-"
+
+// CheckKeys 逐个请求 models 接口验证所有 key
+func CheckKeys(ctx context.Context) []KeyCheck {
+	checks := make([]KeyCheck, 0, len(gptClients))
+	for _, gpt := range gptClients {
+		checks = append(checks, checkKey(ctx, gpt))
+	}
+	return checks
+}
+
+func checkKey(ctx context.Context, gpt *GPTConfig) KeyCheck {
+	check := KeyCheck{
+		KeyHash:    gpt.KeyHash,
+		RateLimits: map[string]string{},
+	}
+	call, callCtx := startUpstreamCall(ctx, "models", "", gpt)
+	req, err := http.NewRequestWithContext(callCtx, "GET", apiURL("/v1/models"), nil)
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		check.Error = err.Error()
+		return check
+	}
+	req.Header.Set("Authorization", "Bearer "+gpt.APIKey)
+	resp, err := gpt.Client.Do(req)
+	if err != nil {
+		call.done(0, errType(err))
+		check.Error = err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+	check.Status = resp.StatusCode
+	for name, values := range resp.Header {
+		if strings.HasPrefix(name, rateLimitHeaderPrefix) && len(values) > 0 {
+			check.RateLimits[strings.ToLower(name)] = values[0]
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		if rspData, err := models.ToRespOpenApi(bodyBytes); err == nil {
+			check.Error = rspData.Error.Message
+		}
+		call.done(resp.StatusCode, ErrTypeAPI)
+		return check
+	}
+	call.done(resp.StatusCode, ErrTypeNone)
+	return check
+}
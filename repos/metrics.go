@@ -0,0 +1,232 @@
+package repos
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/knowledge"
+	"chatgpt_server/models"
+)
+
+const (
+	ProviderOpenAI = "openai"
+	// ProviderMock 进程内的 mock 上游，见 openai.provider
+	ProviderMock = "mock"
+
+	ErrTypeNone     = ""
+	ErrTypeTimeout  = "timeout"
+	ErrTypeCanceled = "canceled"
+	ErrTypeNetwork  = "network"
+	ErrTypeDecode   = "decode"
+	ErrTypeAPI      = "api"
+
+	// DefaultMetricModels metrics.models 的默认值，model 标签只使用其中的模型
+	DefaultMetricModels = "gpt-3.5-turbo,gpt-3.5-turbo-0301,gpt-4,gpt-4-32k,gpt-4-turbo,gpt-4o,gpt-4o-mini," +
+		"gpt-4.1,o1,o3,o4-mini,text-embedding-ada-002,text-embedding-3-small,text-embedding-3-large," +
+		"text-moderation-latest,omni-moderation-latest,whisper-1,tts-1,tts-1-hd,dall-e-2,dall-e-3"
+	// modelOther 不在 metrics.models 中的模型，model 由客户端传入，不能直接作为标签
+	modelOther = "other"
+)
+
+var (
+	upstreamLabels = []string{"provider", "model", "key", "status", "error"}
+
+	upstreamRequestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upstream",
+		Subsystem: "request",
+		Name:      "requests_count",
+		Help:      "The total number of upstream requests",
+	}, upstreamLabels)
+
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "upstream",
+		Subsystem: "request",
+		Name:      "duration_seconds",
+		Help:      "The upstream request latency in seconds",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, upstreamLabels)
+
+	upstreamTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "upstream",
+		Subsystem: "request",
+		Name:      "tokens_count",
+		Help:      "The total number of tokens used, type is prompt or completion",
+	}, []string{"provider", "model", "key", "type"})
+
+	upstreamTTFT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "upstream",
+		Subsystem: "stream",
+		Name:      "time_to_first_token_seconds",
+		Help:      "The time from sending a stream request to receiving the first content delta",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 8),
+	}, []string{"provider", "model", "key"})
+
+	upstreamTokensPerSecond = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "upstream",
+		Subsystem: "stream",
+		Name:      "tokens_per_second",
+		Help:      "The estimated tokens of the first choice per second after its first delta in a stream request",
+		Buckets:   prometheus.LinearBuckets(10, 10, 15),
+	}, []string{"provider", "model", "key"})
+)
+
+func init() {
+	prometheus.MustRegister(upstreamRequestCount)
+	prometheus.MustRegister(upstreamRequestDuration)
+	prometheus.MustRegister(upstreamTokens)
+	prometheus.MustRegister(upstreamTTFT)
+	prometheus.MustRegister(upstreamTokensPerSecond)
+}
+
+// HashKey API key 的摘要，用于指标和日志，不能暴露 key 本身
+func HashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// metricModel 把模型名收敛到 metrics.models 中，避免标签基数无限增长
+func metricModel(model string) string {
+	if model == "" {
+		return ""
+	}
+	for _, known := range strings.Split(config.GetDft("metrics.models", DefaultMetricModels), ",") {
+		if strings.TrimSpace(known) == model {
+			return model
+		}
+	}
+	return modelOther
+}
+
+func statusClass(status int) string {
+	if status <= 0 {
+		return "none"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// errType 把请求错误归类为指标标签
+func errType(err error) string {
+	if err == nil {
+		return ErrTypeNone
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrTypeCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTypeTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTypeTimeout
+	}
+	return ErrTypeNetwork
+}
+
+// upstreamCall 记录一次上游调用的指标和 span
+type upstreamCall struct {
+	gpt      *GPTConfig
+	provider string
+	model    string
+	key      string
+	start    time.Time
+	span     zipkin.Span
+	// stream 模式，只统计第一个回答，stream 响应没有 usage，token 数按收到的内容估算
+	firstToken time.Time
+	chunks     int
+	text       strings.Builder
+}
+
+// startUpstreamCall name 为接口名，如 chat.completions，返回的 ctx 用于创建上游请求
+func startUpstreamCall(ctx context.Context, name, model string, gpt *GPTConfig) (*upstreamCall, context.Context) {
+	c := &upstreamCall{
+		gpt:      gpt,
+		provider: Provider(),
+		model:    metricModel(model),
+		key:      gpt.KeyHash,
+		start:    time.Now(),
+	}
+	c.span, ctx = startUpstreamSpan(ctx, name, c)
+	return c, ctx
+}
+
+// done status 为 0 表示没有收到响应
+func (c *upstreamCall) done(status int, errType string) {
+	c.gpt.markStatus(status)
+	if c.chunks > 0 {
+		c.span.Tag(TagStreamChunks, strconv.Itoa(c.chunks))
+	}
+	finishUpstreamSpan(c.span, status, errType)
+	labels := prometheus.Labels{
+		"provider": c.provider,
+		"model":    c.model,
+		"key":      c.key,
+		"status":   statusClass(status),
+		"error":    errType,
+	}
+	upstreamRequestCount.With(labels).Inc()
+	upstreamRequestDuration.With(labels).Observe(time.Since(c.start).Seconds())
+	if c.chunks == 0 {
+		return
+	}
+	streamLabels := prometheus.Labels{
+		"provider": c.provider,
+		"model":    c.model,
+		"key":      c.key,
+	}
+	if elapsed := time.Since(c.firstToken).Seconds(); elapsed > 0 {
+		tokens := knowledge.EstimateTokens(c.text.String())
+		upstreamTokensPerSecond.With(streamLabels).Observe(float64(tokens) / elapsed)
+	}
+}
+
+func (c *upstreamCall) tokens(prompt, completion int) {
+	if prompt > 0 {
+		c.span.Tag(TagPromptTokens, strconv.Itoa(prompt))
+		upstreamTokens.With(prometheus.Labels{
+			"provider": c.provider,
+			"model":    c.model,
+			"key":      c.key,
+			"type":     "prompt",
+		}).Add(float64(prompt))
+	}
+	if completion > 0 {
+		c.span.Tag(TagCompletionTokens, strconv.Itoa(completion))
+		upstreamTokens.With(prometheus.Labels{
+			"provider": c.provider,
+			"model":    c.model,
+			"key":      c.key,
+			"type":     "completion",
+		}).Add(float64(completion))
+	}
+}
+
+// delta stream 模式第一个回答收到一段内容
+func (c *upstreamCall) delta(delta models.ChatGPTMessage) {
+	if c.chunks == 0 {
+		c.firstToken = time.Now()
+		c.span.Annotate(c.firstToken, "First Token")
+		upstreamTTFT.With(prometheus.Labels{
+			"provider": c.provider,
+			"model":    c.model,
+			"key":      c.key,
+		}).Observe(c.firstToken.Sub(c.start).Seconds())
+	}
+	c.chunks++
+	c.text.WriteString(delta.Content)
+	if delta.FunctionCall != nil {
+		c.text.WriteString(delta.FunctionCall.Arguments)
+	}
+	for _, call := range delta.ToolCalls {
+		c.text.WriteString(call.Function.Arguments)
+	}
+}
@@ -0,0 +1,44 @@
+package repos
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/mock"
+)
+
+// Provider openai.provider 配置，mock 时不访问网络，由进程内的 mock 上游回答
+func Provider() string {
+	return config.GetDft("openai.provider", ProviderOpenAI)
+}
+
+// MockOptions mock.* 配置的 mock 上游行为
+func MockOptions() mock.Options {
+	opts := mock.Options{
+		Latency:       time.Duration(config.GetIntDft("mock.latency_ms", 0)) * time.Millisecond,
+		ChunkInterval: time.Duration(config.GetIntDft("mock.chunk_interval_ms", 20)) * time.Millisecond,
+		ErrorStatus:   config.GetIntDft("mock.error_status", http.StatusInternalServerError),
+	}
+	opts.ErrorRate, _ = strconv.ParseFloat(config.GetStr("mock.error_rate"), 64)
+	if file := config.GetStr("mock.script"); file != "" {
+		script, err := mock.LoadScript(file)
+		if err != nil {
+			panic("load mock script error: " + err.Error())
+		}
+		opts.Script = script
+	}
+	return opts
+}
+
+// mockTransport 所有 key 共用一个 mock 上游，脚本按顺序返回的状态不会因 key 不同而分开
+var mockTransport http.RoundTripper
+
+func newMockTransport() http.RoundTripper {
+	if mockTransport == nil {
+		mockTransport = mock.NewTransport(mock.NewUpstream(MockOptions()))
+	}
+	return mockTransport
+}
@@ -0,0 +1,122 @@
+package repos
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+type Moderation interface {
+	Moderate(ctx context.Context, userID int64, inputs []string) (*models.RespModeration, error)
+}
+
+// NewModeration 按 moderation.upstream 选择实现: openai 调用上游接口，local 使用本地替身
+func NewModeration() Moderation {
+	switch config.GetStr("moderation.upstream") {
+	case "openai":
+		return new(moderation)
+	case "local":
+		return NewLocalModeration(nil)
+	}
+	return nil
+}
+
+type moderation struct {
+}
+
+func (m moderation) Moderate(ctx context.Context, userID int64, inputs []string) (*models.RespModeration, error) {
+	chatgpt := gptClients.Get(userID)
+	moderationModel := config.GetDft("moderation.model", "text-moderation-latest")
+	body := models.CreateReqModeration(inputs, moderationModel)
+	if body == nil {
+		return &models.RespModeration{}, nil
+	}
+	call, callCtx := startUpstreamCall(ctx, "moderations", moderationModel, chatgpt)
+	req, err := http.NewRequestWithContext(callCtx, "POST", apiURL("/v1/moderations"), body)
+	if err != nil {
+		call.done(0, ErrTypeNetwork)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("make request to moderation error")
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+chatgpt.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := chatgpt.Client.Do(req)
+	if err != nil {
+		call.done(0, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("send moderation request error")
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		call.done(resp.StatusCode, errType(err))
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+		}).Errorln("read moderation response error")
+		return nil, err
+	}
+	rspData, err := models.ToRespModeration(bodyBytes)
+	if err != nil {
+		call.done(resp.StatusCode, ErrTypeDecode)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": err,
+			"resp":  string(bodyBytes),
+		}).Errorln("moderation respose data error")
+		return nil, err
+	}
+	if rspData.Error.Message != "" {
+		call.done(resp.StatusCode, ErrTypeAPI)
+		log.WithCtxFields(ctx, log.Fields{
+			"error": rspData.Error.Message,
+		}).Errorln("moderation server error")
+		return nil, utils.ErrorChatGPTError.NewWithMsg(rspData.Error.Message)
+	}
+	call.done(resp.StatusCode, ErrTypeNone)
+	return rspData, nil
+}
+
+// ScoreFunc 本地替身的打分函数，返回各分类分数
+type ScoreFunc func(input string) map[string]float64
+
+type localModeration struct {
+	score ScoreFunc
+}
+
+// NewLocalModeration 不访问网络的替身，score 为 nil 时所有分数为 0
+func NewLocalModeration(score ScoreFunc) Moderation {
+	return &localModeration{score: score}
+}
+
+func (m localModeration) Moderate(ctx context.Context, userID int64, inputs []string) (*models.RespModeration, error) {
+	res := &models.RespModeration{
+		ID:      "modr-local",
+		Model:   "local",
+		Results: make([]models.ModerationResult, 0, len(inputs)),
+	}
+	for _, input := range inputs {
+		result := models.ModerationResult{
+			Categories:     map[string]bool{},
+			CategoryScores: map[string]float64{},
+		}
+		if m.score != nil {
+			for category, score := range m.score(input) {
+				result.CategoryScores[category] = score
+				result.Categories[category] = score >= 0.5
+				result.Flagged = result.Flagged || score >= 0.5
+			}
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrNonPublicAddress 用户提供的地址解析到回环、内网、链路本地等非公网地址
+var ErrNonPublicAddress = errors.New("address is not a public ip")
+
+// 除 net.IP 方法能判断的之外，不允许访问的网段
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // 本网络
+		"100.64.0.0/10",   // 运营商级 NAT
+		"192.0.0.0/24",    // IETF 协议分配
+		"192.0.2.0/24",    // 文档
+		"198.18.0.0/15",   // 基准测试
+		"198.51.100.0/24", // 文档
+		"203.0.113.0/24",  // 文档
+		"240.0.0.0/4",     // 保留
+		"64:ff9b::/96",    // NAT64，可映射到内网 IPv4
+		"2001:db8::/32",   // 文档
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPublicIP 是否为可以代用户访问的公网地址
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicDialer 在 DNS 解析之后、建立连接之前检查地址，域名解析到内网地址时同样拒绝
+var publicDialer = &net.Dialer{
+	Timeout:   10 * time.Second,
+	KeepAlive: 30 * time.Second,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+			return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+		}
+		return nil
+	},
+}
+
+// newPublicTransport 访问用户提供的地址使用的 transport，只连接公网地址，不使用代理，
+// 否则经过代理时检查的是代理的地址
+func newPublicTransport() *http.Transport {
+	return &http.Transport{
+		DialContext:           publicDialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// checkPublicURL 只允许 http 和 https，hosts 不为空时主机必须是其中之一或其子域名
+func checkPublicURL(u *url.URL, hosts []string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url scheme must be http or https")
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not allowed", host)
+}
+
+// splitHosts 逗号分隔的主机列表，转为小写
+func splitHosts(value string) []string {
+	var hosts []string
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,110 @@
+package repos
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"meipian.cn/meigo/v2/log"
+)
+
+const quotaPrefix = "quota:"
+
+// Quota 按自然日计数的用户额度，配置了 redis 时多实例共享，否则只在本进程内计数
+type Quota interface {
+	// Take 占用 n 个额度，超过 limit 时不占用，返回 false 和当前剩余的额度
+	Take(ctx context.Context, scope string, userID int64, n, limit int) (remaining int, ok bool)
+	// Refund 请求失败时归还额度
+	Refund(ctx context.Context, scope string, userID int64, n int)
+}
+
+type quota struct {
+	mu   sync.Mutex
+	day  string
+	used map[string]int
+}
+
+var (
+	defaultQuota     *quota
+	defaultQuotaOnce sync.Once
+)
+
+func NewQuota() Quota {
+	defaultQuotaOnce.Do(func() {
+		defaultQuota = &quota{used: map[string]int{}}
+	})
+	return defaultQuota
+}
+
+func quotaKey(scope string, userID int64, day string) string {
+	return redisKey(quotaPrefix) + scope + ":" + strconv.FormatInt(userID, 10) + ":" + day
+}
+
+func (q *quota) Take(ctx context.Context, scope string, userID int64, n, limit int) (int, bool) {
+	day := time.Now().Format("20060102")
+	key := quotaKey(scope, userID, day)
+	if RedisEnabled() {
+		used, err := q.incrRedis(ctx, key, n)
+		if err == nil {
+			if used > limit {
+				_, _ = q.incrRedis(ctx, key, -n)
+				return maxInt(limit-used+n, 0), false
+			}
+			return limit - used, true
+		}
+		// redis 不可用时退回进程内计数
+		log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("quota redis error")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.day != day {
+		q.day, q.used = day, map[string]int{}
+	}
+	if q.used[key]+n > limit {
+		return maxInt(limit-q.used[key], 0), false
+	}
+	q.used[key] += n
+	return limit - q.used[key], true
+}
+
+func (q *quota) Refund(ctx context.Context, scope string, userID int64, n int) {
+	day := time.Now().Format("20060102")
+	key := quotaKey(scope, userID, day)
+	if RedisEnabled() {
+		if _, err := q.incrRedis(ctx, key, -n); err == nil {
+			return
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.day == day && q.used[key] >= n {
+		q.used[key] -= n
+	}
+}
+
+// incrRedis 计数保留两天后过期
+func (q *quota) incrRedis(ctx context.Context, key string, n int) (int, error) {
+	conn, err := redisPool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	_ = conn.Send("MULTI")
+	_ = conn.Send("INCRBY", key, n)
+	_ = conn.Send("EXPIRE", key, int((48 * time.Hour).Seconds()))
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(values[0], nil)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,49 @@
+package repos
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+const (
+	redisConn = "redis"
+
+	DefaultRedisTimeout     = 2 * time.Second
+	DefaultRedisIdleTimeout = 5 * time.Minute
+)
+
+var redisPool *redis.Pool
+
+// InitRedis 配置了 redis.host 时初始化连接池
+func InitRedis() {
+	if config.GetStr(redisConn+".host") == "" {
+		return
+	}
+	cfg := config.RedisConfig(redisConn)
+	redisPool = &redis.Pool{
+		MaxIdle:     cfg.PoolSize,
+		MaxActive:   cfg.PoolSize * 10,
+		IdleTimeout: DefaultRedisIdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", cfg.Host,
+				redis.DialPassword(cfg.Auth),
+				redis.DialConnectTimeout(DefaultRedisTimeout),
+				redis.DialReadTimeout(DefaultRedisTimeout),
+				redis.DialWriteTimeout(DefaultRedisTimeout),
+			)
+		},
+	}
+}
+
+// RedisEnabled 是否配置了 redis
+func RedisEnabled() bool {
+	return redisPool != nil
+}
+
+// redisKey 加上配置的 key 前缀
+func redisKey(key string) string {
+	return config.RedisConfig(redisConn).Prefix + key
+}
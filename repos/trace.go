@@ -0,0 +1,85 @@
+package repos
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go"
+	zipkinModel "github.com/openzipkin/zipkin-go/model"
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+)
+
+const (
+	TagProvider         = "upstream.provider"
+	TagModel            = "upstream.model"
+	TagKeyHash          = "upstream.key"
+	TagPromptTokens     = "upstream.tokens.prompt"
+	TagCompletionTokens = "upstream.tokens.completion"
+	TagStreamChunks     = "upstream.stream.chunks"
+	// 第几次尝试，从 1 开始，任务重试、回调重试时大于 1
+	TagAttempt = "retry.attempt"
+)
+
+type attemptKey struct{}
+
+// WithAttempt 记录当前是第几次尝试，之后创建的上游 span 和续写 span 都带上 TagAttempt
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// Attempt ctx 中记录的尝试次数，没有重试时为 1
+func Attempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// lazyClient 第一次使用时才创建 http.Client。包级变量初始化时 zipkin tracer 还不存在，
+// tracedTransport 会退回不带 trace 的 transport，initDeps 之后创建才能记录 span
+type lazyClient struct {
+	once   sync.Once
+	client *http.Client
+	build  func() *http.Client
+}
+
+func (l *lazyClient) get() *http.Client {
+	l.once.Do(func() {
+		l.client = l.build()
+	})
+	return l.client
+}
+
+// tracedTransport 上游请求使用 zipkin transport，记录连接、TLS、首字节等 httptrace 事件
+func tracedTransport(rt http.RoundTripper) http.RoundTripper {
+	traced, err := zipkinUtil.NewTransport(zipkinUtil.ZipkinTracer,
+		zipkinUtil.RoundTripper(rt),
+		zipkinUtil.TransportTrace(true),
+	)
+	if err != nil {
+		return rt
+	}
+	return traced
+}
+
+// startUpstreamSpan 整个上游调用（含读取 body / stream）的 span，transport 的 span 是它的子 span
+func startUpstreamSpan(ctx context.Context, name string, c *upstreamCall) (zipkin.Span, context.Context) {
+	span, ctx := zipkinUtil.ZipkinTracer.StartSpanFromContext(ctx, c.provider+":"+name, zipkin.Kind(zipkinModel.Client))
+	span.Tag(TagProvider, c.provider)
+	span.Tag(TagModel, c.model)
+	span.Tag(TagKeyHash, c.key)
+	span.Tag(TagAttempt, strconv.Itoa(Attempt(ctx)))
+	return span, ctx
+}
+
+func finishUpstreamSpan(span zipkin.Span, status int, errType string) {
+	if status > 0 {
+		zipkin.TagHTTPStatusCode.Set(span, strconv.Itoa(status))
+	}
+	if errType != ErrTypeNone {
+		zipkin.TagError.Set(span, errType)
+	}
+	span.Finish()
+}
@@ -0,0 +1,142 @@
+package repos
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	zipkinModel "github.com/openzipkin/zipkin-go/model"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+)
+
+const (
+	DefaultWebhookTimeout = 5 * time.Second
+	DefaultWebhookRetries = 3
+	// 第 n 次重试前等待 DefaultWebhookBackoff * 2^(n-1)
+	DefaultWebhookBackoff = time.Second
+
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	// sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// ErrWebhookUnsigned 未配置 jobs.webhook_secret，不发送没有签名的回调
+var ErrWebhookUnsigned = errors.New("jobs.webhook_secret is not configured")
+
+// Webhook 向调用方推送签名的回调
+type Webhook interface {
+	// Send 失败或返回 5xx、429 时重试，返回最后一次的错误
+	Send(ctx context.Context, url string, body []byte) error
+	// Enabled 是否配置了签名的 secret，没有时不能使用回调
+	Enabled() bool
+}
+
+type webhook struct {
+	client  *lazyClient
+	secret  string
+	retries int
+	backoff time.Duration
+}
+
+// webhookClient 回调地址由调用方提供，只连接公网地址，不跟随重定向
+var webhookClient = &lazyClient{build: func() *http.Client {
+	return &http.Client{
+		Transport: tracedTransport(newPublicTransport()),
+		Timeout:   DefaultWebhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}}
+
+func NewWebhook() Webhook {
+	return &webhook{
+		client:  webhookClient,
+		secret:  config.GetStr("jobs.webhook_secret"),
+		retries: config.GetIntDft("jobs.webhook_retries", DefaultWebhookRetries),
+		backoff: time.Duration(config.GetIntDft("jobs.webhook_backoff_ms", int(DefaultWebhookBackoff/time.Millisecond))) * time.Millisecond,
+	}
+}
+
+// Sign 回调的签名，接收方用相同的 secret 校验
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w webhook) Enabled() bool {
+	return w.secret != ""
+}
+
+func (w webhook) Send(ctx context.Context, url string, body []byte) error {
+	if !w.Enabled() {
+		return ErrWebhookUnsigned
+	}
+	var err error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.backoff << (attempt - 1)):
+			}
+		}
+		var retry bool
+		span, attemptCtx := zipkinUtil.ZipkinTracer.StartSpanFromContext(ctx, "webhook", zipkin.Kind(zipkinModel.Client))
+		span.Tag(TagAttempt, strconv.Itoa(attempt+1))
+		retry, err = w.send(attemptCtx, url, body)
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		span.Finish()
+		if err == nil || !retry {
+			break
+		}
+		log.WithCtxFields(ctx, log.Fields{
+			"url":     url,
+			"attempt": attempt + 1,
+			"error":   err,
+		}).Warnln("webhook delivery failed")
+	}
+	return err
+}
+
+// send 返回是否可以重试
+func (w webhook) send(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, Sign(w.secret, timestamp, body))
+	resp, err := w.client.get().Do(req)
+	if errors.Is(err, ErrNonPublicAddress) {
+		return false, err
+	}
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, internalMaxBody))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook status %d", resp.StatusCode)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
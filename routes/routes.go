@@ -9,6 +9,7 @@ import (
 	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
 
 	"chatgpt_server/controllers"
+	"chatgpt_server/storage"
 )
 
 func pprofHandler(h http.HandlerFunc) gin.HandlerFunc {
@@ -36,6 +37,10 @@ func RouteInit(r *gin.Engine) {
 		debugRoute.GET("/pprof/threadcreate", pprofHandler(pprof.Handler("threadcreate").ServeHTTP))
 	}
 
+	healthCtrl := controllers.NewHealth()
+	r.GET("/healthz", healthCtrl.Healthz)
+	r.GET("/readyz", healthCtrl.Readyz)
+
 	var globalMiddleware = []gin.HandlerFunc{
 		zipkinUtil.GinZipkinMiddleware,
 	}
@@ -50,5 +55,50 @@ func RouteInit(r *gin.Engine) {
 	chatGPTRoute := root.Group("/chatGPT")
 	{
 		chatGPTRoute.POST("/sendMsg", chatCtrl.SendChatGPTMsg)
+		chatGPTRoute.POST("/sendMsgStream", chatCtrl.SendChatGPTMsgStream)
+	}
+	batchCtrl := controllers.NewBatch()
+	chatGPTRoute.POST("/batch", batchCtrl.Run)
+
+	embeddingsCtrl := controllers.NewEmbeddings()
+	root.POST("/embeddings", embeddingsCtrl.Create)
+	vectorRoute := root.Group("/vectors/:namespace")
+	{
+		vectorRoute.POST("/upsert", embeddingsCtrl.Upsert)
+		vectorRoute.POST("/delete", embeddingsCtrl.Delete)
+		vectorRoute.POST("/query", embeddingsCtrl.Query)
+	}
+
+	knowledgeCtrl := controllers.NewKnowledge()
+	knowledgeRoute := root.Group("/knowledge/:kb")
+	{
+		knowledgeRoute.POST("/documents", knowledgeCtrl.AddDocument)
+		knowledgeRoute.GET("/documents", knowledgeCtrl.ListDocuments)
+		knowledgeRoute.DELETE("/documents/:doc", knowledgeCtrl.DeleteDocument)
+	}
+
+	filesCtrl := controllers.NewFiles()
+	root.POST("/files/extract", filesCtrl.Extract)
+
+	imagesCtrl := controllers.NewImages()
+	root.POST("/images/generate", imagesCtrl.Generate)
+	jobsCtrl := controllers.NewJobs()
+	jobsRoute := root.Group("/jobs")
+	{
+		jobsRoute.POST("", jobsCtrl.Submit)
+		jobsRoute.GET("/:id", jobsCtrl.Get)
+		jobsRoute.POST("/:id/cancel", jobsCtrl.Cancel)
+	}
+
+	audioCtrl := controllers.NewAudio()
+	audioRoute := root.Group("/audio")
+	{
+		audioRoute.POST("/transcriptions", audioCtrl.Transcribe)
+		audioRoute.POST("/speech", audioCtrl.Speech)
+	}
+
+	// 转存的图片等文件，生产环境可由对象存储或 CDN 提供
+	if dir := storage.LocalDir(); dir != "" {
+		r.Static(storage.DefaultPublicPath, dir)
 	}
 }
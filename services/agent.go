@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go"
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+
+	"chatgpt_server/models"
+	"chatgpt_server/pii"
+	"chatgpt_server/tools"
+	"chatgpt_server/utils"
+)
+
+const tagToolName = "tool.name"
+
+// runAgent 模型请求的工具都在服务端注册时执行工具并继续请求，直到模型给出最终回答。
+// 模型请求了客户端定义的工具时原样返回给客户端
+func (c chatGPT) runAgent(ctx context.Context, req models.ReqChatGPTFromCient, masker *pii.Masker) (*models.RespChatGPT, error) {
+	caller := tools.Caller{Name: req.Caller, UserID: req.UserID}
+	defs, err := tools.Resolve(caller, req.ServerTools)
+	if err != nil {
+		return nil, err
+	}
+	if req.N > 1 {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("n: must be 1 with server_tools")
+	}
+	serverTools := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		serverTools[def.Function.Name] = true
+	}
+	for _, tool := range req.Tools {
+		if serverTools[tool.Function.Name] {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("tools: " + tool.Function.Name + " conflicts with server_tools")
+		}
+	}
+	req.Tools = append(req.Tools, defs...)
+
+	var (
+		usage models.ChatUsage
+		trace []models.ToolTrace
+	)
+	maxSteps := tools.MaxSteps()
+	for step := 1; ; step++ {
+		res, err := c.complete(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		usage.PromptTokens += res.Usage.PromptTokens
+		usage.CompletionTokens += res.Usage.CompletionTokens
+		usage.TotalTokens += res.Usage.TotalTokens
+		if len(res.Choices) == 0 || !allServerCalls(res.Choices[0], serverTools) {
+			res.Usage = usage
+			res.ToolTrace = trace
+			return res, nil
+		}
+		if step > maxSteps {
+			return nil, utils.ErrorToolStepsExceeded
+		}
+		message := res.Choices[0].Message
+		req.Message = append(req.Message, message)
+		for _, result := range c.executeTools(ctx, caller, message.ToolCalls, masker) {
+			result.Step = step
+			trace = append(trace, result)
+			content := result.Result
+			if masker != nil {
+				content = masker.Mask(content)
+			}
+			req.Message = append(req.Message, models.ChatGPTMessage{
+				Role:       models.RoleTool,
+				ToolCallID: result.ID,
+				Content:    content,
+			})
+		}
+	}
+}
+
+// allServerCalls 回答是否只请求了服务端的工具
+func allServerCalls(choice models.ChatChoice, serverTools map[string]bool) bool {
+	if choice.FinishReason != models.FinishReasonToolCalls || len(choice.Message.ToolCalls) == 0 {
+		return false
+	}
+	for _, call := range choice.Message.ToolCalls {
+		if !serverTools[call.Function.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+// executeTools 并行执行同一轮的工具调用，结果与 calls 顺序一致。参数中的占位符先还原
+func (c chatGPT) executeTools(ctx context.Context, caller tools.Caller, calls []models.ToolCall, masker *pii.Masker) []models.ToolTrace {
+	results := make([]models.ToolTrace, len(calls))
+	var wg sync.WaitGroup
+	for i := range calls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			call := calls[i]
+			call.Function.Arguments = masker.Restore(call.Function.Arguments)
+			span, toolCtx := zipkinUtil.ZipkinTracer.StartSpanFromContext(ctx, "tool:"+call.Function.Name)
+			span.Tag(tagToolName, call.Function.Name)
+			results[i] = tools.Execute(toolCtx, caller, call)
+			if results[i].Error != "" {
+				zipkin.TagError.Set(span, results[i].Error)
+			}
+			span.Finish()
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
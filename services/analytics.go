@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"chatgpt_server/analytics"
+	"chatgpt_server/knowledge"
+	"chatgpt_server/models"
+)
+
+type eventKey struct{}
+
+// completionEvent 一次生成的统计事件，审核命中的分类在处理过程中经 ctx 收集
+type completionEvent struct {
+	mu    sync.Mutex
+	ev    analytics.Event
+	start time.Time
+	flags map[string]bool
+	// 已经过敏感词和 PII 替换的内容，只在策略允许时发布
+	prompt      []models.ChatGPTMessage
+	completions []string
+}
+
+func startEvent(ctx context.Context, kind string, req models.ReqChatGPTFromCient) (context.Context, *completionEvent) {
+	e := &completionEvent{
+		ev: analytics.Event{
+			UserID: req.UserID,
+			Caller: req.Caller,
+			Kind:   kind,
+			Model:  req.Model,
+		},
+		start: time.Now(),
+		flags: map[string]bool{},
+	}
+	return context.WithValue(ctx, eventKey{}, e), e
+}
+
+// flagEvent 记录审核命中的分类，ctx 中没有事件时忽略，如图片生成
+func flagEvent(ctx context.Context, categories ...string) {
+	e, ok := ctx.Value(eventKey{}).(*completionEvent)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, category := range categories {
+		e.flags[category] = true
+	}
+}
+
+// firstToken 记录 stream 模式下第一个内容分片的时间
+func (e *completionEvent) firstToken() {
+	if e.ev.FirstTokenMs == 0 {
+		e.ev.FirstTokenMs = time.Since(e.start).Milliseconds()
+	}
+}
+
+// finish 记录每个回答最终的 finish_reason，续写时覆盖之前的值
+func (e *completionEvent) finish(index int, reason string) {
+	for len(e.ev.FinishReason) <= index {
+		e.ev.FinishReason = append(e.ev.FinishReason, "")
+	}
+	e.ev.FinishReason[index] = reason
+}
+
+func (e *completionEvent) model(model string) {
+	if model != "" {
+		e.ev.Model = model
+	}
+}
+
+// estimatePrompt stream 模式下按字数估算 prompt 的 token 数
+func (e *completionEvent) estimatePrompt(messages []models.ChatGPTMessage, imageTokens int) {
+	e.ev.UsageEstimated = true
+	e.ev.PromptTokens = imageTokens
+	for _, message := range messages {
+		e.ev.PromptTokens += knowledge.EstimateTokens(message.Text())
+	}
+	e.ev.ImageTokens = imageTokens
+}
+
+func (c chatGPT) publishEvent(ctx context.Context, e *completionEvent, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev := e.ev
+	ev.LatencyMs = time.Since(e.start).Milliseconds()
+	ev.Status = analytics.StatusSucceeded
+	if err != nil {
+		ev.Status = analytics.StatusFailed
+		ev.ErrorCode = errorDetail(err).Code
+	}
+	for category := range e.flags {
+		ev.ModerationFlags = append(ev.ModerationFlags, category)
+	}
+	sort.Strings(ev.ModerationFlags)
+	ev.TotalTokens = ev.PromptTokens + ev.CompletionTokens
+	ev.Cost = analytics.Cost(ev.Model, ev.PromptTokens, ev.CompletionTokens)
+	if err == nil && analytics.IncludeContent(&ev) {
+		ev.Prompt = e.prompt
+		ev.Completions = e.completions
+	}
+	c.events.Publish(ctx, ev)
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"io"
+	"sync"
+	"unicode"
+
+	"chatgpt_server/ledger"
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+	"chatgpt_server/utils"
+)
+
+const (
+	// pcm 为 24kHz、16 位、单声道，wav 为相同参数加 44 字节的文件头
+	pcmBytesPerSecond = 24000 * 2
+	wavHeaderSize     = 44
+
+	// 按字数估算合成时长，中文约每秒 4 字，其他语言约每秒 15 个字符
+	cjkCharsPerSecond   = 4
+	otherCharsPerSecond = 15
+)
+
+type Audio interface {
+	// Transcribe 语音转文字，按音频时长记录用量
+	Transcribe(ctx context.Context, req models.ReqTranscription) (*models.RespTranscription, error)
+	// Speech 文字转语音，音频流读完或关闭时按时长记录用量
+	Speech(ctx context.Context, req models.ReqSpeechFromClient) (*models.SpeechAudio, error)
+}
+
+type audio struct {
+	repo   repos.Audio
+	ledger ledger.Ledger
+}
+
+func NewAudio() Audio {
+	return &audio{
+		repos.NewAudio(),
+		ledger.NewLedger(),
+	}
+}
+
+func (a audio) Transcribe(ctx context.Context, req models.ReqTranscription) (*models.RespTranscription, error) {
+	if err := req.Validate(); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	res, err := a.repo.Transcribe(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	switch req.Timestamps {
+	case models.TimestampsNone:
+		res.Segments, res.Words = nil, nil
+	case models.TimestampsSegment:
+		res.Words = nil
+	case models.TimestampsWord:
+		res.Segments = nil
+	}
+	res.Usage = &models.AudioUsage{Seconds: res.Duration}
+	a.ledger.Record(ctx, ledger.Entry{
+		UserID:  req.UserID,
+		Kind:    ledger.KindTranscription,
+		Model:   req.Model,
+		Seconds: res.Duration,
+	})
+	return res, nil
+}
+
+func (a audio) Speech(ctx context.Context, req models.ReqSpeechFromClient) (*models.SpeechAudio, error) {
+	if err := req.Validate(); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	body, err := a.repo.Speech(ctx, req.UserID, req.ReqSpeech)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SpeechAudio{
+		ContentType: models.SpeechContentType(req.ResponseFormat),
+		Body: &meteredSpeech{
+			ReadCloser: body,
+			record: func(n int64) {
+				entry := speechUsage(req.ReqSpeech, n)
+				entry.UserID = req.UserID
+				a.ledger.Record(ctx, entry)
+			},
+		},
+	}, nil
+}
+
+// speechUsage pcm 和 wav 按字节数计算时长，压缩格式按字数和语速估算
+func speechUsage(req models.ReqSpeech, n int64) ledger.Entry {
+	entry := ledger.Entry{
+		Kind:       ledger.KindSpeech,
+		Model:      req.Model,
+		Characters: len([]rune(req.Input)),
+	}
+	switch req.ResponseFormat {
+	case "pcm":
+		entry.Seconds = float64(n) / pcmBytesPerSecond
+	case "wav":
+		if n > wavHeaderSize {
+			entry.Seconds = float64(n-wavHeaderSize) / pcmBytesPerSecond
+		}
+	default:
+		var cjk, other int
+		for _, r := range req.Input {
+			if unicode.Is(unicode.Han, r) {
+				cjk++
+			} else if !unicode.IsSpace(r) {
+				other++
+			}
+		}
+		entry.Seconds = (float64(cjk)/cjkCharsPerSecond + float64(other)/otherCharsPerSecond) / req.Speed
+		entry.Estimated = true
+	}
+	return entry
+}
+
+// meteredSpeech 统计读出的字节数，关闭时记录一次用量
+type meteredSpeech struct {
+	io.ReadCloser
+	n      int64
+	record func(n int64)
+	once   sync.Once
+}
+
+func (m *meteredSpeech) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	m.n += int64(n)
+	return n, err
+}
+
+func (m *meteredSpeech) Close() error {
+	m.once.Do(func() { m.record(m.n) })
+	return m.ReadCloser.Close()
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultBatchMaxItems = 1000
+	// 同一调用方所有批量请求共享的并发数，没有认证的调用方共享同一份
+	DefaultBatchConcurrency = 4
+)
+
+type Batch interface {
+	// Run 并发处理各项，每完成一项调用一次 send，send 按完成顺序在同一个 goroutine 中调用
+	// 单项失败时结果中带 error，只有参数错误或 send 返回错误时 Run 返回错误
+	Run(ctx context.Context, req models.ReqBatch, send func(*models.BatchResult) error) error
+}
+
+type batch struct {
+	chatGPT ChatGPT
+	limiter *callerLimiter
+}
+
+var defaultCallerLimiter = &callerLimiter{slots: map[string]*callerSlots{}}
+
+func NewBatch() Batch {
+	return &batch{
+		NewChatGPT(),
+		defaultCallerLimiter,
+	}
+}
+
+// MaxBatchItems batch.max_items 配置
+func MaxBatchItems() int {
+	return config.GetIntDft("batch.max_items", DefaultBatchMaxItems)
+}
+
+func (b batch) Run(ctx context.Context, req models.ReqBatch, send func(*models.BatchResult) error) error {
+	if err := req.Validate(MaxBatchItems()); err != nil {
+		return utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 只按认证后的调用方限制，user_id 由客户端传入，不能作为限制的依据
+	caller := req.Caller
+	concurrency := config.GetIntDft("batch.concurrency", DefaultBatchConcurrency)
+	// 缓冲所有结果，send 出错后未完成的项也不会阻塞
+	results := make(chan *models.BatchResult, len(req.Requests))
+	go func() {
+		var wg sync.WaitGroup
+		defer close(results)
+		defer wg.Wait()
+		for i := range req.Requests {
+			release, err := b.limiter.acquire(ctx, caller, concurrency)
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer release()
+				results <- b.runItem(ctx, req, i)
+			}(i)
+		}
+	}()
+	var sendErr error
+	for result := range results {
+		if sendErr != nil {
+			continue
+		}
+		if sendErr = send(result); sendErr != nil {
+			cancel()
+		}
+	}
+	return sendErr
+}
+
+func (b batch) runItem(ctx context.Context, req models.ReqBatch, i int) *models.BatchResult {
+	item := req.Requests[i]
+	result := &models.BatchResult{CustomID: item.CustomID, Index: i}
+	if item.Invalid != "" {
+		result.Error = errorDetail(utils.ErrorParamsInvalid.NewWithMsg(item.Invalid))
+		return result
+	}
+	body := item.Body
+	if req.UserID != 0 {
+		body.UserID = req.UserID
+	}
+	body.Caller = req.Caller
+	body.Stream = false
+	res, err := b.chatGPT.SendMsg(ctx, body)
+	if err != nil {
+		result.Error = errorDetail(err)
+		return result
+	}
+	result.Response = res
+	return result
+}
+
+type callerSlots struct {
+	ch   chan struct{}
+	refs int
+}
+
+// callerLimiter 按调用方限制并发，没有等待者时删除
+type callerLimiter struct {
+	mu    sync.Mutex
+	slots map[string]*callerSlots
+}
+
+func (l *callerLimiter) acquire(ctx context.Context, caller string, size int) (func(), error) {
+	l.mu.Lock()
+	s, ok := l.slots[caller]
+	if !ok {
+		s = &callerSlots{ch: make(chan struct{}, size)}
+		l.slots[caller] = s
+	}
+	s.refs++
+	l.mu.Unlock()
+	unref := func() {
+		l.mu.Lock()
+		if s.refs--; s.refs == 0 {
+			delete(l.slots, caller)
+		}
+		l.mu.Unlock()
+	}
+	select {
+	case s.ch <- struct{}{}:
+		return func() {
+			<-s.ch
+			unref()
+		}, nil
+	case <-ctx.Done():
+		unref()
+		return nil, ctx.Err()
+	}
+}
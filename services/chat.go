@@ -3,24 +3,35 @@ package services
 import (
 	"context"
 
+	"meipian.cn/meigo/v2/config"
+
 	"chatgpt_server/models"
-	"chatgpt_server/repos"
+	"chatgpt_server/utils"
 )
 
 type Chat interface {
+	// SendMsg 旧的 /chat/sendMsg，转为 chat completions 请求，模型见 chat.legacy_model
 	SendMsg(ctx context.Context, req models.ReqChat) (*models.RespGPT3, error)
 }
 
 type chat struct {
-	repo repos.Chat
+	chatGPT ChatGPT
 }
 
 func NewChat() Chat {
 	return &chat{
-		repos.NewChat(),
+		NewChatGPT(),
 	}
 }
 
 func (c chat) SendMsg(ctx context.Context, req models.ReqChat) (*models.RespGPT3, error) {
-	return c.repo.SendMsg(ctx, req)
+	chatReq, err := models.CreateReqChatFromLegacy(&req, config.GetDft("chat.legacy_model", models.DefaultChatModel))
+	if err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	res, err := c.chatGPT.SendMsg(ctx, *chatReq)
+	if err != nil {
+		return nil, err
+	}
+	return models.ToRespGPT3(req, res), nil
 }
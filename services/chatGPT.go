@@ -2,45 +2,481 @@ package services
 
 import (
 	"context"
+	"strconv"
+	"strings"
 
+	"github.com/openzipkin/zipkin-go"
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+
+	"chatgpt_server/analytics"
+	"chatgpt_server/knowledge"
 	"chatgpt_server/models"
+	"chatgpt_server/moderation"
+	"chatgpt_server/pii"
 	"chatgpt_server/repos"
+	"chatgpt_server/utils"
+	"chatgpt_server/vision"
 )
 
 type ChatGPT interface {
 	SendMsg(ctx context.Context, req models.ReqChatGPTFromCient) (*models.RespChatGPT, error)
+	SendMsgStream(ctx context.Context, req models.ReqChatGPTFromCient, send func(*models.RespChatGPTChunk) error) error
 }
 
 type chatGPT struct {
 	repo repos.ChatGPT
+	moderationGate
+	knowledge Knowledge
+	events    analytics.Publisher
 }
 
 func NewChatGPT() ChatGPT {
 	return &chatGPT{
 		repos.NewChatGPT(),
+		newModerationGate(),
+		NewKnowledge(),
+		analytics.NewPublisher(),
 	}
 }
 
+// SendMsg 结束后发布统计事件，成功或失败都会发布
 func (c chatGPT) SendMsg(ctx context.Context, req models.ReqChatGPTFromCient) (*models.RespChatGPT, error) {
-	res, err := c.repo.SendMsg(ctx, req)
+	ctx, ev := startEvent(ctx, analytics.KindChat, req)
+	res, err := c.sendMsg(ctx, req, ev)
+	c.publishEvent(ctx, ev, err)
+	return res, err
+}
+
+func (c chatGPT) sendMsg(ctx context.Context, req models.ReqChatGPTFromCient, ev *completionEvent) (*models.RespChatGPT, error) {
+	masker, err := c.prepareMessages(ctx, req.UserID, req.Message)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := c.retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	citations := injectKnowledge(&req, chunks)
+	imageTokens, err := prepareImages(ctx, req.Message)
+	if err != nil {
+		return nil, err
+	}
+	run := c.complete
+	if len(req.ServerTools) > 0 {
+		run = func(ctx context.Context, req models.ReqChatGPTFromCient) (*models.RespChatGPT, error) {
+			return c.runAgent(ctx, req, masker)
+		}
+	}
+	var res *models.RespChatGPT
+	if isStructured(req) {
+		res, err = c.completeStructured(ctx, req, run)
+	} else {
+		res, err = run(ctx, req)
+	}
+	if err != nil || len(res.Choices) == 0 {
+		return res, err
+	}
+	res.Usage.ImageTokens = imageTokens
+	res.Citations = citations
+	ev.model(res.Model)
+	ev.ev.PromptTokens = res.Usage.PromptTokens
+	ev.ev.CompletionTokens = res.Usage.CompletionTokens
+	ev.ev.ImageTokens = imageTokens
+	ev.prompt = req.Message
+	for i := range res.Choices {
+		content := res.Choices[i].Message.Content
+		if err := c.moderateCompletion(ctx, req.UserID, &res.Choices[i].Message); err != nil {
+			return nil, err
+		}
+		// logprobs 中的 token 是替换前的内容
+		if res.Choices[i].Message.Content != content {
+			res.Choices[i].Logprobs = nil
+		}
+		ev.completions = append(ev.completions, res.Choices[i].Message.Content)
+		ev.ev.FinishReason = append(ev.ev.FinishReason, res.Choices[i].FinishReason)
+		res.Choices[i].Message.Content = masker.Restore(res.Choices[i].Message.Content)
+		mapArguments(&res.Choices[i].Message, masker.Restore)
+		if res.Choices[i].Parsed != nil {
+			res.Choices[i].Parsed = parseStructured(res.Choices[i].Message.Content)
+		}
+	}
+	return res, err
+}
+
+// complete 请求一次回答，第一个回答因 length 截断时续写，usage 为所有轮次之和
+func (c chatGPT) complete(ctx context.Context, req models.ReqChatGPTFromCient) (*models.RespChatGPT, error) {
+	span, roundCtx := startRoundSpan(ctx, 0)
+	res, err := c.repo.SendMsg(roundCtx, req)
+	span.Finish()
 	if err != nil {
 		return res, err
 	}
 	if len(res.Choices) == 0 {
 		return res, err
 	}
-	for res.Choices[0].FinishReason == "length" {
-		req.Message = append(req.Message, res.Choices[0].Message)
-		nextRes, err := c.repo.SendMsg(ctx, req)
+	// 续写时只追加上一轮新返回的内容，res 中是累计的回答
+	last := res.Choices[0].Message
+	// 续写只针对第一个回答
+	req.N = 1
+	for round := 1; res.Choices[0].FinishReason == "length" && round <= MaxContinueRounds; round++ {
+		req.Message = append(req.Message, last)
+		span, roundCtx := startRoundSpan(ctx, round)
+		nextRes, err := c.repo.SendMsg(roundCtx, req)
+		span.Finish()
 		if err != nil {
 			return res, err
 		}
-		if len(res.Choices) == 0 {
+		if len(nextRes.Choices) == 0 {
 			break
 		}
-		res.Choices[0].Message.Content += nextRes.Choices[0].Message.Content
+		last = nextRes.Choices[0].Message
+		res.Choices[0].Message.Content += last.Content
 		res.Choices[0].FinishReason = nextRes.Choices[0].FinishReason
-		res.Usage = nextRes.Usage
+		if logprobs := res.Choices[0].Logprobs; logprobs != nil && nextRes.Choices[0].Logprobs != nil {
+			logprobs.Content = append(logprobs.Content, nextRes.Choices[0].Logprobs.Content...)
+		}
+		// 每一轮都按完整的提示词计费，用量按轮累加
+		res.Usage.PromptTokens += nextRes.Usage.PromptTokens
+		res.Usage.CompletionTokens += nextRes.Usage.CompletionTokens
+		res.Usage.TotalTokens += nextRes.Usage.TotalTokens
 	}
-	return res, err
+	return res, nil
+}
+
+// SendMsgStream 结束后发布统计事件，用量为估算值
+func (c chatGPT) SendMsgStream(ctx context.Context, req models.ReqChatGPTFromCient, send func(*models.RespChatGPTChunk) error) error {
+	ctx, ev := startEvent(ctx, analytics.KindChatStream, req)
+	err := c.sendMsgStream(ctx, req, send, ev)
+	c.publishEvent(ctx, ev, err)
+	return err
+}
+
+func (c chatGPT) sendMsgStream(ctx context.Context, req models.ReqChatGPTFromCient, send func(*models.RespChatGPTChunk) error, ev *completionEvent) error {
+	if len(req.ServerTools) > 0 {
+		return utils.ErrorParamsInvalid.NewWithMsg("server_tools: not supported in stream mode")
+	}
+	if isStructured(req) {
+		return utils.ErrorParamsInvalid.NewWithMsg("response_format: json_schema is not supported in stream mode")
+	}
+	masker, err := c.prepareMessages(ctx, req.UserID, req.Message)
+	if err != nil {
+		return err
+	}
+	chunks, err := c.retrieve(ctx, req)
+	if err != nil {
+		return err
+	}
+	imageTokens, err := prepareImages(ctx, req.Message)
+	if err != nil {
+		return err
+	}
+	citations := injectKnowledge(&req, chunks)
+	ev.estimatePrompt(req.Message, imageTokens)
+	ev.prompt = append([]models.ChatGPTMessage(nil), req.Message...)
+	completions := make(map[int]*strings.Builder)
+	// 结束后按输出的内容估算回答的 token 数，与最后一个分片中的 usage 一致
+	defer func() {
+		for i := 0; i < len(completions); i++ {
+			if b, ok := completions[i]; ok {
+				ev.completions = append(ev.completions, b.String())
+			}
+		}
+		ev.ev.CompletionTokens = streamUsage(0, 0, ev.completions).CompletionTokens
+	}()
+	filters := make(map[int]*moderation.StreamFilter)
+	restorers := make(map[int]*pii.StreamRestorer)
+	arguments := newArgumentRestorers(masker)
+	// 每个回答收到的原始内容，命中敏感词时送审完整的回答
+	raw := make(map[int]*strings.Builder)
+	// 开启上游输出审核时先缓存所有分片，审核通过后再发给客户端；
+	// 否则只暂存最后一个分片，结束时在其中带上 usage
+	buffered := c.upstream != nil && c.policy.CheckOutput
+	var pending []*models.RespChatGPTChunk
+	for round := 0; ; round++ {
+		var content strings.Builder
+		finishReason := ""
+		span, roundCtx := startRoundSpan(ctx, round)
+		err = c.repo.SendMsgStream(roundCtx, req, func(chunk *models.RespChatGPTChunk) error {
+			ev.model(chunk.Model)
+			// 续写只针对第一个回答，其他回答在第一轮已经结束
+			if round > 0 {
+				chunk.Choices = firstChoice(chunk.Choices)
+				if len(chunk.Choices) == 0 {
+					return nil
+				}
+			}
+			for i := range chunk.Choices {
+				choice := &chunk.Choices[i]
+				if choice.Index == 0 {
+					content.WriteString(choice.Delta.Content)
+					finishReason = choice.FinishReason
+					// 第一个回答被截断时会继续请求，对客户端隐藏中间的 length
+					if choice.FinishReason == "length" && round < MaxContinueRounds {
+						choice.FinishReason = ""
+					}
+				}
+				filter, ok := filters[choice.Index]
+				if !ok {
+					filter = c.moderator.NewStream()
+					filters[choice.Index] = filter
+					restorers[choice.Index] = masker.NewStream()
+				}
+				restorer := restorers[choice.Index]
+				if _, ok := raw[choice.Index]; !ok {
+					raw[choice.Index] = new(strings.Builder)
+				}
+				raw[choice.Index].WriteString(choice.Delta.Content)
+				res := filter.Write(choice.Delta.Content)
+				if res.Blocked || len(res.Flags) > 0 {
+					c.recordHits(ctx, req.UserID, stageCompletion, raw[choice.Index].String(), res)
+				}
+				if res.Blocked {
+					return utils.ErrorSensitiveContent
+				}
+				// 分片内容被替换或暂存时，logprobs 中的 token 会泄露原文
+				if res.Text != choice.Delta.Content {
+					choice.Logprobs = nil
+				}
+				if choice.Delta.Content != "" {
+					ev.firstToken()
+				}
+				if _, ok := completions[choice.Index]; !ok {
+					completions[choice.Index] = new(strings.Builder)
+				}
+				completions[choice.Index].WriteString(res.Text)
+				choice.Delta.Content = restorer.Write(res.Text)
+				arguments.write(choice)
+				if choice.FinishReason != "" {
+					ev.finish(choice.Index, choice.FinishReason)
+					held := filter.Flush()
+					completions[choice.Index].WriteString(held)
+					choice.Delta.Content += restorer.Write(held) + restorer.Flush()
+					arguments.flush(choice)
+				}
+			}
+			// 引用只在第一个分片中返回
+			chunk.Citations, citations = citations, nil
+			pending = append(pending, chunk)
+			if !buffered && len(pending) > 1 {
+				if err := send(pending[0]); err != nil {
+					return err
+				}
+				pending = pending[1:]
+			}
+			return nil
+		})
+		span.Finish()
+		if err != nil {
+			return err
+		}
+		if finishReason != "length" || round >= MaxContinueRounds {
+			inputs := make([]string, 0, len(completions))
+			for i := 0; i < len(completions); i++ {
+				if b, ok := completions[i]; ok {
+					inputs = append(inputs, b.String())
+				}
+			}
+			if buffered {
+				if err := c.moderateUpstream(ctx, req.UserID, stageCompletion, inputs); err != nil {
+					return err
+				}
+			}
+			if len(pending) > 0 {
+				pending[len(pending)-1].Usage = streamUsage(ev.ev.PromptTokens, imageTokens, inputs)
+			}
+			for _, chunk := range pending {
+				if err := send(chunk); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		req.Message = append(req.Message, models.ChatGPTMessage{
+			Role:    "assistant",
+			Content: content.String(),
+		})
+		req.N = 1
+	}
+}
+
+// streamUsage stream 响应没有上游的 usage，按估算的提示词和回答内容计算
+func streamUsage(promptTokens, imageTokens int, completions []string) *models.ChatUsage {
+	usage := &models.ChatUsage{PromptTokens: promptTokens, ImageTokens: imageTokens}
+	for _, completion := range completions {
+		usage.CompletionTokens += knowledge.EstimateTokens(completion)
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// firstChoice 只保留 index 为 0 的分片
+func firstChoice(choices []models.ChatChunkChoice) []models.ChatChunkChoice {
+	for i := range choices {
+		if choices[i].Index == 0 {
+			return choices[i : i+1]
+		}
+	}
+	return nil
+}
+
+// MaxContinueRounds finish_reason 为 length 时最多续写的次数，超过后按 length 返回
+const MaxContinueRounds = 5
+
+const (
+	stagePrompt     = "prompt"
+	stageCompletion = "completion"
+
+	tagRound = "chatgpt.round"
+)
+
+// startRoundSpan 每一轮请求一个子 span，round 0 为首次请求，之后为 finish_reason=length 时的续写。
+// 任务重试时带上第几次尝试，见 repos.WithAttempt
+func startRoundSpan(ctx context.Context, round int) (zipkin.Span, context.Context) {
+	span, ctx := zipkinUtil.ZipkinTracer.StartSpanFromContext(ctx, "chatgpt.round")
+	span.Tag(tagRound, strconv.Itoa(round))
+	span.Tag(repos.TagAttempt, strconv.Itoa(repos.Attempt(ctx)))
+	return span, ctx
+}
+
+// prepareMessages 发送给上游前处理所有消息: 本地敏感词 -> PII 替换 -> 上游审核
+// 返回的 Masker 用于还原回答中的占位符，未开启 PII 替换时为 nil
+func (c chatGPT) prepareMessages(ctx context.Context, userID int64, messages []models.ChatGPTMessage) (*pii.Masker, error) {
+	var masker *pii.Masker
+	if pii.Enabled() {
+		masker = pii.NewMasker()
+	}
+	for i := range messages {
+		err := messages[i].MapText(func(text string) (string, error) {
+			res := c.moderator.Check(text)
+			// 送审记录同样只保存替换后的文本，占位符与发送给上游的一致
+			if res.Blocked || len(res.Flags) > 0 {
+				c.recordHits(ctx, userID, stagePrompt, masker.Mask(text), res)
+			}
+			if res.Blocked {
+				return "", utils.ErrorSensitiveContent
+			}
+			return res.Text, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if masker != nil {
+		for i := range messages {
+			_ = messages[i].MapText(func(text string) (string, error) {
+				return masker.Mask(text), nil
+			})
+			mapArguments(&messages[i], masker.Mask)
+		}
+	}
+	if c.upstream == nil || !c.policy.CheckInput {
+		return masker, nil
+	}
+	inputs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		if message.Role == "user" {
+			inputs = append(inputs, message.Text())
+		}
+	}
+	return masker, c.moderateUpstream(ctx, userID, stagePrompt, inputs)
+}
+
+// prepareImages 校验并处理消息中的图片，返回估算的图片 token 数
+func prepareImages(ctx context.Context, messages []models.ChatGPTMessage) (int, error) {
+	tokens, err := vision.Prepare(ctx, messages)
+	if err != nil {
+		return 0, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	return tokens, nil
+}
+
+// mapArguments 处理消息中工具调用的参数，用于 PII 替换和还原
+func mapArguments(message *models.ChatGPTMessage, fn func(string) string) {
+	for i := range message.ToolCalls {
+		message.ToolCalls[i].Function.Arguments = fn(message.ToolCalls[i].Function.Arguments)
+	}
+	if message.FunctionCall != nil {
+		message.FunctionCall.Arguments = fn(message.FunctionCall.Arguments)
+	}
+}
+
+// functionCallIndex 旧的 function_call 在 argumentRestorers 中的下标
+const functionCallIndex = -1
+
+type argumentKey struct {
+	choice int
+	call   int
+}
+
+// argumentRestorers stream 模式下按 choice 和 tool call 的 index 还原参数中的占位符
+type argumentRestorers struct {
+	masker    *pii.Masker
+	restorers map[argumentKey]*pii.StreamRestorer
+}
+
+func newArgumentRestorers(masker *pii.Masker) *argumentRestorers {
+	return &argumentRestorers{masker: masker, restorers: map[argumentKey]*pii.StreamRestorer{}}
+}
+
+func (a *argumentRestorers) get(key argumentKey) *pii.StreamRestorer {
+	restorer, ok := a.restorers[key]
+	if !ok {
+		restorer = a.masker.NewStream()
+		a.restorers[key] = restorer
+	}
+	return restorer
+}
+
+func (a *argumentRestorers) write(choice *models.ChatChunkChoice) {
+	for i := range choice.Delta.ToolCalls {
+		call := &choice.Delta.ToolCalls[i]
+		index := i
+		if call.Index != nil {
+			index = *call.Index
+		}
+		call.Function.Arguments = a.get(argumentKey{choice.Index, index}).Write(call.Function.Arguments)
+	}
+	if call := choice.Delta.FunctionCall; call != nil {
+		call.Arguments = a.get(argumentKey{choice.Index, functionCallIndex}).Write(call.Arguments)
+	}
+}
+
+// flush 回答结束时把暂存的参数作为额外的 delta 补发
+func (a *argumentRestorers) flush(choice *models.ChatChunkChoice) {
+	for key, restorer := range a.restorers {
+		if key.choice != choice.Index {
+			continue
+		}
+		held := restorer.Flush()
+		if held == "" {
+			continue
+		}
+		if key.call == functionCallIndex {
+			if choice.Delta.FunctionCall == nil {
+				choice.Delta.FunctionCall = new(models.FunctionCall)
+			}
+			choice.Delta.FunctionCall.Arguments += held
+			continue
+		}
+		index := key.call
+		choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, models.ToolCall{
+			Index:    &index,
+			Function: models.FunctionCall{Arguments: held},
+		})
+	}
+}
+
+func (c chatGPT) moderateCompletion(ctx context.Context, userID int64, message *models.ChatGPTMessage) error {
+	res := c.moderator.Check(message.Content)
+	if res.Blocked || len(res.Flags) > 0 {
+		c.recordHits(ctx, userID, stageCompletion, message.Content, res)
+	}
+	if res.Blocked {
+		return utils.ErrorSensitiveContent
+	}
+	message.Content = res.Text
+	if c.upstream == nil || !c.policy.CheckOutput {
+		return nil
+	}
+	return c.moderateUpstream(ctx, userID, stageCompletion, []string{message.Content})
 }
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/kafka"
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultConsumerGroup       = "chatgpt_server"
+	DefaultConsumerConcurrency = 8
+
+	// 加入消费组失败后重试的间隔
+	consumerRetryBackoff = 2 * time.Second
+)
+
+// ConsumerOptions kafka.consumer.* 配置，命令行参数可以覆盖
+type ConsumerOptions struct {
+	Topic           string
+	Group           string
+	ReplyTopic      string
+	DeadLetterTopic string
+	// 所有分区同时处理的任务数
+	Concurrency int
+}
+
+func ConsumerOptionsFromConfig() ConsumerOptions {
+	return ConsumerOptions{
+		Topic:           config.GetStr("kafka.consumer.topic"),
+		Group:           config.GetDft("kafka.consumer.group", DefaultConsumerGroup),
+		ReplyTopic:      config.GetStr("kafka.consumer.reply_topic"),
+		DeadLetterTopic: config.GetStr("kafka.consumer.dead_letter_topic"),
+		Concurrency:     config.GetIntDft("kafka.consumer.concurrency", DefaultConsumerConcurrency),
+	}
+}
+
+func (o ConsumerOptions) Validate() error {
+	switch {
+	case !kafka.Enabled():
+		return errors.New("kafka.brokers is not configured")
+	case o.Topic == "":
+		return errors.New("kafka.consumer.topic is required")
+	case o.ReplyTopic == "":
+		return errors.New("kafka.consumer.reply_topic is required")
+	case o.DeadLetterTopic == "":
+		return errors.New("kafka.consumer.dead_letter_topic is required")
+	case o.Concurrency < 1:
+		return errors.New("kafka.consumer.concurrency must be at least 1")
+	}
+	return nil
+}
+
+// RunConsumer 消费聊天任务直到 ctx 取消，等待处理中的任务结束并提交位移后返回。
+// 结果或死信发布成功后才提交位移，发布失败时停止消费并返回错误，未提交的消息重启后重新投递
+func RunConsumer(ctx context.Context, opts ConsumerOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	cfg, err := kafka.NewConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Consumer.Return.Errors = true
+	// 新的消费组从最早的消息开始，不丢弃启动前发布的任务
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	group, err := sarama.NewConsumerGroup(kafka.Brokers(), opts.Group, cfg)
+	if err != nil {
+		return err
+	}
+	defer group.Close()
+	go func() {
+		for err := range group.Errors() {
+			log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("kafka consumer error")
+		}
+	}()
+	producer, err := kafka.NewSyncProducer()
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h := &taskConsumer{
+		opts:     opts,
+		tasks:    NewTasks(),
+		producer: producer,
+		slots:    make(chan struct{}, opts.Concurrency),
+		stop:     cancel,
+	}
+	for ctx.Err() == nil {
+		// 每次重新均衡后 Consume 返回，需要重新加入
+		if err := group.Consume(ctx, []string{opts.Topic}, h); err != nil {
+			log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("kafka consume error")
+			select {
+			case <-ctx.Done():
+			case <-time.After(consumerRetryBackoff):
+			}
+		}
+	}
+	return h.err
+}
+
+// taskConsumer 多个分区共享并发数，每个分区只提交连续处理完的位移
+type taskConsumer struct {
+	opts     ConsumerOptions
+	tasks    Tasks
+	producer sarama.SyncProducer
+	slots    chan struct{}
+	stop     context.CancelFunc
+	mu       sync.Mutex
+	err      error
+}
+
+func (h *taskConsumer) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *taskConsumer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *taskConsumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tracker := new(offsetTracker)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for msg := range claim.Messages() {
+		select {
+		case h.slots <- struct{}{}:
+		case <-sess.Context().Done():
+			return nil
+		}
+		tracker.add(msg.Offset)
+		wg.Add(1)
+		go func(msg *sarama.ConsumerMessage) {
+			defer wg.Done()
+			defer func() { <-h.slots }()
+			// 处理中的任务不随重新均衡或退出取消，发布结果后再提交位移
+			if err := h.handle(context.Background(), msg); err != nil {
+				h.fail(err)
+				return
+			}
+			if next, ok := tracker.done(msg.Offset); ok {
+				sess.MarkOffset(msg.Topic, msg.Partition, next, "")
+			}
+		}(msg)
+	}
+	return nil
+}
+
+// handle 处理一条消息，只有发布失败时返回错误
+func (h *taskConsumer) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	task, err := models.ToChatTask(msg.Value)
+	if err == nil {
+		err = task.Validate()
+	}
+	if err != nil {
+		return h.deadLetter(ctx, msg, string(msg.Key), err, 1)
+	}
+	// 任务 ID 作为 request id，与 HTTP 请求一样透传到上游和日志
+	ctx = context.WithValue(ctx, log.XRequestID, task.ID)
+	result, err := h.tasks.Process(ctx, *task)
+	if err != nil {
+		if pubErr := h.deadLetter(ctx, msg, task.ID, err, h.tasks.Attempts()); pubErr != nil {
+			return pubErr
+		}
+		// 调用方仍然会收到失败的结果
+		result = &models.ChatTaskResult{
+			ID:         task.ID,
+			Status:     models.JobFailed,
+			Error:      errorDetail(err),
+			FinishedAt: time.Now().UnixMilli(),
+		}
+	}
+	return h.publish(h.opts.ReplyTopic, task.ID, result)
+}
+
+// deadLetter 发布到死信 topic，key 为任务 ID，无法解析时为原消息的 key
+func (h *taskConsumer) deadLetter(ctx context.Context, msg *sarama.ConsumerMessage, key string, cause error, attempts int) error {
+	log.WithCtxFields(ctx, log.Fields{"partition": msg.Partition, "offset": msg.Offset, "error": cause}).Errorln("task moved to dead letter topic")
+	letter := models.DeadLetter{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UnixMilli(),
+	}
+	if e, ok := cause.(*utils.ServiceErr); ok {
+		letter.Error = utils.GetErrorMsg(e)
+	}
+	return h.publish(h.opts.DeadLetterTopic, key, letter)
+}
+
+func (h *taskConsumer) publish(topic, key string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(body)}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	_, _, err = h.producer.SendMessage(msg)
+	return err
+}
+
+// fail 记录第一个错误并停止消费
+func (h *taskConsumer) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		h.err = err
+		h.stop()
+	}
+}
+
+// offsetTracker 记录分区内处理中的位移，并发完成时只返回连续完成的下一个位移
+type offsetTracker struct {
+	mu       sync.Mutex
+	pending  []int64
+	finished map[int64]bool
+}
+
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// done 标记完成，返回可以提交的位移（下一条要读的消息）
+func (t *offsetTracker) done(offset int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished == nil {
+		t.finished = map[int64]bool{}
+	}
+	t.finished[offset] = true
+	next, ok := int64(0), false
+	for len(t.pending) > 0 && t.finished[t.pending[0]] {
+		next, ok = t.pending[0]+1, true
+		delete(t.finished, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	return next, ok
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultEmbeddingBatchSize   = 100
+	DefaultEmbeddingConcurrency = 4
+	DefaultEmbeddingMaxInputs   = 2048
+)
+
+type Embeddings interface {
+	Create(ctx context.Context, req models.ReqEmbeddingsFromClient) (*models.RespEmbeddings, error)
+}
+
+type embeddings struct {
+	repo  repos.Embeddings
+	cache repos.EmbeddingCache
+}
+
+func NewEmbeddings() Embeddings {
+	return &embeddings{
+		repos.NewEmbeddings(),
+		repos.NewEmbeddingCache(),
+	}
+}
+
+// embeddingKey 缓存键，同一模型下相同内容的向量相同
+func embeddingKey(model, input string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + input))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create 命中缓存的输入不请求上游，其余去重后按 embeddings.batch_size 分批并发请求
+func (e embeddings) Create(ctx context.Context, req models.ReqEmbeddingsFromClient) (*models.RespEmbeddings, error) {
+	if err := req.Validate(config.GetIntDft("embeddings.max_inputs", DefaultEmbeddingMaxInputs)); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	keys := make([]string, len(req.Input))
+	for i, input := range req.Input {
+		keys[i] = embeddingKey(req.Model, input)
+	}
+	vectors := e.cache.Get(ctx, keys)
+	res := &models.RespEmbeddings{
+		Object: "list",
+		Model:  req.Model,
+		Data:   make([]models.Embedding, len(req.Input)),
+	}
+	// 未命中的输入，相同内容只请求一次
+	var missKeys, missInputs []string
+	seen := map[string]bool{}
+	for i, key := range keys {
+		if vectors[i] != nil {
+			res.Usage.CachedInputs++
+			continue
+		}
+		if !seen[key] {
+			seen[key] = true
+			missKeys = append(missKeys, key)
+			missInputs = append(missInputs, req.Input[i])
+		}
+	}
+	fetched, tokens, err := e.fetch(ctx, req.UserID, req.Model, missInputs)
+	if err != nil {
+		return nil, err
+	}
+	e.cache.Set(ctx, missKeys, fetched)
+	byKey := make(map[string][]float32, len(missKeys))
+	for i, key := range missKeys {
+		byKey[key] = fetched[i]
+	}
+	for i, key := range keys {
+		vector := vectors[i]
+		if vector == nil {
+			vector = byKey[key]
+		}
+		res.Data[i] = models.Embedding{Object: "embedding", Index: i, Embedding: vector}
+	}
+	res.Usage.PromptTokens = tokens
+	res.Usage.TotalTokens = tokens
+	return res, nil
+}
+
+// fetch 分批请求上游，返回与 inputs 对应的向量和消耗的 token
+func (e embeddings) fetch(ctx context.Context, userID int64, model string, inputs []string) ([][]float32, int, error) {
+	vectors := make([][]float32, len(inputs))
+	if len(inputs) == 0 {
+		return vectors, 0, nil
+	}
+	size := config.GetIntDft("embeddings.batch_size", DefaultEmbeddingBatchSize)
+	if size <= 0 {
+		size = DefaultEmbeddingBatchSize
+	}
+	concurrency := config.GetIntDft("embeddings.concurrency", DefaultEmbeddingConcurrency)
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		tokens   int
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for start := 0; start < len(inputs); start += size {
+		end := start + size
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(start, end int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			res, err := e.repo.Create(ctx, userID, model, inputs[start:end])
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			tokens += res.Usage.PromptTokens
+			for _, data := range res.Data {
+				if data.Index >= 0 && data.Index < end-start {
+					vectors[start+data.Index] = data.Embedding
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+	return vectors, tokens, nil
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+
+	"meipian.cn/meigo/v2/log"
+
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+
+	"chatgpt_server/extract"
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+type Files interface {
+	// Extract 提取上传文件中的文字，指定知识库时作为文档加入
+	Extract(ctx context.Context, req models.ReqExtract) (*models.RespExtract, error)
+}
+
+type files struct {
+	knowledge Knowledge
+}
+
+func NewFiles() Files {
+	return &files{
+		NewKnowledge(),
+	}
+}
+
+func (f files) Extract(ctx context.Context, req models.ReqExtract) (*models.RespExtract, error) {
+	span, ctx := zipkinUtil.ZipkinTracer.StartSpanFromContext(ctx, "files.extract")
+	doc, err := extract.Extract(req.Filename, req.Data, extract.GetOptions())
+	span.Finish()
+	if err != nil {
+		if !errors.Is(err, extract.ErrUnsupported) && !errors.Is(err, extract.ErrEncrypted) {
+			log.WithCtxFields(ctx, log.Fields{"filename": req.Filename, "size": len(req.Data)}).Warnln("extract failed:", err)
+		}
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("file: " + err.Error())
+	}
+	resp := &models.RespExtract{
+		Filename:  req.Filename,
+		Format:    doc.Format,
+		Title:     doc.Title,
+		Pages:     doc.Pages,
+		Chars:     utf8.RuneCountInString(doc.Text),
+		Truncated: doc.Truncated,
+		Text:      doc.Text,
+	}
+	if req.KnowledgeBaseID == "" {
+		return resp, nil
+	}
+	if doc.Text == "" {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("file: no text found")
+	}
+	resp.Document, err = f.knowledge.AddDocument(ctx, req.KnowledgeBaseID, models.ReqKBDocument{
+		ID:     req.DocumentID,
+		Title:  doc.Title,
+		Source: req.Filename,
+		Text:   doc.Text,
+		UserID: req.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+)
+
+type Health interface {
+	// Liveness 进程存活即为 ok，同时列出各依赖状态
+	Liveness(ctx context.Context) models.HealthReport
+	// Readiness 任一必需依赖不可用时为 fail
+	Readiness(ctx context.Context) models.HealthReport
+}
+
+type health struct {
+	repo repos.Health
+}
+
+func NewHealth() Health {
+	return &health{
+		repos.NewHealth(),
+	}
+}
+
+func (h health) checks(ctx context.Context) map[string]models.DependencyStatus {
+	return map[string]models.DependencyStatus{
+		"keys":  h.repo.Keys(ctx),
+		"proxy": h.repo.Proxy(ctx),
+		"redis": h.repo.Redis(ctx),
+	}
+}
+
+func (h health) Liveness(ctx context.Context) models.HealthReport {
+	return models.HealthReport{
+		Status: models.HealthOK,
+		Checks: h.checks(ctx),
+	}
+}
+
+func (h health) Readiness(ctx context.Context) models.HealthReport {
+	report := models.HealthReport{
+		Status: models.HealthOK,
+		Checks: h.checks(ctx),
+	}
+	for _, check := range report.Checks {
+		if check.Status == models.HealthFail {
+			report.Status = models.HealthFail
+		}
+	}
+	return report
+}
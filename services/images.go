@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+	"chatgpt_server/storage"
+	"chatgpt_server/utils"
+)
+
+const quotaScopeImages = "images"
+
+type Images interface {
+	// Generate 审核提示词、扣除额度后生成图片，开启转存时图片保存到本地存储后返回地址
+	Generate(ctx context.Context, req models.ReqImageGenerateFromClient) (*models.RespImages, error)
+}
+
+type images struct {
+	repo  repos.Images
+	quota repos.Quota
+	// 未配置 storage.dir 时为 nil
+	storage storage.Storage
+	moderationGate
+}
+
+func NewImages() Images {
+	return &images{
+		repos.NewImages(),
+		repos.NewQuota(),
+		storage.New(),
+		newModerationGate(),
+	}
+}
+
+func (i images) Generate(ctx context.Context, req models.ReqImageGenerateFromClient) (*models.RespImages, error) {
+	if err := req.Validate(config.GetDft("images.model", models.DefaultImageModel)); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	prompt, err := i.checkPrompt(ctx, req.UserID, req.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	upstreamReq := req.ReqImageGenerate
+	upstreamReq.Prompt = prompt
+	// 转存时向上游请求 base64，避免再下载一次临时地址
+	rehost := config.GetBool("images.rehost", false)
+	if rehost {
+		if i.storage == nil {
+			return nil, utils.ErrorSystemError.NewWithMsg("images.rehost requires storage.dir")
+		}
+		upstreamReq.ResponseFormat = models.ImageFormatB64
+	}
+
+	var remaining *int
+	if limit := config.GetIntDft("images.daily_quota", 0); limit > 0 {
+		left, ok := i.quota.Take(ctx, quotaScopeImages, req.UserID, req.N, limit)
+		if !ok {
+			return nil, utils.ErrorQuotaExceeded.NewWithMsg("今日图片生成额度剩余 " + strconv.Itoa(left) + " 张")
+		}
+		remaining = &left
+		defer func() {
+			if err != nil {
+				i.quota.Refund(ctx, quotaScopeImages, req.UserID, req.N)
+			}
+		}()
+	}
+
+	res, err := i.repo.Generate(ctx, req.UserID, upstreamReq)
+	if err != nil {
+		return nil, err
+	}
+	if rehost {
+		if err = i.rehost(ctx, res, req.ResponseFormat); err != nil {
+			return nil, err
+		}
+	}
+	res.QuotaRemaining = remaining
+	return res, nil
+}
+
+// rehost 把 base64 图片保存到存储，format 为 url 时只返回存储地址
+func (i images) rehost(ctx context.Context, res *models.RespImages, format string) error {
+	day := time.Now().Format("20060102")
+	for j := range res.Data {
+		data, err := base64.StdEncoding.DecodeString(res.Data[j].B64JSON)
+		if err != nil {
+			log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("decode generated image error")
+			return utils.ErrorChatGPTError.NewWithMsg("invalid image data")
+		}
+		name := "images/" + day + "/" + uuid.NewString() + imageExt(data)
+		url, err := i.storage.Put(ctx, name, data)
+		if err != nil {
+			log.WithCtxFields(ctx, log.Fields{"error": err, "name": name}).Errorln("save generated image error")
+			return err
+		}
+		res.Data[j].URL = url
+		if format != models.ImageFormatB64 {
+			res.Data[j].B64JSON = ""
+		}
+	}
+	return nil
+}
+
+func imageExt(data []byte) string {
+	switch strings.TrimPrefix(http.DetectContentType(data), "image/") {
+	case "jpeg":
+		return ".jpg"
+	case "webp":
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultJobWorkers   = 4
+	DefaultJobQueueSize = 1000
+	DefaultJobTTL       = 24 * time.Hour
+	DefaultJobTimeout   = 10 * time.Minute
+	// 退出时等待执行中的任务的时间，超过后取消
+	DefaultJobShutdownTimeout = 30 * time.Second
+
+	// 任务在其他实例上被取消时，执行中的任务最迟在该间隔后停止
+	jobCancelPoll = time.Second
+)
+
+type Jobs interface {
+	// Submit 保存任务并放入队列，立即返回 queued 状态的任务
+	Submit(ctx context.Context, req models.ReqJob) (*models.Job, error)
+	// Get 任务不存在或不属于 caller 时返回 ErrorNotFound
+	Get(ctx context.Context, caller, id string) (*models.Job, error)
+	// Cancel 取消排队或执行中的任务，已结束的任务返回参数错误
+	Cancel(ctx context.Context, caller, id string) (*models.Job, error)
+}
+
+type jobs struct {
+	chatGPT ChatGPT
+	store   repos.JobStore
+	webhook repos.Webhook
+	ttl     time.Duration
+}
+
+func NewJobs() Jobs {
+	return newJobs()
+}
+
+func newJobs() *jobs {
+	return &jobs{
+		NewChatGPT(),
+		repos.NewJobStore(),
+		repos.NewWebhook(),
+		time.Duration(config.GetIntDft("jobs.ttl_s", int(DefaultJobTTL/time.Second))) * time.Second,
+	}
+}
+
+type jobTask struct {
+	job *models.Job
+	req models.ReqChatGPTFromCient
+	// 保留提交请求的 request id 和 trace，但不随请求结束而取消
+	ctx context.Context
+}
+
+// jobRunner 本进程内的任务队列和 worker，任务状态保存在 JobStore 中
+type jobRunner struct {
+	jobs    *jobs
+	queue   chan *jobTask
+	timeout time.Duration
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	stopped bool
+}
+
+var runner *jobRunner
+
+// InitJobs 启动处理异步任务的 worker，只在 HTTP 服务中调用
+func InitJobs() {
+	runner = &jobRunner{
+		jobs:    newJobs(),
+		queue:   make(chan *jobTask, config.GetIntDft("jobs.queue_size", DefaultJobQueueSize)),
+		timeout: time.Duration(config.GetIntDft("jobs.timeout_s", int(DefaultJobTimeout/time.Second))) * time.Second,
+		running: map[string]context.CancelFunc{},
+	}
+	for i := 0; i < config.GetIntDft("jobs.workers", DefaultJobWorkers); i++ {
+		runner.wg.Add(1)
+		go runner.work()
+	}
+}
+
+// StopJobs 不再接受新任务，等待执行中的任务结束，队列中未开始的任务标记为失败
+func StopJobs() {
+	if runner == nil {
+		return
+	}
+	runner.mu.Lock()
+	runner.stopped = true
+	close(runner.queue)
+	runner.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		runner.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(DefaultJobShutdownTimeout):
+		runner.mu.Lock()
+		for _, cancel := range runner.running {
+			cancel()
+		}
+		runner.mu.Unlock()
+		<-done
+	}
+}
+
+func (r *jobRunner) enqueue(task *jobTask) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return false
+	}
+	select {
+	case r.queue <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *jobRunner) work() {
+	defer r.wg.Done()
+	for task := range r.queue {
+		r.mu.Lock()
+		stopped := r.stopped
+		r.mu.Unlock()
+		if stopped {
+			r.jobs.finish(task.ctx, task.job, nil, utils.ErrorSystemError.NewWithMsg("server is shutting down"))
+			continue
+		}
+		r.run(task)
+	}
+}
+
+func (r *jobRunner) run(task *jobTask) {
+	j := r.jobs
+	job := task.job
+	job.Status = models.JobRunning
+	job.StartedAt = time.Now().UnixMilli()
+	// 排队时已被取消的任务不再执行
+	if !j.transition(task.ctx, job, models.JobQueued) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(task.ctx, r.timeout)
+	defer cancel()
+	r.mu.Lock()
+	r.running[job.ID] = cancel
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, job.ID)
+		r.mu.Unlock()
+	}()
+	go j.watchCancel(ctx, job.ID, cancel)
+
+	res, err := j.chatGPT.SendMsg(ctx, task.req)
+	if err == nil && ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	j.finish(task.ctx, job, res, err)
+}
+
+// cancelLocal 取消本进程中执行的任务
+func (r *jobRunner) cancelLocal(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancel, ok := r.running[id]; ok {
+		cancel()
+	}
+}
+
+// watchCancel 定期检查任务是否在其他实例上被取消
+func (j jobs) watchCancel(ctx context.Context, id string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(jobCancelPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if job, err := j.store.Get(ctx, id); err == nil && job != nil && job.Status == models.JobCanceled {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// finish 保存结果，配置了回调地址时在后台推送。任务已被取消时不覆盖取消状态
+func (j jobs) finish(ctx context.Context, job *models.Job, res *models.RespChatGPT, err error) {
+	job.FinishedAt = time.Now().UnixMilli()
+	if err != nil {
+		job.Status = models.JobFailed
+		if _, ok := err.(*utils.ServiceErr); !ok {
+			log.WithCtxFields(ctx, log.Fields{"job": job.ID, "error": err}).Errorln("job failed")
+		}
+		job.Error = errorDetail(err)
+	} else {
+		job.Status = models.JobSucceeded
+		job.Result = res
+	}
+	if !j.transition(ctx, job, models.JobQueued, models.JobRunning) {
+		return
+	}
+	if job.CallbackURL != "" {
+		go j.deliver(ctx, job)
+	}
+}
+
+func (j jobs) deliver(ctx context.Context, job *models.Job) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	job.Webhook = models.WebhookDelivered
+	if err := j.webhook.Send(ctx, job.CallbackURL, body); err != nil {
+		log.WithCtxFields(ctx, log.Fields{"job": job.ID, "url": job.CallbackURL, "error": err}).Errorln("job webhook failed")
+		job.Webhook = models.WebhookFailed
+	}
+	j.transition(ctx, job, job.Status)
+}
+
+// transition 任务当前的状态为 from 之一时保存，返回是否保存，状态已被其他请求改变（如取消）时不保存
+func (j jobs) transition(ctx context.Context, job *models.Job, from ...string) bool {
+	ok, err := j.store.Transition(ctx, job, j.ttl, from...)
+	if err != nil {
+		log.WithCtxFields(ctx, log.Fields{"job": job.ID, "error": err}).Errorln("save job error")
+	}
+	return ok
+}
+
+func (j jobs) Submit(ctx context.Context, req models.ReqJob) (*models.Job, error) {
+	if runner == nil {
+		return nil, utils.ErrorSystemError.NewWithMsg("async jobs are not enabled")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	// 回调必须签名，接收方才能确认来源
+	if req.CallbackURL != "" && !j.webhook.Enabled() {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("callback_url: callbacks are disabled, jobs.webhook_secret is not configured")
+	}
+	chatReq := req.ReqChatGPTFromCient
+	chatReq.Stream = false
+	if _, err := models.CreateReqChatGPT(&chatReq); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	job := &models.Job{
+		ID:          uuid.NewString(),
+		Status:      models.JobQueued,
+		UserID:      req.UserID,
+		Caller:      req.Caller,
+		CallbackURL: req.CallbackURL,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	if err := j.store.Save(ctx, job, j.ttl); err != nil {
+		log.WithCtxFields(ctx, log.Fields{"error": err}).Errorln("save job error")
+		return nil, err
+	}
+	copied := *job
+	if !runner.enqueue(&jobTask{job: &copied, req: chatReq, ctx: detach(ctx)}) {
+		j.finish(ctx, &copied, nil, utils.ErrorServiceBusy)
+		return nil, utils.ErrorServiceBusy
+	}
+	return job, nil
+}
+
+func (j jobs) Get(ctx context.Context, caller, id string) (*models.Job, error) {
+	job, err := j.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// 不属于调用方的任务与不存在的任务返回相同的错误
+	if job == nil || job.Caller != caller {
+		return nil, utils.ErrorNotFound.NewWithMsg("job not found")
+	}
+	return job, nil
+}
+
+func (j jobs) Cancel(ctx context.Context, caller, id string) (*models.Job, error) {
+	job, err := j.Get(ctx, caller, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Finished() {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("job is already " + job.Status)
+	}
+	job.Status = models.JobCanceled
+	job.FinishedAt = time.Now().UnixMilli()
+	ok, err := j.store.Transition(ctx, job, j.ttl, models.JobQueued, models.JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// 读取之后任务已经结束
+		current, err := j.Get(ctx, caller, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("job is already " + current.Status)
+	}
+	if runner != nil {
+		runner.cancelLocal(id)
+	}
+	return job, nil
+}
+
+// errorDetail 业务错误原样返回，其他错误统一为系统错误，与 outServiceErr 一致
+func errorDetail(err error) *models.ErrorDetail {
+	if _, ok := err.(*utils.ServiceErr); !ok {
+		err = utils.ErrorSystemError
+	}
+	return &models.ErrorDetail{Code: utils.GetErrorCode(err), Msg: utils.GetErrorMsg(err)}
+}
+
+// detachedContext 保留 ctx 中的值，但不继承取消和超时
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
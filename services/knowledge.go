@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"chatgpt_server/knowledge"
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+	"chatgpt_server/vector"
+)
+
+type Knowledge interface {
+	// AddDocument 切分文档并生成向量，ID 已存在时替换原文档
+	AddDocument(ctx context.Context, kbID string, req models.ReqKBDocument) (*models.KBDocument, error)
+	ListDocuments(ctx context.Context, kbID string) (*models.RespKBDocuments, error)
+	DeleteDocument(ctx context.Context, kbID, documentID string) (*models.RespKBDelete, error)
+	// Retrieve 检索与 query 最相关的资料块，知识库不存在时返回 ErrorNotFound
+	Retrieve(ctx context.Context, kbID string, userID int64, query string) ([]knowledge.Chunk, error)
+}
+
+type knowledgeBase struct {
+	embeddings Embeddings
+}
+
+func NewKnowledge() Knowledge {
+	return &knowledgeBase{
+		NewEmbeddings(),
+	}
+}
+
+func namespaceOf(kbID string) (string, error) {
+	namespace, err := knowledge.Namespace(kbID)
+	if err != nil {
+		return "", utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	return namespace, nil
+}
+
+func (k knowledgeBase) AddDocument(ctx context.Context, kbID string, req models.ReqKBDocument) (*models.KBDocument, error) {
+	namespace, err := namespaceOf(kbID)
+	if err != nil {
+		return nil, err
+	}
+	opts := knowledge.GetOptions()
+	if req.ID == "" {
+		req.ID = uuid.NewString()
+	}
+	if !knowledge.ValidID(req.ID) {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("id: must be letters, digits, _ or -")
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("text: is required")
+	}
+	if utf8.RuneCountInString(req.Text) > opts.MaxDocumentLen {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("text: exceeds " + strconv.Itoa(opts.MaxDocumentLen) + " characters")
+	}
+	if req.Title == "" {
+		req.Title = req.ID
+	}
+	chunks := knowledge.Split(req.Text, opts.ChunkSize, opts.ChunkOverlap)
+	embedReq := models.ReqEmbeddingsFromClient{UserID: req.UserID}
+	embedReq.Model = opts.Model
+	// 标题一起生成向量，只有标题能匹配的问题也能检索到
+	for _, chunk := range chunks {
+		embedReq.Input = append(embedReq.Input, req.Title+"\n"+chunk)
+	}
+	res, err := k.embeddings.Create(ctx, embedReq)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]vector.Item, len(chunks))
+	for i, chunk := range chunks {
+		items[i] = vector.Item{
+			ID:     knowledge.ChunkID(req.ID, i),
+			Vector: res.Data[i].Embedding,
+			Metadata: map[string]string{
+				knowledge.MetaDocumentID: req.ID,
+				knowledge.MetaTitle:      req.Title,
+				knowledge.MetaSource:     req.Source,
+				knowledge.MetaChunk:      strconv.Itoa(i),
+				knowledge.MetaText:       chunk,
+			},
+		}
+	}
+	store := vector.Knowledge()
+	// 替换文档时先删除多出来的旧块
+	if stale := k.chunkIDs(namespace, req.ID, len(chunks)); len(stale) > 0 {
+		if _, err := store.Delete(namespace, stale); err != nil {
+			return nil, err
+		}
+	}
+	if err := store.Upsert(namespace, items); err != nil {
+		return nil, err
+	}
+	return &models.KBDocument{ID: req.ID, Title: req.Title, Source: req.Source, Chunks: len(chunks)}, nil
+}
+
+// chunkIDs 文档中序号不小于 from 的块
+func (k knowledgeBase) chunkIDs(namespace, documentID string, from int) []string {
+	items, _ := vector.Knowledge().Items(namespace)
+	var ids []string
+	for _, item := range items {
+		if item.Metadata[knowledge.MetaDocumentID] != documentID {
+			continue
+		}
+		if index, _ := strconv.Atoi(item.Metadata[knowledge.MetaChunk]); index >= from {
+			ids = append(ids, item.ID)
+		}
+	}
+	return ids
+}
+
+func (k knowledgeBase) ListDocuments(ctx context.Context, kbID string) (*models.RespKBDocuments, error) {
+	namespace, err := namespaceOf(kbID)
+	if err != nil {
+		return nil, err
+	}
+	items, err := vector.Knowledge().Items(namespace)
+	if err != nil {
+		return nil, err
+	}
+	documents := map[string]*models.KBDocument{}
+	for _, item := range items {
+		id := item.Metadata[knowledge.MetaDocumentID]
+		doc, ok := documents[id]
+		if !ok {
+			doc = &models.KBDocument{
+				ID:     id,
+				Title:  item.Metadata[knowledge.MetaTitle],
+				Source: item.Metadata[knowledge.MetaSource],
+			}
+			documents[id] = doc
+		}
+		doc.Chunks++
+	}
+	res := &models.RespKBDocuments{Documents: make([]models.KBDocument, 0, len(documents))}
+	for _, doc := range documents {
+		res.Documents = append(res.Documents, *doc)
+	}
+	sort.Slice(res.Documents, func(i, j int) bool {
+		return res.Documents[i].ID < res.Documents[j].ID
+	})
+	return res, nil
+}
+
+func (k knowledgeBase) DeleteDocument(ctx context.Context, kbID, documentID string) (*models.RespKBDelete, error) {
+	namespace, err := namespaceOf(kbID)
+	if err != nil {
+		return nil, err
+	}
+	ids := k.chunkIDs(namespace, documentID, 0)
+	if len(ids) == 0 {
+		return nil, utils.ErrorNotFound.NewWithMsg("文档不存在")
+	}
+	n, err := vector.Knowledge().Delete(namespace, ids)
+	if err != nil {
+		return nil, err
+	}
+	return &models.RespKBDelete{Deleted: n}, nil
+}
+
+func (k knowledgeBase) Retrieve(ctx context.Context, kbID string, userID int64, query string) ([]knowledge.Chunk, error) {
+	namespace, err := namespaceOf(kbID)
+	if err != nil {
+		return nil, err
+	}
+	if vector.Knowledge().Count(namespace) == 0 {
+		return nil, utils.ErrorNotFound.NewWithMsg("知识库不存在或没有文档")
+	}
+	opts := knowledge.GetOptions()
+	req := models.ReqEmbeddingsFromClient{UserID: userID}
+	req.Model = opts.Model
+	req.Input = models.StringList{query}
+	res, err := k.embeddings.Create(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := vector.Knowledge().Query(namespace, res.Data[0].Embedding, opts.TopK)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]knowledge.Chunk, 0, len(matches))
+	for _, match := range matches {
+		if float64(match.Score) < opts.MinScore {
+			continue
+		}
+		chunks = append(chunks, knowledge.ChunkFromMatch(match))
+	}
+	return chunks, nil
+}
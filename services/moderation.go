@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+
+	"chatgpt_server/moderation"
+	"chatgpt_server/pii"
+	"chatgpt_server/repos"
+	"chatgpt_server/utils"
+)
+
+// moderationGate 本地敏感词、上游审核和送审记录，聊天和图片生成共用
+type moderationGate struct {
+	moderator moderation.Moderator
+	// 上游审核接口，未开启时为 nil
+	upstream repos.Moderation
+	policy   *moderation.Policy
+	recorder moderation.Recorder
+}
+
+func newModerationGate() moderationGate {
+	return moderationGate{
+		moderation.NewModerator(),
+		repos.NewModeration(),
+		moderation.NewPolicy(),
+		moderation.NewRecorder(),
+	}
+}
+
+// checkPrompt 检查单条提示词，返回替换了敏感词的文本。
+// 开启 PII 替换时，上游审核和送审记录使用替换后的文本
+func (g moderationGate) checkPrompt(ctx context.Context, userID int64, text string) (string, error) {
+	var masker *pii.Masker
+	if pii.Enabled() {
+		masker = pii.NewMasker()
+	}
+	res := g.moderator.Check(text)
+	if res.Blocked || len(res.Flags) > 0 {
+		g.recordHits(ctx, userID, stagePrompt, masker.Mask(text), res)
+	}
+	if res.Blocked {
+		return "", utils.ErrorSensitiveContent
+	}
+	if g.upstream == nil || !g.policy.CheckInput {
+		return res.Text, nil
+	}
+	return res.Text, g.moderateUpstream(ctx, userID, stagePrompt, []string{masker.Mask(res.Text)})
+}
+
+// moderateUpstream 调用上游审核接口，任一输入超过阈值即拒绝并送审
+func (g moderationGate) moderateUpstream(ctx context.Context, userID int64, stage string, inputs []string) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+	res, err := g.upstream.Moderate(ctx, userID, inputs)
+	if err != nil {
+		if g.policy.FailOpen {
+			return nil
+		}
+		return err
+	}
+	for i, result := range res.Results {
+		if i >= len(inputs) {
+			break
+		}
+		violations := g.policy.Violations(result)
+		if len(violations) == 0 {
+			continue
+		}
+		for category := range violations {
+			flagEvent(ctx, category)
+		}
+		g.recorder.Record(ctx, moderation.ReviewRecord{
+			UserID:  userID,
+			Stage:   stage,
+			Source:  moderation.SourceUpstream,
+			Blocked: true,
+			Scores:  violations,
+			Content: inputs[i],
+		})
+		return utils.ErrorModerationFlagged
+	}
+	return nil
+}
+
+func (g moderationGate) recordHits(ctx context.Context, userID int64, stage, content string, res moderation.Result) {
+	for _, hit := range res.Hits {
+		flagEvent(ctx, hit.Category)
+	}
+	g.recorder.Record(ctx, moderation.ReviewRecord{
+		UserID:  userID,
+		Stage:   stage,
+		Source:  moderation.SourceWordList,
+		Blocked: res.Blocked,
+		Hits:    res.Hits,
+		Content: content,
+	})
+}
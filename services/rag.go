@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+
+	"chatgpt_server/knowledge"
+	"chatgpt_server/models"
+)
+
+// retrieve 使用知识库时以最后一条 user 消息检索资料。需在 prepareMessages 之后调用，
+// 未通过审核的请求不检索，发给上游 embeddings 的是 PII 替换后的内容
+func (c chatGPT) retrieve(ctx context.Context, req models.ReqChatGPTFromCient) ([]knowledge.Chunk, error) {
+	if req.KnowledgeBaseID == "" {
+		return nil, nil
+	}
+	query := ""
+	for i := len(req.Message) - 1; i >= 0; i-- {
+		if req.Message[i].Role == models.RoleUser {
+			query = strings.TrimSpace(req.Message[i].Text())
+			break
+		}
+	}
+	if query == "" {
+		return nil, nil
+	}
+	span, ctx := zipkinUtil.ZipkinTracer.StartSpanFromContext(ctx, "knowledge.retrieve")
+	defer span.Finish()
+	span.Tag("knowledge_base_id", req.KnowledgeBaseID)
+	return c.knowledge.Retrieve(ctx, req.KnowledgeBaseID, req.UserID, query)
+}
+
+// injectKnowledge 把资料加入 system prompt，返回实际使用的引用
+// 资料的长度不超过 knowledge.context_tokens，且与消息、max_tokens 之和不超过 knowledge.context_window
+func injectKnowledge(req *models.ReqChatGPTFromCient, chunks []knowledge.Chunk) []models.Citation {
+	if len(chunks) == 0 {
+		return nil
+	}
+	opts := knowledge.GetOptions()
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = models.DefaultMaxTokens
+	}
+	budget := opts.ContextWindow - maxTokens
+	for _, message := range req.Message {
+		budget -= knowledge.EstimateTokens(message.Text())
+	}
+	if budget > opts.ContextTokens {
+		budget = opts.ContextTokens
+	}
+	prompt, citations := knowledge.BuildContext(opts.Prompt, chunks, budget)
+	if prompt == "" {
+		return nil
+	}
+	if len(req.Message) > 0 && req.Message[0].Role == models.RoleSystem && len(req.Message[0].Parts) == 0 {
+		req.Message[0].Content = strings.TrimSpace(req.Message[0].Content + "\n\n" + prompt)
+		return citations
+	}
+	system := models.ChatGPTMessage{Role: models.RoleSystem, Content: prompt}
+	req.Message = append([]models.ChatGPTMessage{system}, req.Message...)
+	return citations
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/jsonschema"
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+const (
+	// 回答不符合 schema 时要求模型修正的次数
+	DefaultStructuredRetries = 2
+	// 支持 response_format json_schema 的模型，按前缀匹配
+	DefaultStructuredNativeModels = "gpt-4o,gpt-4.1,o1,o3,o4-mini"
+)
+
+// completeFunc 请求一次完整的回答，complete 或 runAgent
+type completeFunc func(ctx context.Context, req models.ReqChatGPTFromCient) (*models.RespChatGPT, error)
+
+func isStructured(req models.ReqChatGPTFromCient) bool {
+	return req.ResponseFormat != nil && req.ResponseFormat.Type == models.ResponseFormatJSONSchema
+}
+
+// completeStructured 校验第一个回答是否符合 schema，不符合时把错误发给模型要求修正，
+// 超过 structured.max_retries 次仍不符合时返回 ErrorSchemaMismatch。用量为所有请求的合计
+func (c chatGPT) completeStructured(ctx context.Context, req models.ReqChatGPTFromCient, run completeFunc) (*models.RespChatGPT, error) {
+	// 不支持原生结构化输出时会去掉 response_format，先按原始请求校验 n、stream 和 schema
+	checked := req
+	if _, err := models.CreateReqChatGPT(&checked); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	format := req.ResponseFormat.JSONSchema
+	schema, err := jsonschema.Compile(format.Schema)
+	if err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("response_format.json_schema.schema: " + err.Error())
+	}
+	model := req.Model
+	if model == "" {
+		model = models.DefaultChatModel
+	}
+	if !nativeStructured(model) {
+		req.ResponseFormat = nil
+		req.Message = append([]models.ChatGPTMessage{{Role: models.RoleSystem, Content: schemaInstruction(format)}}, req.Message...)
+	}
+	retries := config.GetIntDft("structured.max_retries", DefaultStructuredRetries)
+	var usage models.ChatUsage
+	for attempt := 0; ; attempt++ {
+		res, err := run(ctx, req)
+		if err != nil || len(res.Choices) == 0 {
+			return res, err
+		}
+		usage.PromptTokens += res.Usage.PromptTokens
+		usage.CompletionTokens += res.Usage.CompletionTokens
+		usage.TotalTokens += res.Usage.TotalTokens
+		res.Usage = usage
+		choice := &res.Choices[0]
+		// 调用客户端定义的工具时还没有最终回答
+		if choice.FinishReason == models.FinishReasonToolCalls {
+			return res, nil
+		}
+		problems, err := validateStructured(schema, choice.Message.Content)
+		if err != nil {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("response_format.json_schema.schema: " + err.Error())
+		}
+		if len(problems) == 0 {
+			choice.Parsed = parseStructured(choice.Message.Content)
+			return res, nil
+		}
+		log.WithCtxFields(ctx, log.Fields{"attempt": attempt, "problems": problems}).Warnln("structured output does not match the schema")
+		if attempt >= retries {
+			return nil, utils.ErrorSchemaMismatch.NewWithMsg("response does not match the schema: " + strings.Join(problems, "; "))
+		}
+		messages := make([]models.ChatGPTMessage, 0, len(req.Message)+2)
+		messages = append(messages, req.Message...)
+		req.Message = append(messages,
+			models.ChatGPTMessage{Role: models.RoleAssistant, Content: choice.Message.Content},
+			models.ChatGPTMessage{Role: models.RoleUser, Content: repairPrompt(problems)},
+		)
+	}
+}
+
+// nativeStructured 模型是否支持上游的结构化输出，见 structured.native_models
+func nativeStructured(model string) bool {
+	for _, prefix := range strings.Split(config.GetDft("structured.native_models", DefaultStructuredNativeModels), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaInstruction 不支持结构化输出的模型通过 system prompt 给出 schema
+func schemaInstruction(format *models.JSONSchema) string {
+	var b strings.Builder
+	b.WriteString("Reply with only a JSON value that conforms to the following JSON Schema, without code fences or any explanation.\n")
+	b.WriteString("Schema name: " + format.Name + "\n")
+	if format.Description != "" {
+		b.WriteString("Description: " + format.Description + "\n")
+	}
+	b.WriteString("JSON Schema:\n")
+	b.Write(format.Schema)
+	return b.String()
+}
+
+func repairPrompt(problems []string) string {
+	return "Your previous reply does not conform to the JSON Schema:\n- " + strings.Join(problems, "\n- ") +
+		"\nReply again with only the corrected JSON, without code fences or any explanation."
+}
+
+// validateStructured 返回内容不符合 schema 的原因，符合时为空。
+// schema 过于复杂、校验超过预算时返回错误，这是请求的问题，不再让模型修正
+func validateStructured(schema *jsonschema.Schema, content string) ([]string, error) {
+	_, errs, err := schema.ValidateJSON([]byte(stripFences(content)))
+	if errors.Is(err, jsonschema.ErrTooComplex) {
+		return nil, err
+	}
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	problems := make([]string, 0, len(errs))
+	for _, e := range errs {
+		problems = append(problems, e.Error())
+	}
+	return problems, nil
+}
+
+// parseStructured 回答中的 JSON，内容在审核替换或 PII 还原后不再是合法 JSON 时为 nil
+func parseStructured(content string) json.RawMessage {
+	data := []byte(stripFences(content))
+	if !json.Valid(data) {
+		return nil
+	}
+	return data
+}
+
+// stripFences 去掉模型有时仍会加上的 ```json 代码块
+func stripFences(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	if i := strings.IndexByte(content, '\n'); i >= 0 {
+		content = content[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
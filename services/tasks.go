@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+	zipkinUtil "meipian.cn/meigo/v2/util/zipkin"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultTaskAttempts = 3
+	DefaultTaskBackoff  = time.Second
+	DefaultTaskTimeout  = 10 * time.Minute
+	// 已完成任务的结果保留时间，期间重复投递直接返回保存的结果
+	DefaultTaskDedupeTTL = 24 * time.Hour
+)
+
+type Tasks interface {
+	// Process 执行校验过的任务，相同 ID 已经完成时返回保存的结果，不再调用上游
+	// 业务错误作为失败的结果返回；系统错误重试后仍失败时返回 error，不保存结果
+	Process(ctx context.Context, task models.ChatTask) (*models.ChatTaskResult, error)
+	// Attempts 每个任务最多执行的次数
+	Attempts() int
+}
+
+type tasks struct {
+	chatGPT  ChatGPT
+	store    repos.JobStore
+	attempts int
+	backoff  time.Duration
+	timeout  time.Duration
+	ttl      time.Duration
+}
+
+func NewTasks() Tasks {
+	attempts := config.GetIntDft("kafka.consumer.attempts", DefaultTaskAttempts)
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &tasks{
+		NewChatGPT(),
+		repos.NewTaskStore(),
+		attempts,
+		time.Duration(config.GetIntDft("kafka.consumer.backoff_ms", int(DefaultTaskBackoff/time.Millisecond))) * time.Millisecond,
+		time.Duration(config.GetIntDft("kafka.consumer.timeout_s", int(DefaultTaskTimeout/time.Second))) * time.Second,
+		time.Duration(config.GetIntDft("kafka.consumer.dedupe_ttl_s", int(DefaultTaskDedupeTTL/time.Second))) * time.Second,
+	}
+}
+
+func (t tasks) Attempts() int {
+	return t.attempts
+}
+
+func (t tasks) Process(ctx context.Context, task models.ChatTask) (*models.ChatTaskResult, error) {
+	// 任务状态与 /jobs 分开保存，未配置 redis 时只在本进程内去重
+	job, err := t.store.Get(ctx, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	if job != nil && job.Finished() {
+		log.WithCtxFields(ctx, log.Fields{"task": task.ID}).Infoln("duplicate task, reuse the saved result")
+		return toTaskResult(task.ID, job), nil
+	}
+
+	job = &models.Job{
+		ID:        task.ID,
+		Status:    models.JobRunning,
+		UserID:    task.Request.UserID,
+		CreatedAt: time.Now().UnixMilli(),
+		StartedAt: time.Now().UnixMilli(),
+	}
+	t.save(ctx, job)
+
+	req := task.Request
+	req.Caller = task.Caller
+	req.Stream = false
+	var res *models.RespChatGPT
+	backoff := t.backoff
+	for attempt := 1; ; attempt++ {
+		span, attemptCtx := zipkinUtil.ZipkinTracer.StartSpanFromContext(repos.WithAttempt(ctx, attempt), "task.attempt")
+		span.Tag(repos.TagAttempt, strconv.Itoa(attempt))
+		callCtx, cancel := context.WithTimeout(attemptCtx, t.timeout)
+		res, err = t.chatGPT.SendMsg(callCtx, req)
+		cancel()
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		span.Finish()
+		if err == nil || !retryable(err) {
+			break
+		}
+		log.WithCtxFields(ctx, log.Fields{"task": task.ID, "attempt": attempt, "error": err}).Warnln("task failed")
+		if attempt >= t.attempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	job.FinishedAt = time.Now().UnixMilli()
+	if err != nil {
+		job.Status = models.JobFailed
+		job.Error = errorDetail(err)
+	} else {
+		job.Status = models.JobSucceeded
+		job.Result = res
+	}
+	t.save(ctx, job)
+	return toTaskResult(task.ID, job), nil
+}
+
+func (t tasks) save(ctx context.Context, job *models.Job) {
+	if err := t.store.Save(ctx, job, t.ttl); err != nil {
+		log.WithCtxFields(ctx, log.Fields{"task": job.ID, "error": err}).Errorln("save task error")
+	}
+}
+
+// retryable 上游错误、服务繁忙和未知错误可以重试，参数、审核等业务错误重试也不会成功
+func retryable(err error) bool {
+	e, ok := err.(*utils.ServiceErr)
+	if !ok {
+		return true
+	}
+	return e.Code == utils.ErrorChatGPTError.Code || e.Code == utils.ErrorServiceBusy.Code
+}
+
+func toTaskResult(id string, job *models.Job) *models.ChatTaskResult {
+	return &models.ChatTaskResult{
+		ID:         id,
+		Status:     job.Status,
+		Response:   job.Result,
+		Error:      job.Error,
+		FinishedAt: job.FinishedAt,
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+	"chatgpt_server/vector"
+)
+
+const DefaultVectorMaxItems = 1000
+
+type Vectors interface {
+	Upsert(ctx context.Context, namespace string, req models.ReqVectorUpsert) (*models.RespVectorUpsert, error)
+	Delete(ctx context.Context, namespace string, req models.ReqVectorDelete) (*models.RespVectorDelete, error)
+	Query(ctx context.Context, namespace string, req models.ReqVectorQuery) (*models.RespVectorQuery, error)
+}
+
+type vectors struct {
+	embeddings Embeddings
+}
+
+func NewVectors() Vectors {
+	return &vectors{
+		NewEmbeddings(),
+	}
+}
+
+// embed 对 texts 生成向量
+func (v vectors) embed(ctx context.Context, userID int64, model string, texts []string) ([][]float32, error) {
+	req := models.ReqEmbeddingsFromClient{UserID: userID}
+	req.Model = model
+	req.Input = texts
+	res, err := v.embeddings.Create(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]float32, len(res.Data))
+	for i, data := range res.Data {
+		result[i] = data.Embedding
+	}
+	return result, nil
+}
+
+func (v vectors) Upsert(ctx context.Context, namespace string, req models.ReqVectorUpsert) (*models.RespVectorUpsert, error) {
+	if err := req.Validate(config.GetIntDft("vector.max_items", DefaultVectorMaxItems)); err != nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(err.Error())
+	}
+	items := make([]vector.Item, len(req.Items))
+	var (
+		texts   []string
+		indexes []int
+	)
+	for i, item := range req.Items {
+		items[i] = vector.Item{ID: item.ID, Vector: item.Vector, Metadata: item.Metadata}
+		if len(item.Vector) == 0 {
+			texts = append(texts, item.Text)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(texts) > 0 {
+		embedded, err := v.embed(ctx, req.UserID, req.Model, texts)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range indexes {
+			items[i].Vector = embedded[j]
+		}
+	}
+	store := vector.Default()
+	if err := store.Upsert(namespace, items); err != nil {
+		return nil, err
+	}
+	return &models.RespVectorUpsert{Upserted: len(items), Total: store.Count(namespace)}, nil
+}
+
+func (v vectors) Delete(ctx context.Context, namespace string, req models.ReqVectorDelete) (*models.RespVectorDelete, error) {
+	if len(req.IDs) == 0 {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("ids: is required")
+	}
+	store := vector.Default()
+	n, err := store.Delete(namespace, req.IDs)
+	if err != nil {
+		return nil, err
+	}
+	return &models.RespVectorDelete{Deleted: n, Total: store.Count(namespace)}, nil
+}
+
+func (v vectors) Query(ctx context.Context, namespace string, req models.ReqVectorQuery) (*models.RespVectorQuery, error) {
+	query := req.Vector
+	if len(query) == 0 {
+		if req.Text == "" {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("vector or text is required")
+		}
+		embedded, err := v.embed(ctx, req.UserID, req.Model, []string{req.Text})
+		if err != nil {
+			return nil, err
+		}
+		query = embedded[0]
+	}
+	matches, err := vector.Default().Query(namespace, query, req.TopK)
+	if err != nil {
+		return nil, err
+	}
+	res := &models.RespVectorQuery{Matches: make([]models.VectorMatch, len(matches))}
+	for i, match := range matches {
+		res.Matches[i] = models.VectorMatch{ID: match.ID, Score: match.Score, Metadata: match.Metadata}
+	}
+	return res, nil
+}
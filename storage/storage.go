@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"meipian.cn/meigo/v2/config"
+)
+
+const (
+	// DefaultPublicPath 本地存储的文件由本服务在该路径下提供访问
+	DefaultPublicPath = "/storage"
+)
+
+// Storage 保存生成的文件，返回可访问的地址
+type Storage interface {
+	// Put name 为相对路径，如 images/20060102/xxx.png
+	Put(ctx context.Context, name string, data []byte) (string, error)
+}
+
+// local 本地文件系统，用于开发环境或挂载了共享存储的部署，可替换为对象存储
+type local struct {
+	dir     string
+	baseURL string
+}
+
+// New 按 storage.* 配置返回存储，未配置 storage.dir 时返回 nil
+func New() Storage {
+	dir := LocalDir()
+	if dir == "" {
+		return nil
+	}
+	baseURL := config.GetDft("storage.base_url", DefaultPublicPath)
+	return &local{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// LocalDir 本地存储目录，为空时不提供文件访问
+func LocalDir() string {
+	return config.GetStr("storage.dir")
+}
+
+func (l local) Put(ctx context.Context, name string, data []byte) (string, error) {
+	name = path.Clean("/" + name)[1:]
+	if name == "" {
+		return "", errors.New("empty file name")
+	}
+	file := filepath.Join(l.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return l.baseURL + "/" + name, nil
+}
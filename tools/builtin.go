@@ -0,0 +1,177 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+)
+
+func init() {
+	Register(timeTool{})
+	Register(calculatorTool{})
+	Register(articleTool{internal: repos.NewInternal()})
+	Register(userProfileTool{internal: repos.NewInternal()})
+}
+
+// decodeArguments 模型给出的参数为空时按 {} 处理
+func decodeArguments(arguments string, v interface{}) error {
+	if strings.TrimSpace(arguments) == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(arguments), v); err != nil {
+		return errors.New("invalid arguments: " + err.Error())
+	}
+	return nil
+}
+
+func marshalResult(v interface{}) (string, error) {
+	body, err := json.Marshal(v)
+	return string(body), err
+}
+
+type timeTool struct {
+}
+
+func (timeTool) Definition() models.FunctionDef {
+	return models.FunctionDef{
+		Name:        "current_time",
+		Description: "Get the current date and time",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"timezone":{"type":"string","description":"IANA time zone, e.g. Asia/Shanghai"}}}`),
+	}
+}
+
+func (timeTool) Public() bool {
+	return true
+}
+
+func (timeTool) Run(ctx context.Context, caller Caller, arguments string) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if args.Timezone != "" {
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", errors.New("unknown timezone " + args.Timezone)
+		}
+		now = now.In(loc)
+	}
+	return marshalResult(map[string]string{
+		"time":     now.Format(time.RFC3339),
+		"weekday":  now.Weekday().String(),
+		"timezone": now.Location().String(),
+	})
+}
+
+type calculatorTool struct {
+}
+
+func (calculatorTool) Definition() models.FunctionDef {
+	return models.FunctionDef{
+		Name:        "calculator",
+		Description: "Evaluate an arithmetic expression with + - * / % ^ and parentheses",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"expression":{"type":"string","description":"e.g. (1+2)*3"}},"required":["expression"]}`),
+	}
+}
+
+func (calculatorTool) Public() bool {
+	return true
+}
+
+func (calculatorTool) Run(ctx context.Context, caller Caller, arguments string) (string, error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	value, err := evaluate(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return marshalResult(map[string]string{
+		"expression": args.Expression,
+		"result":     strconv.FormatFloat(value, 'g', -1, 64),
+	})
+}
+
+type articleTool struct {
+	internal repos.Internal
+}
+
+func (articleTool) Definition() models.FunctionDef {
+	return models.FunctionDef{
+		Name:        "article_lookup",
+		Description: "Look up an article by its ID",
+		Parameters: json.RawMessage(`{"type":"object","properties":{` +
+			`"id":{"type":"string","description":"article ID"}},"required":["id"]}`),
+	}
+}
+
+func (articleTool) Public() bool {
+	return false
+}
+
+func (t articleTool) Run(ctx context.Context, caller Caller, arguments string) (string, error) {
+	// 模型可能把 id 给成数字
+	var args struct {
+		ID interface{} `json:"id"`
+	}
+	if err := decodeArguments(arguments, &args); err != nil {
+		return "", err
+	}
+	var id string
+	switch v := args.ID.(type) {
+	case string:
+		id = strings.TrimSpace(v)
+	case float64:
+		id = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if id == "" {
+		return "", errors.New("id is required")
+	}
+	body, err := t.internal.Article(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// userProfileTool 只能查询请求中 user_id 对应的用户
+type userProfileTool struct {
+	internal repos.Internal
+}
+
+func (userProfileTool) Definition() models.FunctionDef {
+	return models.FunctionDef{
+		Name:        "user_profile",
+		Description: "Get the profile of the current user",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+	}
+}
+
+func (userProfileTool) Public() bool {
+	return false
+}
+
+func (t userProfileTool) Run(ctx context.Context, caller Caller, arguments string) (string, error) {
+	if caller.UserID <= 0 {
+		return "", errors.New("no user in the request")
+	}
+	body, err := t.internal.UserProfile(ctx, caller.UserID)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
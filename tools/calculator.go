@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+// evaluate 计算四则运算表达式，支持 + - * / % ^、括号和一元负号
+func evaluate(expr string) (float64, error) {
+	p := &parser{text: []rune(expr)}
+	value, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		return 0, fmt.Errorf("unexpected %q at %d", p.text[p.pos], p.pos)
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return value, nil
+}
+
+// maxDepth 括号和一元运算的最大嵌套层数
+const maxDepth = 64
+
+type parser struct {
+	text  []rune
+	pos   int
+	depth int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.text) && unicode.IsSpace(p.text[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek 跳过空白后的下一个字符，结束时为 0
+func (p *parser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+// expr = term { ("+" | "-") term }
+func (p *parser) expr() (float64, error) {
+	left, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+// term = power { ("*" | "/" | "%") power }
+func (p *parser) term() (float64, error) {
+	left, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.power()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+// power = unary [ "^" power ]，右结合
+func (p *parser) power() (float64, error) {
+	base, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	exp, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exp), nil
+}
+
+// unary = ("-" | "+") unary | primary
+func (p *parser) unary() (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return 0, errors.New("expression too deep")
+	}
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.unary()
+		return -value, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.primary()
+}
+
+// primary = number | "(" expr ")"
+func (p *parser) primary() (float64, error) {
+	switch r := p.peek(); {
+	case r == '(':
+		p.pos++
+		value, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("missing )")
+		}
+		p.pos++
+		return value, nil
+	case r == '.' || unicode.IsDigit(r):
+		start := p.pos
+		for p.pos < len(p.text) && (p.text[p.pos] == '.' || unicode.IsDigit(p.text[p.pos])) {
+			p.pos++
+		}
+		return strconv.ParseFloat(string(p.text[start:p.pos]), 64)
+	case r == 0:
+		return 0, errors.New("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected %q at %d", r, p.pos)
+	}
+}
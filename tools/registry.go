@@ -0,0 +1,176 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultTimeout  = 5 * time.Second
+	DefaultMaxSteps = 5
+	// 允许所有调用方
+	anyCaller = "*"
+)
+
+// Caller 调用方，Name 为已认证的调用方（见 auth.Caller），UserID 为该调用方在请求中给出的 user_id
+type Caller struct {
+	Name   string
+	UserID int64
+}
+
+// Tool 服务端执行的工具
+type Tool interface {
+	Definition() models.FunctionDef
+	// Public 未在 tools.permissions 中配置时是否允许所有调用方使用
+	Public() bool
+	// Run arguments 为模型给出的 JSON 参数，返回给模型的结果
+	Run(ctx context.Context, caller Caller, arguments string) (string, error)
+}
+
+var registry = struct {
+	tools map[string]Tool
+	sync.RWMutex
+}{tools: map[string]Tool{}}
+
+// Register 注册工具，同名的工具会被覆盖
+func Register(tool Tool) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.tools[tool.Definition().Name] = tool
+}
+
+func Lookup(name string) (Tool, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	tool, ok := registry.tools[name]
+	return tool, ok
+}
+
+// Names 所有已注册的工具名
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.tools))
+	for name := range registry.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// permissions tools.permissions 配置 "article:web|ios,user_profile:web"，* 为所有调用方
+func permissions() map[string][]string {
+	result := map[string][]string{}
+	for _, item := range strings.Split(config.GetStr("tools.permissions"), ",") {
+		name, callers, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			continue
+		}
+		result[strings.TrimSpace(name)] = strings.Split(callers, "|")
+	}
+	return result
+}
+
+// Allowed caller 是否可以使用 tool
+func Allowed(caller Caller, tool Tool) bool {
+	callers, ok := permissions()[tool.Definition().Name]
+	if !ok {
+		return tool.Public()
+	}
+	for _, name := range callers {
+		name = strings.TrimSpace(name)
+		if name == anyCaller || (name != "" && name == caller.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve 按名称取调用方请求的工具定义，未注册或无权使用时返回错误
+func Resolve(caller Caller, names []string) ([]models.Tool, error) {
+	defs := make([]models.Tool, 0, len(names))
+	for _, name := range names {
+		tool, ok := Lookup(name)
+		if !ok {
+			return nil, utils.ErrorParamsInvalid.NewWithMsg("server_tools: unknown tool " + name)
+		}
+		if !Allowed(caller, tool) {
+			return nil, utils.ErrorToolNotAllowed.NewWithMsg("无权使用工具 " + name)
+		}
+		defs = append(defs, models.Tool{Type: models.ToolTypeFunction, Function: tool.Definition()})
+	}
+	return defs, nil
+}
+
+// Timeout 单次工具调用的超时
+func Timeout() time.Duration {
+	return time.Duration(config.GetIntDft("tools.timeout_ms", int(DefaultTimeout/time.Millisecond))) * time.Millisecond
+}
+
+// MaxSteps 一次请求中最多执行工具的轮数
+func MaxSteps() int {
+	return config.GetIntDft("tools.max_steps", DefaultMaxSteps)
+}
+
+// errorResult 工具出错时返回给模型的结果，模型可以据此调整参数或直接回答
+func errorResult(err error) string {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(body)
+}
+
+// Execute 带超时执行一次工具调用，出错时结果为 {"error": "..."}
+func Execute(ctx context.Context, caller Caller, call models.ToolCall) models.ToolTrace {
+	trace := models.ToolTrace{
+		ID:        call.ID,
+		Name:      call.Function.Name,
+		Arguments: call.Function.Arguments,
+	}
+	start := time.Now()
+	defer func() {
+		trace.Duration = time.Since(start).Milliseconds()
+	}()
+	tool, ok := Lookup(call.Function.Name)
+	if !ok || !Allowed(caller, tool) {
+		err := utils.ErrorToolNotAllowed
+		trace.Error = err.Msg
+		trace.Result = errorResult(errors.New(err.Msg))
+		return trace
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout())
+	defer cancel()
+	type output struct {
+		result string
+		err    error
+	}
+	done := make(chan output, 1)
+	go func() {
+		result, err := tool.Run(ctx, caller, call.Function.Arguments)
+		done <- output{result, err}
+	}()
+	var out output
+	select {
+	case out = <-done:
+	case <-ctx.Done():
+		out.err = ctx.Err()
+	}
+	if errors.Is(out.err, context.DeadlineExceeded) {
+		out.err = errors.New("timeout")
+	}
+	if out.err != nil {
+		trace.Error = utils.GetErrorMsg(out.err)
+		trace.Result = errorResult(errors.New(trace.Error))
+		return trace
+	}
+	trace.Result = out.result
+	return trace
+}
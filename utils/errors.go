@@ -25,6 +25,51 @@ var (
 		Code: 500,
 		Msg:  "ChatGPT server error",
 	}
+	// 内容命中敏感词
+	ErrorSensitiveContent = &ServiceErr{
+		Code: 1201,
+		Msg:  "内容包含敏感信息",
+	}
+	// 上游审核接口判定违规
+	ErrorModerationFlagged = &ServiceErr{
+		Code: 1202,
+		Msg:  "内容未通过审核",
+	}
+	// 调用方令牌无效或过期
+	ErrorUnauthorized = &ServiceErr{
+		Code: 1401,
+		Msg:  "调用方身份无效",
+	}
+	// 资源不存在
+	ErrorNotFound = &ServiceErr{
+		Code: 1404,
+		Msg:  "资源不存在",
+	}
+	// 调用方无权使用该工具
+	ErrorToolNotAllowed = &ServiceErr{
+		Code: 1301,
+		Msg:  "无权使用该工具",
+	}
+	// 超出用户额度
+	ErrorQuotaExceeded = &ServiceErr{
+		Code: 1429,
+		Msg:  "超出使用额度",
+	}
+	// 任务队列已满
+	ErrorServiceBusy = &ServiceErr{
+		Code: 1503,
+		Msg:  "服务繁忙，请稍后重试",
+	}
+	// 工具调用轮数超过限制
+	ErrorToolStepsExceeded = &ServiceErr{
+		Code: 1302,
+		Msg:  "工具调用次数超过限制",
+	}
+	// 多次修正后回答仍不符合 JSON Schema
+	ErrorSchemaMismatch = &ServiceErr{
+		Code: 1303,
+		Msg:  "回答不符合 JSON Schema",
+	}
 )
 
 func (e *ServiceErr) NewWithMsg(msg string) error {
@@ -0,0 +1,305 @@
+package vector
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	DefaultHNSWM              = 16
+	DefaultHNSWEfConstruction = 200
+	DefaultHNSWEfSearch       = 64
+)
+
+// HNSWOptions HNSW 参数，见 https://arxiv.org/abs/1603.09320
+type HNSWOptions struct {
+	// 每个节点在每层的邻居数，第 0 层为 2M
+	M              int
+	EfConstruction int
+	EfSearch       int
+}
+
+type hnswNode struct {
+	item    Item
+	friends [][]int
+	deleted bool
+}
+
+// hnsw 删除和替换只标记旧节点，标记的节点超过一半时重建
+type hnsw struct {
+	opts      HNSWOptions
+	levelMult float64
+	nodes     []*hnswNode
+	// 未删除的 ID 对应的节点下标
+	ids      map[string]int
+	entry    int
+	maxLevel int
+	rand     *rand.Rand
+}
+
+func newHNSW(opts HNSWOptions) *hnsw {
+	if opts.M < 2 {
+		opts.M = DefaultHNSWM
+	}
+	if opts.EfConstruction <= 0 {
+		opts.EfConstruction = DefaultHNSWEfConstruction
+	}
+	if opts.EfSearch <= 0 {
+		opts.EfSearch = DefaultHNSWEfSearch
+	}
+	return &hnsw{
+		opts:      opts,
+		levelMult: 1 / math.Log(float64(opts.M)),
+		ids:       map[string]int{},
+		entry:     -1,
+		rand:      rand.New(rand.NewSource(1)),
+	}
+}
+
+func (h *hnsw) maxFriends(level int) int {
+	if level == 0 {
+		return 2 * h.opts.M
+	}
+	return h.opts.M
+}
+
+func (h *hnsw) Upsert(items ...Item) {
+	for _, item := range items {
+		if old, ok := h.ids[item.ID]; ok {
+			h.nodes[old].deleted = true
+		}
+		h.insert(item)
+	}
+	h.compact()
+}
+
+func (h *hnsw) Delete(ids ...string) int {
+	n := 0
+	for _, id := range ids {
+		if i, ok := h.ids[id]; ok {
+			h.nodes[i].deleted = true
+			delete(h.ids, id)
+			n++
+		}
+	}
+	h.compact()
+	return n
+}
+
+// compact 标记删除的节点超过一半时用剩下的向量重建图
+func (h *hnsw) compact() {
+	if len(h.nodes) < 2*DefaultHNSWM || len(h.ids)*2 > len(h.nodes) {
+		return
+	}
+	items := h.Items()
+	h.nodes, h.ids, h.entry, h.maxLevel = nil, map[string]int{}, -1, 0
+	for _, item := range items {
+		h.insert(item)
+	}
+}
+
+func (h *hnsw) insert(item Item) {
+	level := int(math.Floor(-math.Log(1-h.rand.Float64()) * h.levelMult))
+	node := &hnswNode{item: item, friends: make([][]int, level+1)}
+	index := len(h.nodes)
+	h.nodes = append(h.nodes, node)
+	h.ids[item.ID] = index
+	if h.entry < 0 {
+		h.entry, h.maxLevel = index, level
+		return
+	}
+	entry := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		entry = h.greedy(item.Vector, entry, l)
+	}
+	entries := []int{entry}
+	for l := minInt(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(item.Vector, entries, h.opts.EfConstruction, l)
+		neighbors := candidates
+		if len(neighbors) > h.maxFriends(l) {
+			neighbors = neighbors[:h.maxFriends(l)]
+		}
+		for _, c := range neighbors {
+			node.friends[l] = append(node.friends[l], c.index)
+			h.link(c.index, index, l)
+		}
+		entries = entries[:0]
+		for _, c := range candidates {
+			entries = append(entries, c.index)
+		}
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = index, level
+	}
+}
+
+// link 给 from 加一个邻居，超过上限时只保留最相似的
+func (h *hnsw) link(from, to, level int) {
+	node := h.nodes[from]
+	node.friends[level] = append(node.friends[level], to)
+	if len(node.friends[level]) <= h.maxFriends(level) {
+		return
+	}
+	candidates := make([]candidate, 0, len(node.friends[level]))
+	for _, i := range node.friends[level] {
+		candidates = append(candidates, candidate{i, dot(node.item.Vector, h.nodes[i].item.Vector)})
+	}
+	sortCandidates(candidates)
+	node.friends[level] = node.friends[level][:0]
+	for _, c := range candidates[:h.maxFriends(level)] {
+		node.friends[level] = append(node.friends[level], c.index)
+	}
+}
+
+// greedy 在一层中向更相似的邻居移动，直到没有更近的
+func (h *hnsw) greedy(vector []float32, entry, level int) int {
+	best := dot(vector, h.nodes[entry].item.Vector)
+	for changed := true; changed; {
+		changed = false
+		for _, i := range h.nodes[entry].friends[level] {
+			if score := dot(vector, h.nodes[i].item.Vector); score > best {
+				best, entry, changed = score, i, true
+			}
+		}
+	}
+	return entry
+}
+
+// searchLayer 在一层中搜索最相似的 ef 个节点，包括标记删除的节点，按相似度从高到低
+func (h *hnsw) searchLayer(vector []float32, entries []int, ef, level int) []candidate {
+	visited := make(map[int]bool, ef*4)
+	queue := &maxHeap{}
+	results := &minHeap{}
+	for _, i := range entries {
+		if visited[i] {
+			continue
+		}
+		visited[i] = true
+		c := candidate{i, dot(vector, h.nodes[i].item.Vector)}
+		heap.Push(queue, c)
+		heap.Push(results, c)
+	}
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(candidate)
+		if results.Len() >= ef && current.score < (*results)[0].score {
+			break
+		}
+		node := h.nodes[current.index]
+		if level >= len(node.friends) {
+			continue
+		}
+		for _, i := range node.friends[level] {
+			if visited[i] {
+				continue
+			}
+			visited[i] = true
+			c := candidate{i, dot(vector, h.nodes[i].item.Vector)}
+			if results.Len() < ef || c.score > (*results)[0].score {
+				heap.Push(queue, c)
+				heap.Push(results, c)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	candidates := make([]candidate, results.Len())
+	for i := len(candidates) - 1; i >= 0; i-- {
+		candidates[i] = heap.Pop(results).(candidate)
+	}
+	return candidates
+}
+
+func (h *hnsw) Query(vector []float32, k int) []Match {
+	if len(h.ids) == 0 || k <= 0 {
+		return nil
+	}
+	entry := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		entry = h.greedy(vector, entry, l)
+	}
+	// 标记删除的节点也会占用 ef，按比例放大
+	ef := maxInt(h.opts.EfSearch, k) * len(h.nodes) / len(h.ids)
+	matches := make([]Match, 0, k)
+	for _, c := range h.searchLayer(vector, []int{entry}, ef, 0) {
+		node := h.nodes[c.index]
+		if node.deleted {
+			continue
+		}
+		matches = append(matches, Match{ID: node.item.ID, Score: c.score, Metadata: node.item.Metadata})
+		if len(matches) == k {
+			break
+		}
+	}
+	sortMatches(matches)
+	return matches
+}
+
+func (h *hnsw) Len() int {
+	return len(h.ids)
+}
+
+func (h *hnsw) Items() []Item {
+	items := make([]Item, 0, len(h.ids))
+	for _, node := range h.nodes {
+		if !node.deleted {
+			items = append(items, node.item)
+		}
+	}
+	return items
+}
+
+type candidate struct {
+	index int
+	score float32
+}
+
+func sortCandidates(candidates []candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+}
+
+// maxHeap 相似度最高的在堆顶
+type maxHeap []candidate
+
+func (h maxHeap) Len() int            { return len(h) }
+func (h maxHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h maxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// minHeap 相似度最低的在堆顶
+type minHeap []candidate
+
+func (h minHeap) Len() int            { return len(h) }
+func (h minHeap) Less(i, j int) bool  { return h[i].score < h[j].score }
+func (h minHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,130 @@
+package vector
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	KindFlat = "flat"
+	KindHNSW = "hnsw"
+)
+
+// Item 索引中的一条向量，Metadata 随查询结果返回
+type Item struct {
+	ID       string
+	Vector   []float32
+	Metadata map[string]string
+}
+
+type Match struct {
+	ID       string            `json:"id"`
+	Score    float32           `json:"score"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Index 按余弦相似度查询的向量索引，不支持并发写，由 Store 加锁
+type Index interface {
+	// Upsert 写入向量，ID 已存在时替换，向量需已归一化
+	Upsert(items ...Item)
+	// Delete 删除向量，返回实际删除的数量
+	Delete(ids ...string) int
+	// Query 返回与 vector 最相似的 k 条，按相似度从高到低
+	Query(vector []float32, k int) []Match
+	Len() int
+	// Items 所有向量，用于持久化
+	Items() []Item
+}
+
+// newIndex kind 为 hnsw 时使用 HNSW，否则为暴力搜索的 flat 索引
+func newIndex(kind string, opts HNSWOptions) Index {
+	if kind == KindHNSW {
+		return newHNSW(opts)
+	}
+	return newFlat()
+}
+
+// Normalize 返回单位向量，零向量返回 nil
+func Normalize(vector []float32) []float32 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return nil
+	}
+	norm := float32(math.Sqrt(sum))
+	result := make([]float32, len(vector))
+	for i, v := range vector {
+		result[i] = v / norm
+	}
+	return result
+}
+
+// dot 两个单位向量的点积即余弦相似度
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+type flat struct {
+	items map[string]Item
+}
+
+func newFlat() *flat {
+	return &flat{items: map[string]Item{}}
+}
+
+func (f *flat) Upsert(items ...Item) {
+	for _, item := range items {
+		f.items[item.ID] = item
+	}
+}
+
+func (f *flat) Delete(ids ...string) int {
+	n := 0
+	for _, id := range ids {
+		if _, ok := f.items[id]; ok {
+			delete(f.items, id)
+			n++
+		}
+	}
+	return n
+}
+
+func (f *flat) Query(vector []float32, k int) []Match {
+	matches := make([]Match, 0, len(f.items))
+	for _, item := range f.items {
+		matches = append(matches, Match{ID: item.ID, Score: dot(vector, item.Vector), Metadata: item.Metadata})
+	}
+	sortMatches(matches)
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
+
+func (f *flat) Len() int {
+	return len(f.items)
+}
+
+func (f *flat) Items() []Item {
+	items := make([]Item, 0, len(f.items))
+	for _, item := range f.items {
+		items = append(items, item)
+	}
+	return items
+}
+
+// sortMatches 按相似度从高到低，相同时按 ID 排序保证结果稳定
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+}
@@ -0,0 +1,327 @@
+package vector
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"meipian.cn/meigo/v2/config"
+	"meipian.cn/meigo/v2/log"
+
+	"chatgpt_server/utils"
+)
+
+const (
+	DefaultFlushInterval = 5 * time.Second
+	DefaultTopK          = 10
+	MaxTopK              = 100
+
+	fileExt = ".gob"
+	// 知识库的数据写入 vector.dir 下的子目录
+	knowledgeDir = "knowledge"
+)
+
+var namespacePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// snapshot 持久化的内容，只保存向量，加载时重建索引
+type snapshot struct {
+	Dim   int
+	Items []Item
+}
+
+type namespace struct {
+	sync.RWMutex
+	index Index
+	// 向量维度，第一次写入时确定
+	dim   int
+	dirty bool
+}
+
+// Store 按 namespace 隔离的向量索引，dir 不为空时定期写入磁盘
+type Store struct {
+	dir  string
+	kind string
+	opts HNSWOptions
+
+	mu     sync.RWMutex
+	spaces map[string]*namespace
+}
+
+var (
+	defaultStore   = NewStore("", KindFlat, HNSWOptions{})
+	knowledgeStore = NewStore("", KindFlat, HNSWOptions{})
+)
+
+// InitStore 按 vector.* 配置创建默认 Store 和知识库 Store，加载 vector.dir 中已有的数据并定期写入
+func InitStore() {
+	dir := config.GetStr("vector.dir")
+	defaultStore = initStore(dir)
+	if dir != "" {
+		dir = filepath.Join(dir, knowledgeDir)
+	}
+	knowledgeStore = initStore(dir)
+}
+
+func initStore(dir string) *Store {
+	store := NewStore(dir, config.GetDft("vector.index", KindFlat), HNSWOptions{
+		M:              config.GetIntDft("vector.hnsw.m", DefaultHNSWM),
+		EfConstruction: config.GetIntDft("vector.hnsw.ef_construction", DefaultHNSWEfConstruction),
+		EfSearch:       config.GetIntDft("vector.hnsw.ef_search", DefaultHNSWEfSearch),
+	})
+	if err := store.Load(); err != nil {
+		log.Err("load vector store error: " + err.Error())
+	}
+	if store.dir != "" {
+		interval := time.Duration(config.GetIntDft("vector.flush_interval_s", int(DefaultFlushInterval/time.Second))) * time.Second
+		go store.flushLoop(interval)
+	}
+	return store
+}
+
+// Default InitStore 创建的 Store，/vectors 接口读写的数据，未初始化时为只在内存中的 flat 索引
+func Default() *Store {
+	return defaultStore
+}
+
+// Knowledge 知识库使用的 Store，与 Default 分开，/vectors 接口不能读写知识库的内容
+func Knowledge() *Store {
+	return knowledgeStore
+}
+
+func NewStore(dir, kind string, opts HNSWOptions) *Store {
+	return &Store{dir: dir, kind: kind, opts: opts, spaces: map[string]*namespace{}}
+}
+
+func validNamespace(name string) error {
+	if !namespacePattern.MatchString(name) {
+		return utils.ErrorParamsInvalid.NewWithMsg("namespace must match " + namespacePattern.String())
+	}
+	return nil
+}
+
+// get create 为 true 时不存在则创建
+func (s *Store) get(name string, create bool) (*namespace, error) {
+	if err := validNamespace(name); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	space, ok := s.spaces[name]
+	s.mu.RUnlock()
+	if ok || !create {
+		return space, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if space, ok = s.spaces[name]; !ok {
+		space = &namespace{index: newIndex(s.kind, s.opts)}
+		s.spaces[name] = space
+	}
+	return space, nil
+}
+
+// Upsert 写入向量，同一 namespace 中的向量维度必须相同
+func (s *Store) Upsert(name string, items []Item) error {
+	space, err := s.get(name, true)
+	if err != nil {
+		return err
+	}
+	normalized := make([]Item, 0, len(items))
+	for i, item := range items {
+		if item.ID == "" {
+			return utils.ErrorParamsInvalid.NewWithMsg(fmt.Sprintf("items[%d].id: is required", i))
+		}
+		vector := Normalize(item.Vector)
+		if vector == nil {
+			return utils.ErrorParamsInvalid.NewWithMsg(fmt.Sprintf("items[%d].vector: is empty or zero", i))
+		}
+		item.Vector = vector
+		normalized = append(normalized, item)
+	}
+	space.Lock()
+	defer space.Unlock()
+	dim := space.dim
+	if space.index.Len() == 0 {
+		dim = 0
+	}
+	for i, item := range normalized {
+		if dim == 0 {
+			dim = len(item.Vector)
+		}
+		if len(item.Vector) != dim {
+			return utils.ErrorParamsInvalid.NewWithMsg(fmt.Sprintf("items[%d].vector: dimension %d, expected %d", i, len(item.Vector), dim))
+		}
+	}
+	space.dim = dim
+	space.index.Upsert(normalized...)
+	space.dirty = true
+	return nil
+}
+
+// Delete 返回实际删除的数量，namespace 不存在时为 0
+func (s *Store) Delete(name string, ids []string) (int, error) {
+	space, err := s.get(name, false)
+	if err != nil || space == nil {
+		return 0, err
+	}
+	space.Lock()
+	defer space.Unlock()
+	n := space.index.Delete(ids...)
+	space.dirty = space.dirty || n > 0
+	return n, nil
+}
+
+// Query 返回最相似的 k 条，namespace 不存在时返回 ErrorNotFound
+func (s *Store) Query(name string, vector []float32, k int) ([]Match, error) {
+	space, err := s.get(name, false)
+	if err != nil {
+		return nil, err
+	}
+	if space == nil {
+		return nil, utils.ErrorNotFound.NewWithMsg("namespace " + name + " 不存在")
+	}
+	if k <= 0 {
+		k = DefaultTopK
+	}
+	if k > MaxTopK {
+		k = MaxTopK
+	}
+	vector = Normalize(vector)
+	if vector == nil {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg("vector: is empty or zero")
+	}
+	space.RLock()
+	defer space.RUnlock()
+	if space.index.Len() == 0 {
+		return []Match{}, nil
+	}
+	if len(vector) != space.dim {
+		return nil, utils.ErrorParamsInvalid.NewWithMsg(fmt.Sprintf("vector: dimension %d, expected %d", len(vector), space.dim))
+	}
+	return space.index.Query(vector, k), nil
+}
+
+// Items namespace 中的所有向量，不存在时为空
+func (s *Store) Items(name string) ([]Item, error) {
+	space, err := s.get(name, false)
+	if err != nil || space == nil {
+		return nil, err
+	}
+	space.RLock()
+	defer space.RUnlock()
+	return space.index.Items(), nil
+}
+
+// Count namespace 中的向量数
+func (s *Store) Count(name string) int {
+	space, err := s.get(name, false)
+	if err != nil || space == nil {
+		return 0
+	}
+	space.RLock()
+	defer space.RUnlock()
+	return space.index.Len()
+}
+
+// Load 从 dir 加载所有 namespace，dir 为空时不处理
+func (s *Store) Load() error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+fileExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), fileExt)
+		if validNamespace(name) != nil {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		var snap snapshot
+		err = gob.NewDecoder(f).Decode(&snap)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		index := newIndex(s.kind, s.opts)
+		index.Upsert(snap.Items...)
+		s.mu.Lock()
+		s.spaces[name] = &namespace{index: index, dim: snap.Dim}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Flush 把有改动的 namespace 写入磁盘，先写临时文件再改名
+func (s *Store) Flush() error {
+	if s.dir == "" {
+		return nil
+	}
+	s.mu.RLock()
+	names := make([]string, 0, len(s.spaces))
+	for name := range s.spaces {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		s.mu.RLock()
+		space := s.spaces[name]
+		s.mu.RUnlock()
+		space.Lock()
+		if !space.dirty {
+			space.Unlock()
+			continue
+		}
+		snap := snapshot{Dim: space.dim, Items: space.index.Items()}
+		space.dirty = false
+		space.Unlock()
+		if err := writeSnapshot(filepath.Join(s.dir, name+fileExt), snap); err != nil {
+			space.Lock()
+			space.dirty = true
+			space.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSnapshot(file string, snap snapshot) error {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (s *Store) flushLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.Flush(); err != nil {
+			log.Err("flush vector store error: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package vision
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+
+	"meipian.cn/meigo/v2/config"
+
+	"chatgpt_server/models"
+	"chatgpt_server/repos"
+)
+
+const (
+	DefaultMaxBytes  = 20 << 20
+	DefaultMaxSide   = 2048
+	DefaultMaxCount  = 10
+	DefaultMaxPixels = 4096 * 4096
+
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+	FormatWEBP = "webp"
+
+	dataURLPrefix = "data:"
+	base64Marker  = ";base64,"
+)
+
+// formats 上游支持的图片格式
+var formats = map[string]bool{FormatPNG: true, FormatJPEG: true, FormatGIF: true, FormatWEBP: true}
+
+// Options 图片处理配置
+type Options struct {
+	// 单张图片解码后的最大字节数
+	MaxBytes int64
+	// 单个请求最多的图片数
+	MaxCount int
+	// 宽高相乘的上限，缩小时按每像素 4 字节解码，默认约 64MB
+	MaxPixels int
+	// 是否在发给上游前缩小图片，只处理 data URL 和下载后的远程图片
+	Downscale bool
+	// 缩小后的最长边
+	MaxSide int
+	// 是否下载远程图片进行校验，下载后以 data URL 发给上游
+	FetchRemote bool
+}
+
+// GetOptions 读取 image.* 配置
+func GetOptions() Options {
+	return Options{
+		MaxBytes:    int64(config.GetIntDft("image.max_bytes", DefaultMaxBytes)),
+		MaxCount:    config.GetIntDft("image.max_count", DefaultMaxCount),
+		MaxPixels:   config.GetIntDft("image.max_pixels", DefaultMaxPixels),
+		Downscale:   config.GetBool("image.downscale", false),
+		MaxSide:     config.GetIntDft("image.max_side", DefaultMaxSide),
+		FetchRemote: config.GetBool("image.fetch_remote", false),
+	}
+}
+
+// Image 解码后的图片
+type Image struct {
+	Format string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// DataURL 以 data URL 表示图片
+func (img *Image) DataURL() string {
+	return dataURLPrefix + "image/" + img.Format + base64Marker + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// Prepare 校验 user 消息中的图片，按配置下载、缩小，返回估算的图片 token 数
+func Prepare(ctx context.Context, messages []models.ChatGPTMessage) (int, error) {
+	opts := GetOptions()
+	tokens, count := 0, 0
+	for i := range messages {
+		for j := range messages[i].Parts {
+			part := &messages[i].Parts[j]
+			if part.Type != models.ContentTypeImageURL {
+				continue
+			}
+			field := fmt.Sprintf("messages[%d].content[%d].image_url", i, j)
+			if messages[i].Role != models.RoleUser {
+				return 0, &models.FieldError{Field: field, Msg: "images are only allowed in user messages"}
+			}
+			if count++; count > opts.MaxCount {
+				return 0, &models.FieldError{Field: field, Msg: fmt.Sprintf("at most %d images per request", opts.MaxCount)}
+			}
+			n, err := prepareImage(ctx, part.ImageURL, opts)
+			if err != nil {
+				return 0, &models.FieldError{Field: field, Msg: err.Error()}
+			}
+			tokens += n
+		}
+	}
+	return tokens, nil
+}
+
+func prepareImage(ctx context.Context, img *models.ImageURL, opts Options) (int, error) {
+	if img == nil || img.URL == "" {
+		return 0, errors.New("url is required")
+	}
+	switch img.Detail {
+	case "", models.ImageDetailAuto, models.ImageDetailLow, models.ImageDetailHigh:
+	default:
+		return 0, fmt.Errorf("unknown detail %q", img.Detail)
+	}
+	var (
+		decoded *Image
+		err     error
+		// 远程图片下载后改为 data URL，上游不再访问原地址
+		rewrite bool
+	)
+	switch {
+	case strings.HasPrefix(img.URL, dataURLPrefix):
+		decoded, err = decodeDataURL(img.URL, opts)
+	case strings.HasPrefix(img.URL, "https://") || strings.HasPrefix(img.URL, "http://"):
+		if !opts.FetchRemote {
+			return Tokens(0, 0, img.Detail), nil
+		}
+		decoded, err = fetch(ctx, img.URL, opts)
+		rewrite = true
+	default:
+		return 0, errors.New("url must be http(s) or a base64 data URL")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if opts.Downscale {
+		resized, err := downscale(decoded, opts.MaxSide, opts.MaxPixels)
+		if err != nil {
+			return 0, err
+		}
+		rewrite = rewrite || resized != decoded
+		decoded = resized
+	}
+	if rewrite {
+		img.URL = decoded.DataURL()
+	}
+	return Tokens(decoded.Width, decoded.Height, img.Detail), nil
+}
+
+// decodeDataURL 解析 data:image/png;base64,... 格式
+func decodeDataURL(url string, opts Options) (*Image, error) {
+	meta, payload, ok := strings.Cut(strings.TrimPrefix(url, dataURLPrefix), ",")
+	if !ok || !strings.HasSuffix(meta+",", base64Marker) {
+		return nil, errors.New("data URL must be base64 encoded")
+	}
+	mime := strings.TrimSuffix(meta, ";base64")
+	if base64.StdEncoding.DecodedLen(len(payload)) > int(opts.MaxBytes)+3 {
+		return nil, fmt.Errorf("image exceeds %d bytes", opts.MaxBytes)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("invalid base64 data")
+	}
+	img, err := decode(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	if mime != "image/"+img.Format && !(mime == "image/jpg" && img.Format == FormatJPEG) {
+		return nil, fmt.Errorf("mime type %s does not match %s data", mime, img.Format)
+	}
+	return img, nil
+}
+
+// fetch 下载并校验远程图片
+func fetch(ctx context.Context, url string, opts Options) (*Image, error) {
+	data, _, err := repos.FetchURL(ctx, url, opts.MaxBytes)
+	if errors.Is(err, repos.ErrTooLarge) {
+		return nil, fmt.Errorf("image exceeds %d bytes", opts.MaxBytes)
+	}
+	if err != nil {
+		return nil, errors.New("failed to download image")
+	}
+	return decode(data, opts)
+}
+
+// decode 识别格式并读取宽高，不解码像素
+func decode(data []byte, opts Options) (*Image, error) {
+	if int64(len(data)) > opts.MaxBytes {
+		return nil, fmt.Errorf("image exceeds %d bytes", opts.MaxBytes)
+	}
+	format, width, height, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if !formats[format] {
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("invalid image size")
+	}
+	if width*height > opts.MaxPixels {
+		return nil, fmt.Errorf("image %dx%d exceeds %d pixels", width, height, opts.MaxPixels)
+	}
+	return &Image{Format: format, Width: width, Height: height, Data: data}, nil
+}
+
+func decodeConfig(data []byte) (string, int, int, error) {
+	if http.DetectContentType(data) == "image/webp" {
+		width, height, err := webpSize(data)
+		return FormatWEBP, width, height, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", 0, 0, errors.New("unsupported or corrupt image, expected png, jpeg, gif or webp")
+	}
+	return format, cfg.Width, cfg.Height, nil
+}
+
+// webpSize 从 RIFF 头中读取 webp 的宽高，标准库不支持解码 webp
+// https://developers.google.com/speed/webp/docs/riff_container
+func webpSize(data []byte) (int, int, error) {
+	invalid := errors.New("corrupt webp image")
+	if len(data) < 30 {
+		return 0, 0, invalid
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		width := int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16
+		height := int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16
+		return width + 1, height + 1, nil
+	case "VP8 ":
+		if chunk[3] != 0x9d || chunk[4] != 0x01 || chunk[5] != 0x2a {
+			return 0, 0, invalid
+		}
+		width := binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff
+		height := binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff
+		return int(width), int(height), nil
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return 0, 0, invalid
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	}
+	return 0, 0, invalid
+}
@@ -0,0 +1,89 @@
+package vision
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+const jpegQuality = 85
+
+// downscale 最长边超过 maxSide 时等比缩小，png/gif 输出 png，jpeg 输出 jpeg
+// 标准库不能解码 webp，webp 图片原样返回
+func downscale(img *Image, maxSide, maxPixels int) (*Image, error) {
+	if maxSide <= 0 || img.Format == FormatWEBP || (img.Width <= maxSide && img.Height <= maxSide) {
+		return img, nil
+	}
+	// 解码像素前再按图片头确认一次尺寸，解码占用的内存与宽高成正比
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, errors.New("failed to decode image")
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image %dx%d exceeds %d pixels", cfg.Width, cfg.Height, maxPixels)
+	}
+	src, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, errors.New("failed to decode image")
+	}
+	width, height := maxSide, img.Height*maxSide/img.Width
+	if img.Height > img.Width {
+		width, height = img.Width*maxSide/img.Height, maxSide
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := resize(src, width, height)
+	var buf bytes.Buffer
+	format := FormatPNG
+	if img.Format == FormatJPEG {
+		format = FormatJPEG
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Format: format, Width: width, Height: height, Data: buf.Bytes()}, nil
+}
+
+// resize 按面积平均缩小，每个目标像素取对应源区域的平均值
+func resize(src image.Image, width, height int) *image.NRGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := bounds.Min.Y + (y+1)*srcH/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := bounds.Min.X + (x+1)*srcW/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// RGBA() 返回预乘 alpha 的 16 位值
+			c := color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)}
+			dst.Set(x, y, c)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,52 @@
+package vision
+
+import "chatgpt_server/models"
+
+const (
+	// 图片 token 的计算方式见 https://platform.openai.com/docs/guides/vision
+	baseTokens     = 85
+	tileTokens     = 170
+	tileSize       = 512
+	maxFitSide     = 2048
+	maxShortSide   = 768
+	defaultImgSide = 1024
+)
+
+// Tokens 估算一张图片消耗的 token，宽高未知（远程图片未下载）时按 1024x1024 计算
+func Tokens(width, height int, detail string) int {
+	if detail == models.ImageDetailLow {
+		return baseTokens
+	}
+	if width <= 0 || height <= 0 {
+		width, height = defaultImgSide, defaultImgSide
+	}
+	w, h := float64(width), float64(height)
+	if w > maxFitSide || h > maxFitSide {
+		scale := maxFitSide / maxFloat(w, h)
+		w, h = w*scale, h*scale
+	}
+	if short := minFloat(w, h); short > maxShortSide {
+		scale := maxShortSide / short
+		w, h = w*scale, h*scale
+	}
+	tiles := ceilDiv(int(w+0.5), tileSize) * ceilDiv(int(h+0.5), tileSize)
+	return baseTokens + tileTokens*tiles
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}